@@ -0,0 +1,211 @@
+// Package bus is the publish/subscribe layer shared by swarmhub, the deployers
+// and ttl-enforcer. NATS is used in production and Memory stands in for it when
+// there is no broker.
+package bus
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrTimeout is returned by Queue.Next when no job arrived in time.
+var ErrTimeout = errors.New("bus: timeout")
+
+// WorkQueueSubjects are the subjects whose messages are jobs. A job is handed to
+// exactly one consumer of the queue and removed once it is acked.
+var WorkQueueSubjects = []string{"deployer.start"}
+
+// AckWait is how long a job handed out by a Queue may go without an Ack or an
+// InProgress before it is handed out again.
+var AckWait = 30 * time.Second
+
+// EventSubjects are the subjects whose messages are kept so they can be replayed.
+var EventSubjects = []string{"deployer.stop", "deployer.status", "deployer.output.>", "deployer.done"}
+
+// Bus publishes messages and hands them to subscribers.
+type Bus interface {
+	// Publish stores the message and delivers it to the subscribers of subject.
+	Publish(subject string, data []byte) error
+	// Subscribe calls handler for every message on subject, subject may contain
+	// the * and > wildcards. Without options only new messages are delivered.
+	Subscribe(subject string, handler Handler, opts ...SubscribeOption) (Subscription, error)
+	// Replay returns the messages of subject that are stored right now, oldest first.
+	Replay(subject string) ([]*Msg, error)
+	// Queue returns a consumer for the work queue subject. Consumers with the same
+	// durable name share the jobs.
+	Queue(subject string, durable string) (Queue, error)
+	// Truncated reports if stored messages of subject have been removed, either by
+	// limits or by a purge.
+	Truncated(subject string) (bool, error)
+	// Lock takes the lock name for owner, ok is false when it is held already. The
+	// lock is shared by everyone connected to the bus.
+	Lock(name string, owner string) (lock Lock, ok bool, err error)
+	Close() error
+}
+
+// LockTTL is how long a lock is held without being refreshed, so a holder that
+// died doesn't keep it.
+var LockTTL = time.Minute
+
+// Lock is a lock taken with Bus.Lock. It has to be refreshed within LockTTL.
+type Lock interface {
+	Refresh() error
+	Unlock() error
+}
+
+// Handler is called with every message of a subscription.
+type Handler func(m *Msg)
+
+// Subscription stops delivery to its handler once unsubscribed. Durable
+// subscriptions keep their position, Unsubscribe only stops this handler.
+type Subscription interface {
+	Unsubscribe() error
+}
+
+// Queue hands out the jobs of a work queue subject. A job stays in the queue until
+// it is acked, one that isn't acked within AckWait is handed out again, e.g. when
+// its consumer died.
+type Queue interface {
+	// Next waits up to timeout for the next job, ErrTimeout is returned when there
+	// was none.
+	Next(timeout time.Duration) (*Msg, error)
+}
+
+// Msg is a message received from the bus.
+type Msg struct {
+	Subject string
+	Data    []byte
+	// Sequence is the position of the message in the store, it only increases.
+	Sequence  uint64
+	Timestamp time.Time
+
+	ack        func() error
+	inProgress func() error
+}
+
+// Ack confirms the message was handled, it is only needed with ManualAck and for
+// jobs of a Queue.
+func (m *Msg) Ack() error {
+	if m.ack == nil {
+		return nil
+	}
+	return m.ack()
+}
+
+// InProgress tells the queue a job is still being worked on, which restarts its
+// AckWait. It does nothing for other messages.
+func (m *Msg) InProgress() error {
+	if m.inProgress == nil {
+		return nil
+	}
+	return m.inProgress()
+}
+
+type startPosition int
+
+const (
+	deliverNew startPosition = iota
+	deliverAll
+	startSequence
+	startTime
+)
+
+type subscribeOptions struct {
+	start     startPosition
+	sequence  uint64
+	time      time.Time
+	durable   string
+	queue     string
+	manualAck bool
+}
+
+// SubscribeOption changes where a subscription starts and how it acks.
+type SubscribeOption func(*subscribeOptions)
+
+// DeliverAll starts the subscription at the oldest stored message.
+func DeliverAll() SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.start = deliverAll
+	}
+}
+
+// DeliverNew only delivers messages published after subscribing.
+func DeliverNew() SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.start = deliverNew
+	}
+}
+
+// StartSequence starts the subscription at the message with the sequence.
+func StartSequence(sequence uint64) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.start = startSequence
+		o.sequence = sequence
+	}
+}
+
+// StartTime starts the subscription at the first message published at or after t.
+func StartTime(t time.Time) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.start = startTime
+		o.time = t
+	}
+}
+
+// Durable keeps the position of the subscription under name, a new subscription
+// with the same name continues where the last one stopped.
+func Durable(name string) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.durable = name
+	}
+}
+
+// QueueGroup shares the messages between the subscriptions of the group.
+func QueueGroup(group string) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.queue = group
+	}
+}
+
+// ManualAck leaves it to the handler to ack the message.
+func ManualAck() SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.manualAck = true
+	}
+}
+
+func newSubscribeOptions(opts []SubscribeOption) subscribeOptions {
+	var o subscribeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// subjectMatches reports if subject matches pattern using the NATS wildcards, *
+// for a single token and > for the rest of the subject.
+func subjectMatches(pattern string, subject string) bool {
+	for {
+		var p, s string
+		p, pattern = nextToken(pattern)
+		if p == ">" {
+			return subject != ""
+		}
+		s, subject = nextToken(subject)
+		if p == "" || s == "" {
+			return p == s
+		}
+		if p != "*" && p != s {
+			return false
+		}
+	}
+}
+
+func nextToken(subject string) (string, string) {
+	for i := 0; i < len(subject); i++ {
+		if subject[i] == '.' {
+			return subject[:i], subject[i+1:]
+		}
+	}
+	return subject, ""
+}
@@ -0,0 +1,399 @@
+package bus
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Memory is a Bus that keeps every message in memory. It has the same delivery
+// rules as NATS so the services can run and be tested without a broker.
+type Memory struct {
+	mu       sync.Mutex
+	idle     *sync.Cond
+	sequence uint64
+	events   []*Msg
+	jobs     []*memoryJob
+	// jobsChanged is closed and replaced whenever a job is published.
+	jobsChanged chan struct{}
+	subs        []*memorySubscription
+	// durables is the last acked sequence of every durable subscription.
+	durables map[string]uint64
+	// groups is the round robin position of every queue group.
+	groups map[string]int
+	// pending is the number of messages queued for or being handled by a subscription.
+	pending int
+	// locks are the locks that are held with the time they expire.
+	locks  map[string]*memoryLock
+	closed bool
+}
+
+// NewMemory creates an empty in-memory bus.
+func NewMemory() *Memory {
+	b := &Memory{
+		jobsChanged: make(chan struct{}),
+		durables:    make(map[string]uint64),
+		groups:      make(map[string]int),
+		locks:       make(map[string]*memoryLock),
+	}
+	b.idle = sync.NewCond(&b.mu)
+	return b
+}
+
+func isWorkQueueSubject(subject string) bool {
+	for _, pattern := range WorkQueueSubjects {
+		if subjectMatches(pattern, subject) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Memory) Publish(subject string, data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return fmt.Errorf("bus: closed")
+	}
+
+	b.sequence++
+	msg := &Msg{Subject: subject, Data: append([]byte(nil), data...), Sequence: b.sequence, Timestamp: time.Now()}
+
+	if isWorkQueueSubject(subject) {
+		b.jobs = append(b.jobs, &memoryJob{msg: msg})
+		close(b.jobsChanged)
+		b.jobsChanged = make(chan struct{})
+		return nil
+	}
+
+	b.events = append(b.events, msg)
+
+	var groups = make(map[string][]*memorySubscription)
+	for _, sub := range b.subs {
+		if !subjectMatches(sub.subject, subject) {
+			continue
+		}
+		if sub.opts.queue != "" {
+			groups[sub.opts.queue] = append(groups[sub.opts.queue], sub)
+			continue
+		}
+		sub.push(msg)
+	}
+
+	for group, subs := range groups {
+		next := b.groups[group] % len(subs)
+		b.groups[group] = next + 1
+		subs[next].push(msg)
+	}
+
+	return nil
+}
+
+func (b *Memory) Subscribe(subject string, handler Handler, opts ...SubscribeOption) (Subscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, fmt.Errorf("bus: closed")
+	}
+
+	sub := &memorySubscription{b: b, subject: subject, handler: handler, opts: newSubscribeOptions(opts), notify: make(chan struct{}, 1)}
+
+	acked, resume := b.durables[sub.opts.durable]
+	if sub.opts.durable != "" && !resume {
+		if sub.opts.start == deliverNew {
+			acked = b.sequence
+		}
+		b.durables[sub.opts.durable] = acked
+	}
+
+	for _, msg := range b.events {
+		if !subjectMatches(subject, msg.Subject) {
+			continue
+		}
+
+		deliver := false
+		switch {
+		case resume:
+			deliver = msg.Sequence > acked
+		case sub.opts.start == deliverAll:
+			deliver = true
+		case sub.opts.start == startSequence:
+			deliver = msg.Sequence >= sub.opts.sequence
+		case sub.opts.start == startTime:
+			deliver = !msg.Timestamp.Before(sub.opts.time)
+		}
+
+		if deliver {
+			sub.push(msg)
+		}
+	}
+
+	b.subs = append(b.subs, sub)
+	go sub.run()
+
+	return sub, nil
+}
+
+func (b *Memory) Replay(subject string) ([]*Msg, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var msgs []*Msg
+	for _, msg := range b.events {
+		if subjectMatches(subject, msg.Subject) {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, nil
+}
+
+func (b *Memory) Queue(subject string, durable string) (Queue, error) {
+	return memoryQueue{b: b, subject: subject}, nil
+}
+
+// Truncated is always false, nothing is ever removed from memory.
+func (b *Memory) Truncated(subject string) (bool, error) {
+	return false, nil
+}
+
+func (b *Memory) Lock(name string, owner string) (Lock, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if held, ok := b.locks[name]; ok && time.Now().Before(held.expires) {
+		return nil, false, nil
+	}
+	lock := &memoryLock{b: b, name: name, expires: time.Now().Add(LockTTL)}
+	b.locks[name] = lock
+	return lock, true, nil
+}
+
+// Flush waits until every published message has been handled by its subscriptions.
+func (b *Memory) Flush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.pending > 0 {
+		b.idle.Wait()
+	}
+}
+
+func (b *Memory) Close() error {
+	b.mu.Lock()
+	subs := b.subs
+	b.closed = true
+	b.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.Unsubscribe()
+	}
+	return nil
+}
+
+func (b *Memory) ack(durable string, sequence uint64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if sequence > b.durables[durable] {
+		b.durables[durable] = sequence
+	}
+	return nil
+}
+
+type memorySubscription struct {
+	b       *Memory
+	subject string
+	handler Handler
+	opts    subscribeOptions
+	// queue and stopped are guarded by b.mu
+	queue   []*Msg
+	stopped bool
+	notify  chan struct{}
+}
+
+// push queues the message for the subscription, b.mu must be held.
+func (s *memorySubscription) push(msg *Msg) {
+	delivery := *msg
+	if s.opts.durable != "" {
+		durable := s.opts.durable
+		sequence := msg.Sequence
+		delivery.ack = func() error { return s.b.ack(durable, sequence) }
+	}
+
+	s.queue = append(s.queue, &delivery)
+	s.b.pending++
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *memorySubscription) run() {
+	for {
+		s.b.mu.Lock()
+		if s.stopped {
+			s.b.mu.Unlock()
+			return
+		}
+		if len(s.queue) == 0 {
+			s.b.mu.Unlock()
+			<-s.notify
+			continue
+		}
+		msg := s.queue[0]
+		s.queue = s.queue[1:]
+		s.b.mu.Unlock()
+
+		s.handler(msg)
+		if s.opts.durable != "" && !s.opts.manualAck {
+			msg.Ack()
+		}
+
+		s.b.mu.Lock()
+		s.b.pending--
+		if s.b.pending == 0 {
+			s.b.idle.Broadcast()
+		}
+		s.b.mu.Unlock()
+	}
+}
+
+func (s *memorySubscription) Unsubscribe() error {
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+
+	if s.stopped {
+		return nil
+	}
+	s.stopped = true
+	s.b.pending -= len(s.queue)
+	s.queue = nil
+	if s.b.pending == 0 {
+		s.b.idle.Broadcast()
+	}
+
+	for i, sub := range s.b.subs {
+		if sub == s {
+			s.b.subs = append(s.b.subs[:i], s.b.subs[i+1:]...)
+			break
+		}
+	}
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// memoryLock is held while it is the lock of its name and hasn't expired, its
+// fields are guarded by b.mu.
+type memoryLock struct {
+	b       *Memory
+	name    string
+	expires time.Time
+}
+
+func (l *memoryLock) held() bool {
+	return l.b.locks[l.name] == l && time.Now().Before(l.expires)
+}
+
+func (l *memoryLock) Refresh() error {
+	l.b.mu.Lock()
+	defer l.b.mu.Unlock()
+	if !l.held() {
+		return fmt.Errorf("lock %v is no longer held", l.name)
+	}
+	l.expires = time.Now().Add(LockTTL)
+	return nil
+}
+
+func (l *memoryLock) Unlock() error {
+	l.b.mu.Lock()
+	defer l.b.mu.Unlock()
+	if !l.held() {
+		return fmt.Errorf("lock %v is no longer held", l.name)
+	}
+	delete(l.b.locks, l.name)
+	return nil
+}
+
+// memoryJob is a job that hasn't been acked yet. deadline is when it is handed out
+// again, it is zero until it is handed out the first time.
+type memoryJob struct {
+	msg      *Msg
+	deadline time.Time
+}
+
+type memoryQueue struct {
+	b       *Memory
+	subject string
+}
+
+// Next hands out the oldest job of the subject that is not being worked on, or
+// whose AckWait ran out.
+func (q memoryQueue) Next(timeout time.Duration) (*Msg, error) {
+	deadline := time.After(timeout)
+	for {
+		q.b.mu.Lock()
+		now := time.Now()
+		var redeliver time.Time
+		for _, job := range q.b.jobs {
+			if !subjectMatches(q.subject, job.msg.Subject) {
+				continue
+			}
+			if job.deadline.IsZero() || !now.Before(job.deadline) {
+				job.deadline = now.Add(AckWait)
+				q.b.mu.Unlock()
+				return q.b.deliverJob(job), nil
+			}
+			if redeliver.IsZero() || job.deadline.Before(redeliver) {
+				redeliver = job.deadline
+			}
+		}
+		changed := q.b.jobsChanged
+		q.b.mu.Unlock()
+
+		var expired <-chan time.Time
+		var timer *time.Timer
+		if !redeliver.IsZero() {
+			timer = time.NewTimer(time.Until(redeliver))
+			expired = timer.C
+		}
+		select {
+		case <-changed:
+		case <-expired:
+		case <-deadline:
+			return nil, ErrTimeout
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// deliverJob returns the message of the job, acking it removes the job and
+// InProgress restarts its AckWait.
+func (b *Memory) deliverJob(job *memoryJob) *Msg {
+	delivery := *job.msg
+	delivery.ack = func() error {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for i, j := range b.jobs {
+			if j == job {
+				b.jobs = append(b.jobs[:i], b.jobs[i+1:]...)
+				break
+			}
+		}
+		return nil
+	}
+	delivery.inProgress = func() error {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if !job.deadline.IsZero() {
+			job.deadline = time.Now().Add(AckWait)
+		}
+		return nil
+	}
+	return &delivery
+}
@@ -0,0 +1,320 @@
+package bus
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// collector records the data of the messages handed to it.
+type collector struct {
+	mu   sync.Mutex
+	data []string
+}
+
+func (c *collector) handle(m *Msg) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data = append(c.data, string(m.Data))
+}
+
+func (c *collector) got() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.data...)
+}
+
+func equal(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func publish(t *testing.T, b Bus, subject string, data ...string) {
+	for _, d := range data {
+		err := b.Publish(subject, []byte(d))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSubjectMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		subject string
+		want    bool
+	}{
+		{"deployer.status", "deployer.status", true},
+		{"deployer.status", "deployer.stop", false},
+		{"deployer.*", "deployer.status", true},
+		{"deployer.*", "deployer.output.job", false},
+		{"deployer.output.>", "deployer.output.job", true},
+		{"deployer.output.>", "deployer.output.job.more", true},
+		{"deployer.output.>", "deployer.output", false},
+		{"deployer.status", "deployer", false},
+	}
+	for _, test := range tests {
+		got := subjectMatches(test.pattern, test.subject)
+		if got != test.want {
+			t.Errorf("subjectMatches(%q, %q) = %v, want %v", test.pattern, test.subject, got, test.want)
+		}
+	}
+}
+
+func TestMemorySubscribeStart(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []SubscribeOption
+		want []string
+	}{
+		{"new", nil, []string{"c"}},
+		{"all", []SubscribeOption{DeliverAll()}, []string{"a", "b", "c"}},
+		{"sequence", []SubscribeOption{StartSequence(2)}, []string{"b", "c"}},
+	}
+	for _, test := range tests {
+		b := NewMemory()
+		publish(t, b, "deployer.status", "a", "b")
+		publish(t, b, "deployer.stop", "other")
+
+		var c collector
+		_, err := b.Subscribe("deployer.status", c.handle, test.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		publish(t, b, "deployer.status", "c")
+		b.Flush()
+
+		if got := c.got(); !equal(got, test.want) {
+			t.Errorf("%v: got %v, want %v", test.name, got, test.want)
+		}
+		b.Close()
+	}
+}
+
+func TestMemoryDurableResumes(t *testing.T) {
+	b := NewMemory()
+	defer b.Close()
+
+	var first collector
+	sub, err := b.Subscribe("deployer.done", first.handle, Durable("archive"), DeliverAll())
+	if err != nil {
+		t.Fatal(err)
+	}
+	publish(t, b, "deployer.done", "a", "b")
+	b.Flush()
+	sub.Unsubscribe()
+
+	publish(t, b, "deployer.done", "c")
+
+	var second collector
+	_, err = b.Subscribe("deployer.done", second.handle, Durable("archive"), DeliverAll())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Flush()
+
+	if got := first.got(); !equal(got, []string{"a", "b"}) {
+		t.Errorf("first subscription got %v", got)
+	}
+	if got := second.got(); !equal(got, []string{"c"}) {
+		t.Errorf("resumed subscription got %v, want only what it missed", got)
+	}
+}
+
+func TestMemoryManualAckRedelivers(t *testing.T) {
+	b := NewMemory()
+	defer b.Close()
+
+	var first collector
+	sub, err := b.Subscribe("deployer.done", func(m *Msg) {
+		first.handle(m)
+		if string(m.Data) == "a" {
+			m.Ack()
+		}
+	}, Durable("archive"), ManualAck(), DeliverAll())
+	if err != nil {
+		t.Fatal(err)
+	}
+	publish(t, b, "deployer.done", "a", "b")
+	b.Flush()
+	sub.Unsubscribe()
+
+	var second collector
+	_, err = b.Subscribe("deployer.done", second.handle, Durable("archive"), ManualAck(), DeliverAll())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Flush()
+
+	if got := second.got(); !equal(got, []string{"b"}) {
+		t.Errorf("got %v after resuming, want the message that wasn't acked", got)
+	}
+}
+
+func TestMemoryQueueGroup(t *testing.T) {
+	b := NewMemory()
+	defer b.Close()
+
+	var one, two collector
+	for _, c := range []*collector{&one, &two} {
+		_, err := b.Subscribe("deployer.status", c.handle, QueueGroup("swarmhub"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	publish(t, b, "deployer.status", "a", "b", "c", "d")
+	b.Flush()
+
+	if len(one.got()) != 2 || len(two.got()) != 2 {
+		t.Errorf("messages were not shared by the group: %v and %v", one.got(), two.got())
+	}
+}
+
+func TestMemoryReplay(t *testing.T) {
+	b := NewMemory()
+	defer b.Close()
+
+	publish(t, b, "deployer.output.1", "a")
+	publish(t, b, "deployer.output.2", "b")
+	publish(t, b, "deployer.status", "c")
+
+	msgs, err := b.Replay("deployer.output.>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, m := range msgs {
+		got = append(got, string(m.Data))
+	}
+	if !equal(got, []string{"a", "b"}) {
+		t.Errorf("replayed %v", got)
+	}
+}
+
+func TestMemoryQueue(t *testing.T) {
+	defer func(ackWait time.Duration) { AckWait = ackWait }(AckWait)
+	AckWait = 100 * time.Millisecond
+
+	b := NewMemory()
+	defer b.Close()
+	q, err := b.Queue("deployer.start", "deployer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = q.Next(10 * time.Millisecond)
+	if err != ErrTimeout {
+		t.Fatalf("empty queue returned %v, want ErrTimeout", err)
+	}
+
+	publish(t, b, "deployer.start", "a")
+	first, err := q.Next(time.Second)
+	if err != nil || string(first.Data) != "a" {
+		t.Fatalf("got %v, %v", first, err)
+	}
+
+	_, err = q.Next(50 * time.Millisecond)
+	if err != ErrTimeout {
+		t.Fatal("a job was handed out twice within AckWait")
+	}
+
+	redelivered, err := q.Next(time.Second)
+	if err != nil || string(redelivered.Data) != "a" {
+		t.Fatalf("job was not handed out again after AckWait: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		time.Sleep(60 * time.Millisecond)
+		redelivered.InProgress()
+	}
+	_, err = q.Next(60 * time.Millisecond)
+	if err != ErrTimeout {
+		t.Fatal("a job in progress was handed out again")
+	}
+
+	redelivered.Ack()
+	_, err = q.Next(2 * AckWait)
+	if err != ErrTimeout {
+		t.Fatal("an acked job was handed out again")
+	}
+}
+
+func TestMemoryQueueWakesOnPublish(t *testing.T) {
+	b := NewMemory()
+	defer b.Close()
+	q, err := b.Queue("deployer.start", "deployer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		b.Publish("deployer.start", []byte("a"))
+	}()
+
+	msg, err := q.Next(time.Second)
+	if err != nil || string(msg.Data) != "a" {
+		t.Fatalf("got %v, %v", msg, err)
+	}
+}
+
+func TestMemoryLock(t *testing.T) {
+	defer func(ttl time.Duration) { LockTTL = ttl }(LockTTL)
+	LockTTL = 50 * time.Millisecond
+
+	b := NewMemory()
+	defer b.Close()
+
+	lock, ok, err := b.Lock("grid-1", "deployer-a")
+	if err != nil || !ok {
+		t.Fatalf("first lock: %v, %v", ok, err)
+	}
+
+	_, ok, _ = b.Lock("grid-1", "deployer-b")
+	if ok {
+		t.Fatal("a held lock was taken again")
+	}
+	_, ok, _ = b.Lock("grid-2", "deployer-b")
+	if !ok {
+		t.Fatal("locks of other names are independent")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	err = lock.Refresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	_, ok, _ = b.Lock("grid-1", "deployer-b")
+	if ok {
+		t.Fatal("a refreshed lock expired")
+	}
+
+	err = lock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	taken, ok, _ := b.Lock("grid-1", "deployer-b")
+	if !ok {
+		t.Fatal("an unlocked lock could not be taken")
+	}
+
+	time.Sleep(2 * LockTTL)
+	if taken.Refresh() == nil {
+		t.Error("an expired lock could be refreshed")
+	}
+	_, ok, _ = b.Lock("grid-1", "deployer-a")
+	if !ok {
+		t.Error("an expired lock could not be taken")
+	}
+	if taken.Unlock() == nil {
+		t.Error("a lock taken over by another owner could be unlocked")
+	}
+}
@@ -0,0 +1,326 @@
+package bus
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	nats "github.com/nats-io/nats.go"
+)
+
+// jobsStream holds the jobs for the deployers. It is a work queue so every job is
+// handed to exactly one deployer and removed once that deployer acks it.
+var jobsStream = &nats.StreamConfig{
+	Name:      "DEPLOYER_JOBS",
+	Subjects:  WorkQueueSubjects,
+	Retention: nats.WorkQueuePolicy,
+	Storage:   nats.FileStorage,
+}
+
+// eventsStream keeps everything the deployers report, so the output of a
+// deployment can be replayed after it has finished.
+var eventsStream = &nats.StreamConfig{
+	Name:      "DEPLOYER_EVENTS",
+	Subjects:  EventSubjects,
+	Retention: nats.LimitsPolicy,
+	Storage:   nats.FileStorage,
+}
+
+// locksBucket holds the locks taken with Lock, a key is removed LockTTL after it
+// was last written.
+const locksBucket = "SWARMHUB_LOCKS"
+
+// ReplayTimeout is how long Replay waits on the next stored message.
+var ReplayTimeout = 5 * time.Second
+
+// NATS is a Bus backed by JetStream.
+type NATS struct {
+	nc    *nats.Conn
+	js    nats.JetStreamContext
+	locks nats.KeyValue
+}
+
+// Connect connects to the nats server at url and creates the streams when they
+// don't exist yet. name identifies the connection on the server.
+func Connect(url string, name string) (*NATS, error) {
+	nc, err := nats.Connect(url, nats.Name(name))
+	if err != nil {
+		err = fmt.Errorf("unable to connect to nats: %v", err)
+		return nil, err
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		nc.Close()
+		err = fmt.Errorf("unable to get jetstream context: %v", err)
+		return nil, err
+	}
+
+	b := &NATS{nc: nc, js: js}
+	err = b.addStreams()
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (b *NATS) addStreams() error {
+	for _, stream := range []*nats.StreamConfig{jobsStream, eventsStream} {
+		_, err := b.js.StreamInfo(stream.Name)
+		if err == nil {
+			continue
+		}
+		if err != nats.ErrStreamNotFound {
+			err = fmt.Errorf("unable to get stream %v: %v", stream.Name, err)
+			return err
+		}
+
+		_, err = b.js.AddStream(stream)
+		if err != nil {
+			err = fmt.Errorf("unable to add stream %v: %v", stream.Name, err)
+			return err
+		}
+	}
+
+	var err error
+	b.locks, err = b.js.KeyValue(locksBucket)
+	if err == nats.ErrBucketNotFound {
+		b.locks, err = b.js.CreateKeyValue(&nats.KeyValueConfig{Bucket: locksBucket, TTL: LockTTL, Storage: nats.FileStorage})
+	}
+	if err != nil {
+		err = fmt.Errorf("unable to get bucket %v: %v", locksBucket, err)
+		return err
+	}
+	return nil
+}
+
+func (b *NATS) Publish(subject string, data []byte) error {
+	_, err := b.js.Publish(subject, data)
+	return err
+}
+
+func (b *NATS) Subscribe(subject string, handler Handler, opts ...SubscribeOption) (Subscription, error) {
+	o := newSubscribeOptions(opts)
+
+	var natsOpts []nats.SubOpt
+	switch o.start {
+	case deliverAll:
+		natsOpts = append(natsOpts, nats.DeliverAll())
+	case startSequence:
+		natsOpts = append(natsOpts, nats.StartSequence(o.sequence))
+	case startTime:
+		natsOpts = append(natsOpts, nats.StartTime(o.time))
+	default:
+		natsOpts = append(natsOpts, nats.DeliverNew())
+	}
+
+	if o.durable != "" {
+		natsOpts = append(natsOpts, nats.Durable(o.durable))
+	}
+
+	// durable consumers are acked here so a stopped handler leaves its messages
+	// for the next subscription
+	if o.durable != "" || o.manualAck {
+		natsOpts = append(natsOpts, nats.ManualAck())
+	} else {
+		natsOpts = append(natsOpts, nats.AckNone())
+	}
+
+	s := &natsSubscription{durable: o.durable != ""}
+	natsHandler := func(m *nats.Msg) {
+		if s.isStopped() {
+			return
+		}
+		handler(fromNats(m))
+		if o.durable != "" && !o.manualAck {
+			m.Ack()
+		}
+	}
+
+	var err error
+	if o.queue != "" {
+		s.sub, err = b.js.QueueSubscribe(subject, o.queue, natsHandler, natsOpts...)
+	} else {
+		s.sub, err = b.js.Subscribe(subject, natsHandler, natsOpts...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (b *NATS) Replay(subject string) ([]*Msg, error) {
+	var msgs []*Msg
+
+	sub, err := b.js.SubscribeSync(subject, nats.DeliverAll(), nats.AckNone())
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Unsubscribe()
+
+	info, err := sub.ConsumerInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	if info.NumPending == 0 && info.Delivered.Consumer == 0 {
+		return nil, nil
+	}
+
+	for {
+		m, err := sub.NextMsg(ReplayTimeout)
+		if err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, fromNats(m))
+
+		meta, err := m.Metadata()
+		if err != nil || meta.NumPending == 0 {
+			return msgs, err
+		}
+	}
+}
+
+func (b *NATS) Queue(subject string, durable string) (Queue, error) {
+	sub, err := b.js.PullSubscribe(subject, durable, nats.AckWait(AckWait))
+	if err != nil {
+		return nil, err
+	}
+	return natsQueue{sub: sub}, nil
+}
+
+func (b *NATS) Truncated(subject string) (bool, error) {
+	stream := streamFor(subject)
+	if stream == nil {
+		return true, fmt.Errorf("no stream for subject %v", subject)
+	}
+
+	info, err := b.js.StreamInfo(stream.Name)
+	if err != nil {
+		return true, err
+	}
+	return info.State.FirstSeq > 1, nil
+}
+
+func streamFor(subject string) *nats.StreamConfig {
+	for _, stream := range []*nats.StreamConfig{jobsStream, eventsStream} {
+		for _, pattern := range stream.Subjects {
+			if subjectMatches(pattern, subject) {
+				return stream
+			}
+		}
+	}
+	return nil
+}
+
+// Lock creates the key name in the locks bucket, which only succeeds when it
+// doesn't exist or was deleted.
+func (b *NATS) Lock(name string, owner string) (Lock, bool, error) {
+	revision, err := b.locks.Create(name, []byte(owner))
+	if err == nil {
+		return &natsLock{kv: b.locks, name: name, owner: owner, revision: revision}, true, nil
+	}
+
+	_, getErr := b.locks.Get(name)
+	if getErr == nil {
+		return nil, false, nil
+	}
+	if getErr != nats.ErrKeyNotFound && getErr != nats.ErrKeyDeleted {
+		err = getErr
+	}
+	return nil, false, fmt.Errorf("unable to take lock %v: %v", name, err)
+}
+
+// Close closes the connection. Durable consumers are left on the server.
+func (b *NATS) Close() error {
+	b.nc.Close()
+	return nil
+}
+
+type natsSubscription struct {
+	sub     *nats.Subscription
+	durable bool
+
+	mu      sync.Mutex
+	stopped bool
+}
+
+func (s *natsSubscription) isStopped() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stopped
+}
+
+// Unsubscribe removes an ephemeral consumer. The library deletes consumers it
+// created on unsubscribe, so a durable subscription only stops calling its handler
+// and the consumer stays on the server for the next subscription with its name.
+func (s *natsSubscription) Unsubscribe() error {
+	s.mu.Lock()
+	s.stopped = true
+	s.mu.Unlock()
+
+	if s.durable {
+		return nil
+	}
+	return s.sub.Unsubscribe()
+}
+
+// natsLock is held as long as the key has the revision it wrote last.
+type natsLock struct {
+	kv       nats.KeyValue
+	name     string
+	owner    string
+	mu       sync.Mutex
+	revision uint64
+}
+
+func (l *natsLock) Refresh() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	revision, err := l.kv.Update(l.name, []byte(l.owner), l.revision)
+	if err != nil {
+		return fmt.Errorf("unable to refresh lock %v: %v", l.name, err)
+	}
+	l.revision = revision
+	return nil
+}
+
+func (l *natsLock) Unlock() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := l.kv.Delete(l.name, nats.LastRevision(l.revision))
+	if err != nil {
+		return fmt.Errorf("unable to release lock %v: %v", l.name, err)
+	}
+	return nil
+}
+
+type natsQueue struct {
+	sub *nats.Subscription
+}
+
+func (q natsQueue) Next(timeout time.Duration) (*Msg, error) {
+	msgs, err := q.sub.Fetch(1, nats.MaxWait(timeout))
+	if err == nats.ErrTimeout {
+		return nil, ErrTimeout
+	}
+	if err != nil {
+		return nil, err
+	}
+	msg := fromNats(msgs[0])
+	msg.inProgress = func() error { return msgs[0].InProgress() }
+	return msg, nil
+}
+
+func fromNats(m *nats.Msg) *Msg {
+	msg := &Msg{Subject: m.Subject, Data: m.Data, ack: func() error { return m.Ack() }}
+	meta, err := m.Metadata()
+	if err == nil {
+		msg.Sequence = meta.Sequence.Stream
+		msg.Timestamp = meta.Timestamp
+	}
+	return msg
+}
@@ -0,0 +1,254 @@
+// Package events defines the messages swarmhub, the deployers and ttl-enforcer
+// publish about grids, tests and the jobs working on them. Every event carries a
+// Header with the schema version and its kind so consumers can tell them apart
+// without guessing from the IDs.
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/common/bus"
+)
+
+// Version is the version of the schema the events are published with. Events of
+// a newer version are rejected by Decode, version 0 are the messages published
+// before there was a schema.
+const Version = 1
+
+// The kinds of events.
+const (
+	KindGridStatusChanged = "GridStatusChanged"
+	KindTestStatusChanged = "TestStatusChanged"
+	KindJobOutput         = "JobOutput"
+	KindJobFinished       = "JobFinished"
+	KindProfileFinished   = "LoadProfileFinished"
+)
+
+// Subjects the events are published on. Job output goes to JobOutputSubject
+// followed by the job ID, a JobFinished is published there as well so it ends the
+// output of the job.
+const (
+	StatusSubject      = "deployer.status"
+	JobOutputSubject   = "deployer.output."
+	JobFinishedSubject = "deployer.done"
+)
+
+// ErrUnsupportedVersion is returned by Decode for events of a newer schema.
+var ErrUnsupportedVersion = errors.New("events: unsupported version")
+
+// Header is part of every event.
+type Header struct {
+	Version   int
+	Kind      string
+	Timestamp time.Time
+	// Actor is who caused the event, e.g. swarmhub, ttl-enforcer or the host of a
+	// deployer.
+	Actor string
+}
+
+func (h *Header) header() *Header { return h }
+
+// Event is one of the event types of this package.
+type Event interface {
+	header() *Header
+	kind() string
+	subjects() []string
+}
+
+// GridStatusChanged is published when a grid moves to a new status.
+type GridStatusChanged struct {
+	Header
+	GridID string
+	// TestID is the test on the grid the change was made for, if any.
+	TestID string `json:",omitempty"`
+	Status string
+	Reason string `json:",omitempty"`
+	// Provider and Region are set when the grid is being deleted so ttl-enforcer
+	// can find its machines.
+	Provider string `json:",omitempty"`
+	Region   string `json:",omitempty"`
+}
+
+func (e *GridStatusChanged) kind() string       { return KindGridStatusChanged }
+func (e *GridStatusChanged) subjects() []string { return []string{StatusSubject} }
+
+// TestStatusChanged is published when a test moves to a new status.
+type TestStatusChanged struct {
+	Header
+	TestID string
+	// GridID is the grid the test is deployed to, if any.
+	GridID string `json:",omitempty"`
+	Status string
+	Reason string `json:",omitempty"`
+}
+
+func (e *TestStatusChanged) kind() string       { return KindTestStatusChanged }
+func (e *TestStatusChanged) subjects() []string { return []string{StatusSubject} }
+
+// LoadProfileFinished is published once the last stage of the load profile of a
+// test ran and locust was stopped. The test stays deployed until it is stopped.
+type LoadProfileFinished struct {
+	Header
+	TestID string
+	GridID string `json:",omitempty"`
+}
+
+func (e *LoadProfileFinished) kind() string       { return KindProfileFinished }
+func (e *LoadProfileFinished) subjects() []string { return []string{StatusSubject} }
+
+// JobOutput is a line written by a job. JobID is the ID of the deployment the job
+// runs for, the grid or test it was sent for.
+type JobOutput struct {
+	Header
+	JobID          string
+	DeploymentType string
+	// Stream is stdout or stderr.
+	Stream      string
+	Line        string
+	Attempt     int `json:",omitempty"`
+	MaxAttempts int `json:",omitempty"`
+}
+
+func (e *JobOutput) kind() string       { return KindJobOutput }
+func (e *JobOutput) subjects() []string { return []string{JobOutputSubject + e.JobID} }
+
+// JobFinished is published once a job stopped running, whatever the outcome.
+type JobFinished struct {
+	Header
+	JobID          string
+	DeploymentType string
+	// Status is Succeeded, Error, Timeout or Cancelled.
+	Status      string
+	Reason      string `json:",omitempty"`
+	ExitCode    int
+	Attempt     int `json:",omitempty"`
+	MaxAttempts int `json:",omitempty"`
+}
+
+func (e *JobFinished) kind() string { return KindJobFinished }
+func (e *JobFinished) subjects() []string {
+	return []string{JobOutputSubject + e.JobID, JobFinishedSubject}
+}
+
+// Outcomes of a job in JobFinished.
+const (
+	JobSucceeded = "Succeeded"
+	JobError     = "Error"
+)
+
+// Publish fills in the header of the event and publishes it on its subjects. The
+// timestamp is kept when it is already set.
+func Publish(b bus.Bus, actor string, event Event) error {
+	h := event.header()
+	h.Version = Version
+	h.Kind = event.kind()
+	h.Actor = actor
+	if h.Timestamp.IsZero() {
+		h.Timestamp = time.Now().UTC()
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		err = fmt.Errorf("failed to convert %v to json: %v", h.Kind, err)
+		return err
+	}
+
+	for _, subject := range event.subjects() {
+		err = b.Publish(subject, data)
+		if err != nil {
+			err = fmt.Errorf("failed to publish %v to %v: %v", h.Kind, subject, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// Decode returns the event in data as a pointer to one of the event types.
+func Decode(data []byte) (Event, error) {
+	var h Header
+	err := json.Unmarshal(data, &h)
+	if err != nil {
+		err = fmt.Errorf("unable to decode event header: %v", err)
+		return nil, err
+	}
+
+	if h.Version == 0 {
+		return decodeLegacy(data)
+	}
+	if h.Version > Version {
+		err = fmt.Errorf("%w: %v %v", ErrUnsupportedVersion, h.Kind, h.Version)
+		return nil, err
+	}
+
+	var event Event
+	switch h.Kind {
+	case KindGridStatusChanged:
+		event = &GridStatusChanged{}
+	case KindTestStatusChanged:
+		event = &TestStatusChanged{}
+	case KindJobOutput:
+		event = &JobOutput{}
+	case KindJobFinished:
+		event = &JobFinished{}
+	case KindProfileFinished:
+		event = &LoadProfileFinished{}
+	default:
+		err = fmt.Errorf("unknown event kind %q", h.Kind)
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, event)
+	if err != nil {
+		err = fmt.Errorf("unable to decode %v: %v", h.Kind, err)
+		return nil, err
+	}
+	return event, nil
+}
+
+// legacyMessage covers the status and output messages published before events
+// were versioned, they may still be in the streams after an upgrade.
+type legacyMessage struct {
+	ID             string
+	DeploymentType string
+	Status         string
+	Provider       string
+	Region         string
+	StreamType     *string
+	Output         string
+	Running        bool
+	Attempt        int
+	MaxAttempts    int
+}
+
+func decodeLegacy(data []byte) (Event, error) {
+	var m legacyMessage
+	err := json.Unmarshal(data, &m)
+	if err != nil {
+		err = fmt.Errorf("unable to decode message: %v", err)
+		return nil, err
+	}
+
+	switch {
+	case m.Status != "" && m.DeploymentType == "Grid":
+		return &GridStatusChanged{GridID: m.ID, Status: m.Status, Provider: m.Provider, Region: m.Region}, nil
+	case m.Status != "" && m.DeploymentType == "Test":
+		return &TestStatusChanged{TestID: m.ID, Status: m.Status}, nil
+	case m.Status != "":
+		err = fmt.Errorf("legacy status of deployment type %q is not supported", m.DeploymentType)
+		return nil, err
+	case !m.Running:
+		return &JobFinished{JobID: m.ID, DeploymentType: m.DeploymentType, Attempt: m.Attempt, MaxAttempts: m.MaxAttempts}, nil
+	case m.StreamType != nil:
+		stream := "stdout"
+		if *m.StreamType == "stderr" {
+			stream = "stderr"
+		}
+		return &JobOutput{JobID: m.ID, DeploymentType: m.DeploymentType, Stream: stream, Line: m.Output, Attempt: m.Attempt, MaxAttempts: m.MaxAttempts}, nil
+	}
+
+	err = fmt.Errorf("message is not an event")
+	return nil, err
+}
@@ -0,0 +1,5 @@
+module github.com/att-cloudnative-labs/swarmhub/services/common
+
+require (
+	github.com/nats-io/nats.go v1.16.0
+)
@@ -0,0 +1,181 @@
+// Package locust talks to the web API of a locust master to run the load profile
+// of a test and to read its statistics. It sends the parameters of locust 0.x as
+// well as 1.x and later so it works with either.
+package locust
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/common/operations"
+)
+
+// StartTimeout is how long RunProfile waits for the master to accept the first
+// stage, locust is usually still starting when the test was just deployed.
+var StartTimeout = 5 * time.Minute
+
+// retryInterval is the wait between attempts to start the first stage.
+var retryInterval = 5 * time.Second
+
+// Client calls the web API of a locust master.
+type Client struct {
+	// URL is where the web UI of the master is served, e.g. http://localhost:8089.
+	URL string
+	// Token is sent as the Authorization cookie, the locust-go proxy in front of
+	// the masters of AWS grids asks for it.
+	Token string
+	HTTP  *http.Client
+}
+
+// NewClient returns a client for the master at baseURL. skipVerify accepts the
+// self signed certificates of the locust-go proxy.
+func NewClient(baseURL string, token string, skipVerify bool) *Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if skipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return &Client{
+		URL:   strings.TrimRight(baseURL, "/"),
+		Token: token,
+		HTTP:  &http.Client{Timeout: 30 * time.Second, Transport: transport},
+	}
+}
+
+// response is what locust answers to swarm and stop.
+type response struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// Swarm starts users, or changes to that many when locust is already running,
+// spawning spawnRate of them per second.
+func (c *Client) Swarm(ctx context.Context, users int, spawnRate float64) error {
+	rate := strconv.FormatFloat(spawnRate, 'f', -1, 64)
+	form := url.Values{
+		"user_count":   {strconv.Itoa(users)},
+		"spawn_rate":   {rate},
+		"locust_count": {strconv.Itoa(users)},
+		"hatch_rate":   {rate},
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.URL+"/swarm", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return c.do(ctx, req)
+}
+
+// Stop stops all users.
+func (c *Client) Stop(ctx context.Context) error {
+	req, err := http.NewRequest(http.MethodGet, c.URL+"/stop", nil)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, req)
+}
+
+func (c *Client) do(ctx context.Context, req *http.Request) error {
+	body, err := c.send(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	var r response
+	err = json.Unmarshal(body, &r)
+	if err != nil {
+		return fmt.Errorf("%v %v returned an unexpected response: %v", req.Method, req.URL.Path, err)
+	}
+	if !r.Success {
+		return fmt.Errorf("%v %v failed: %v", req.Method, req.URL.Path, r.Message)
+	}
+	return nil
+}
+
+// send returns the body of the response to req, an error unless it is a 200.
+func (c *Client) send(ctx context.Context, req *http.Request) ([]byte, error) {
+	req = req.WithContext(ctx)
+	if c.Token != "" {
+		req.AddCookie(&http.Cookie{Name: "Authorization", Value: c.Token})
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%v %v returned %v: %v", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// RunProfile runs the stages of the profile and stops locust after the last one.
+// A stage without a duration keeps running and RunProfile returns once it started.
+// progress is told about every stage. It returns the error of ctx when it is
+// cancelled, locust is left running then.
+func RunProfile(ctx context.Context, c *Client, profile operations.LoadProfile, progress func(string)) error {
+	steps := profile.Steps()
+	for i, step := range steps {
+		err := c.Swarm(ctx, step.Users, step.SpawnRate)
+		if err != nil && i == 0 {
+			err = retrySwarm(ctx, c, step, err)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to start stage %v of %v: %v", i+1, len(steps), err)
+		}
+
+		if step.Duration == "" {
+			progress(fmt.Sprintf("Running %v users at %v per second until the test is stopped.", step.Users, step.SpawnRate))
+			return nil
+		}
+		progress(fmt.Sprintf("Stage %v of %v: %v users at %v per second for %v.", i+1, len(steps), step.Users, step.SpawnRate, step.Duration))
+
+		duration, err := time.ParseDuration(step.Duration)
+		if err != nil {
+			return fmt.Errorf("stage %v of %v has an invalid duration: %v", i+1, len(steps), err)
+		}
+		select {
+		case <-time.After(duration):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	err := c.Stop(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to stop locust: %v", err)
+	}
+	progress("Load profile finished, locust was stopped.")
+	return nil
+}
+
+// retrySwarm tries to start the first stage until locust accepts it or StartTimeout passed.
+func retrySwarm(ctx context.Context, c *Client, step operations.LoadStage, err error) error {
+	deadline := time.Now().Add(StartTimeout)
+	for time.Now().Before(deadline) {
+		select {
+		case <-time.After(retryInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		err = c.Swarm(ctx, step.Users, step.SpawnRate)
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
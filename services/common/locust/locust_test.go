@@ -0,0 +1,199 @@
+package locust
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/common/operations"
+)
+
+// fakeMaster is the web API of a locust master. The first failSwarms calls to
+// swarm fail, as do all of them from the stage failFrom on when it is set.
+type fakeMaster struct {
+	mu         sync.Mutex
+	failSwarms int
+	failFrom   int
+	swarms     []string
+	stopped    bool
+}
+
+func (m *fakeMaster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/swarm":
+		r.ParseForm()
+		if r.Form.Get("user_count") != r.Form.Get("locust_count") || r.Form.Get("spawn_rate") != r.Form.Get("hatch_rate") {
+			http.Error(w, "the parameters of locust 0.x and 1.x differ", http.StatusBadRequest)
+			return
+		}
+		if m.failSwarms > 0 {
+			m.failSwarms--
+			http.Error(w, "locust is starting", http.StatusBadGateway)
+			return
+		}
+		if m.failFrom > 0 && len(m.swarms)+1 >= m.failFrom {
+			fmt.Fprint(w, `{"success": false, "message": "no workers"}`)
+			return
+		}
+		m.swarms = append(m.swarms, r.Form.Get("user_count")+"@"+r.Form.Get("spawn_rate"))
+		fmt.Fprint(w, `{"success": true, "message": "Swarming started"}`)
+	case "/stop":
+		m.stopped = true
+		fmt.Fprint(w, `{"success": true, "message": "Test stopped"}`)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestRunProfile(t *testing.T) {
+	defer func(timeout time.Duration, interval time.Duration) {
+		StartTimeout, retryInterval = timeout, interval
+	}(StartTimeout, retryInterval)
+	StartTimeout = 100 * time.Millisecond
+	retryInterval = time.Millisecond
+
+	tests := []struct {
+		name        string
+		profile     operations.LoadProfile
+		failSwarms  int
+		failFrom    int
+		wantSwarms  []string
+		wantStopped bool
+		wantErr     string
+	}{
+		{
+			name:       "runs until stopped",
+			profile:    operations.LoadProfile{Users: 10, SpawnRate: 2},
+			wantSwarms: []string{"10@2"},
+		},
+		{
+			name:        "simple profile with a duration",
+			profile:     operations.LoadProfile{Users: 10, SpawnRate: 2.5, Duration: "10ms"},
+			wantSwarms:  []string{"10@2.5"},
+			wantStopped: true,
+		},
+		{
+			name: "stages",
+			profile: operations.LoadProfile{Stages: []operations.LoadStage{
+				{Users: 5, SpawnRate: 1, Duration: "10ms"},
+				{Users: 20, SpawnRate: 5, Duration: "10ms"},
+				{Users: 1, SpawnRate: 1, Duration: "10ms"},
+			}},
+			wantSwarms:  []string{"5@1", "20@5", "1@1"},
+			wantStopped: true,
+		},
+		{
+			name:        "first stage is retried while locust starts",
+			profile:     operations.LoadProfile{Users: 10, SpawnRate: 2, Duration: "10ms"},
+			failSwarms:  3,
+			wantSwarms:  []string{"10@2"},
+			wantStopped: true,
+		},
+		{
+			name:       "locust doesn't start in time",
+			profile:    operations.LoadProfile{Users: 10, SpawnRate: 2},
+			failSwarms: 1000000,
+			wantErr:    "unable to start stage 1 of 1",
+		},
+		{
+			name: "later stages are not retried",
+			profile: operations.LoadProfile{Stages: []operations.LoadStage{
+				{Users: 5, SpawnRate: 1, Duration: "10ms"},
+				{Users: 20, SpawnRate: 5, Duration: "10ms"},
+			}},
+			failFrom:   2,
+			wantSwarms: []string{"5@1"},
+			wantErr:    "unable to start stage 2 of 2: POST /swarm failed: no workers",
+		},
+	}
+
+	for _, test := range tests {
+		master := &fakeMaster{failSwarms: test.failSwarms, failFrom: test.failFrom}
+		server := httptest.NewServer(master)
+
+		var progress []string
+		err := RunProfile(context.Background(), NewClient(server.URL, "", false), test.profile, func(line string) {
+			progress = append(progress, line)
+		})
+		server.Close()
+
+		switch {
+		case test.wantErr == "" && err != nil:
+			t.Errorf("%v: %v", test.name, err)
+		case test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)):
+			t.Errorf("%v: got error %v, want %q", test.name, err, test.wantErr)
+		}
+		if strings.Join(master.swarms, ",") != strings.Join(test.wantSwarms, ",") {
+			t.Errorf("%v: swarmed %v, want %v", test.name, master.swarms, test.wantSwarms)
+		}
+		if master.stopped != test.wantStopped {
+			t.Errorf("%v: stopped is %v, want %v", test.name, master.stopped, test.wantStopped)
+		}
+		if err == nil && len(progress) == 0 {
+			t.Errorf("%v: no progress was reported", test.name)
+		}
+	}
+}
+
+func TestRunProfileCancelled(t *testing.T) {
+	master := &fakeMaster{}
+	server := httptest.NewServer(master)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+
+	profile := operations.LoadProfile{Users: 10, SpawnRate: 2, Duration: "1h"}
+	err := RunProfile(ctx, NewClient(server.URL, "", false), profile, func(string) {})
+	if err != context.Canceled {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	if master.stopped {
+		t.Error("locust was stopped, it is left running when cancelled")
+	}
+}
+
+func TestRetrySwarmCancelled(t *testing.T) {
+	master := &fakeMaster{failSwarms: 1000000}
+	server := httptest.NewServer(master)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	step := operations.LoadStage{Users: 1, SpawnRate: 1}
+	err := retrySwarm(ctx, NewClient(server.URL, "", false), step, fmt.Errorf("first attempt"))
+	if err != context.Canceled {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+}
+
+func TestClientToken(t *testing.T) {
+	var cookie string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie("Authorization"); err == nil {
+			cookie = c.Value
+		}
+		fmt.Fprint(w, `{"success": true}`)
+	}))
+	defer server.Close()
+
+	err := NewClient(server.URL+"/", "secret", false).Stop(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cookie != "secret" {
+		t.Errorf("sent Authorization cookie %q", cookie)
+	}
+}
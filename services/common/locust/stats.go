@@ -0,0 +1,240 @@
+package locust
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// RequestStats are the statistics of one endpoint, or of all requests together,
+// since locust was started. Response times are in milliseconds.
+type RequestStats struct {
+	Method             string
+	Name               string
+	Requests           int64
+	Failures           int64
+	MedianResponseTime float64
+	AvgResponseTime    float64
+	MinResponseTime    float64
+	MaxResponseTime    float64
+	AvgContentLength   float64
+	// RPS is the average number of requests per second over the run.
+	RPS float64
+	// Percentiles are the response times by percentile, e.g. "95" or "99.9".
+	Percentiles map[string]float64 `json:",omitempty"`
+}
+
+// FailRatio is the share of the requests that failed, between 0 and 1.
+func (s RequestStats) FailRatio() float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return float64(s.Failures) / float64(s.Requests)
+}
+
+// Stats are the statistics of a locust master, by endpoint and in total.
+type Stats struct {
+	Requests []RequestStats
+	Total    RequestStats
+}
+
+// Endpoint returns the statistics of an endpoint, given as its name or as its
+// method and name like "GET /login". The empty endpoint is the total.
+func (s Stats) Endpoint(endpoint string) (RequestStats, error) {
+	if endpoint == "" {
+		return s.Total, nil
+	}
+
+	var matches []RequestStats
+	for _, r := range s.Requests {
+		if r.Method+" "+r.Name == endpoint {
+			return r, nil
+		}
+		if r.Name == endpoint {
+			matches = append(matches, r)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return RequestStats{}, fmt.Errorf("no requests to %q were made", endpoint)
+	case 1:
+		return matches[0], nil
+	}
+	return RequestStats{}, fmt.Errorf("%q was requested with more than one method, prefix it with the method", endpoint)
+}
+
+// Stats reads the statistics from the CSV exports of the master, which all
+// versions of locust have. Locust before 1.0 has the percentiles in a separate
+// export.
+func (c *Client) Stats(ctx context.Context) (Stats, error) {
+	body, err := c.get(ctx, "/stats/requests/csv")
+	if err != nil {
+		return Stats{}, err
+	}
+
+	stats, percentiles, err := parseRequestsCSV(body)
+	if err != nil {
+		return stats, fmt.Errorf("unable to read the request statistics: %v", err)
+	}
+	if percentiles {
+		return stats, nil
+	}
+
+	body, err = c.get(ctx, "/stats/distribution/csv")
+	if err != nil {
+		return stats, err
+	}
+	err = mergeDistributionCSV(&stats, body)
+	if err != nil {
+		return stats, fmt.Errorf("unable to read the response time distribution: %v", err)
+	}
+	return stats, nil
+}
+
+func (c *Client) get(ctx context.Context, path string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, c.URL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.send(ctx, req)
+}
+
+// csvTable is a CSV export with its columns by their lower cased header, without
+// the "# " locust before 1.0 puts in front of counts.
+type csvTable struct {
+	columns map[string]int
+	rows    [][]string
+}
+
+func readCSV(body []byte) (csvTable, error) {
+	records, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+	if err != nil {
+		return csvTable{}, err
+	}
+	if len(records) == 0 {
+		return csvTable{}, fmt.Errorf("the export is empty")
+	}
+
+	table := csvTable{columns: map[string]int{}, rows: records[1:]}
+	for i, header := range records[0] {
+		header = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(header), "# "))
+		table.columns[header] = i
+	}
+	return table, nil
+}
+
+// value returns the field of the first of the columns the row has.
+func (t csvTable) value(row []string, columns ...string) string {
+	for _, column := range columns {
+		if i, ok := t.columns[column]; ok && i < len(row) {
+			return row[i]
+		}
+	}
+	return ""
+}
+
+func (t csvTable) number(row []string, columns ...string) float64 {
+	v, err := strconv.ParseFloat(t.value(row, columns...), 64)
+	if err != nil {
+		// N/A when there were no requests
+		return 0
+	}
+	return v
+}
+
+// percentiles reads the columns like "95%" of the row.
+func (t csvTable) percentiles(row []string) map[string]float64 {
+	percentiles := map[string]float64{}
+	for column, i := range t.columns {
+		if !strings.HasSuffix(column, "%") || i >= len(row) {
+			continue
+		}
+		v, err := strconv.ParseFloat(row[i], 64)
+		if err != nil {
+			continue
+		}
+		percentiles[strings.TrimSuffix(column, "%")] = v
+	}
+	return percentiles
+}
+
+func (t csvTable) hasPercentiles() bool {
+	for column := range t.columns {
+		if strings.HasSuffix(column, "%") {
+			return true
+		}
+	}
+	return false
+}
+
+// isTotal reports if the name is the row of all requests, Aggregated since
+// locust 1.0 and Total before.
+func isTotal(name string) bool {
+	return name == "Aggregated" || name == "Total" || name == "None Total"
+}
+
+func parseRequestsCSV(body []byte) (Stats, bool, error) {
+	var stats Stats
+	table, err := readCSV(body)
+	if err != nil {
+		return stats, false, err
+	}
+	if _, ok := table.columns["name"]; !ok {
+		return stats, false, fmt.Errorf("the export has no Name column")
+	}
+
+	for _, row := range table.rows {
+		r := RequestStats{
+			Method:             table.value(row, "type", "method"),
+			Name:               table.value(row, "name"),
+			Requests:           int64(table.number(row, "request count", "requests")),
+			Failures:           int64(table.number(row, "failure count", "failures")),
+			MedianResponseTime: table.number(row, "median response time"),
+			AvgResponseTime:    table.number(row, "average response time"),
+			MinResponseTime:    table.number(row, "min response time"),
+			MaxResponseTime:    table.number(row, "max response time"),
+			AvgContentLength:   table.number(row, "average content size"),
+			RPS:                table.number(row, "requests/s"),
+		}
+		if table.hasPercentiles() {
+			r.Percentiles = table.percentiles(row)
+		}
+
+		if isTotal(r.Name) && (r.Method == "" || r.Method == "None") {
+			r.Method = ""
+			stats.Total = r
+			continue
+		}
+		stats.Requests = append(stats.Requests, r)
+	}
+	return stats, table.hasPercentiles(), nil
+}
+
+// mergeDistributionCSV adds the percentiles of locust before 1.0, its rows are
+// named by method and name like "GET /login".
+func mergeDistributionCSV(stats *Stats, body []byte) error {
+	table, err := readCSV(body)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range table.rows {
+		name := table.value(row, "name")
+		percentiles := table.percentiles(row)
+		if isTotal(name) {
+			stats.Total.Percentiles = percentiles
+			continue
+		}
+		for i, r := range stats.Requests {
+			if r.Method+" "+r.Name == name {
+				stats.Requests[i].Percentiles = percentiles
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+// Package operations defines the steps swarmhub asks the deployers to run. An
+// operation is sent by name with its arguments as JSON, the deployer validates
+// them and decides which script runs it for the grid's provider.
+package operations
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+)
+
+// The operations a deployer runs.
+const (
+	// ProvisionGrid builds a grid, its arguments are ProvisionGridArgs.
+	ProvisionGrid = "ProvisionGrid"
+	// DeployTest loads the test scripts onto a grid, its arguments are DeployTestArgs.
+	DeployTest = "DeployTest"
+	// CleanupGrid stops locust and removes the test from a grid, its arguments are
+	// CleanupGridArgs.
+	CleanupGrid = "CleanupGrid"
+	// DeleteGrid tears a grid down, its arguments are DeleteGridArgs.
+	DeleteGrid = "DeleteGrid"
+)
+
+// identifier is what IDs, regions and instance types may look like. They end up
+// in the command line of ansible so nothing else is let through.
+var identifier = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:-]*$`)
+
+type ProvisionGridArgs struct {
+	GridID string
+	Region string
+	// MasterType and SlaveType are the instance types of the nodes, for local
+	// grids process or docker.
+	MasterType string
+	SlaveType  string
+	// Nodes is the number of slave nodes.
+	Nodes int
+	// Expires is when the grid's TTL runs out in unix seconds.
+	Expires              int64    `json:",omitempty"`
+	MasterSecurityGroups []string `json:",omitempty"`
+	SlaveSecurityGroups  []string `json:",omitempty"`
+}
+
+func (a ProvisionGridArgs) Validate() error {
+	err := validateIdentifiers("GridID", a.GridID, "Region", a.Region, "MasterType", a.MasterType, "SlaveType", a.SlaveType)
+	if err != nil {
+		return err
+	}
+	if a.Nodes < 0 {
+		return fmt.Errorf("Nodes must not be negative, got %v", a.Nodes)
+	}
+	for _, groups := range [][]string{a.MasterSecurityGroups, a.SlaveSecurityGroups} {
+		for _, group := range groups {
+			if !identifier.MatchString(group) {
+				return fmt.Errorf("invalid security group %q", group)
+			}
+		}
+	}
+	return nil
+}
+
+type DeployTestArgs struct {
+	GridID string
+	Region string
+	// ScriptID is the key of the scripts in s3, ScriptURL a url they can be
+	// downloaded from. Which one is needed depends on the provider.
+	ScriptID           string `json:",omitempty"`
+	ScriptURL          string `json:",omitempty"`
+	ScriptFilename     string
+	StartAutomatically bool
+	// Profile is the load locust is driven with once the test is deployed,
+	// MasterURL where the web API of the locust master can be reached for it.
+	Profile   *LoadProfile `json:",omitempty"`
+	MasterURL string       `json:",omitempty"`
+}
+
+func (a DeployTestArgs) Validate() error {
+	err := validateIdentifiers("GridID", a.GridID, "Region", a.Region)
+	if err != nil {
+		return err
+	}
+	if a.ScriptID != "" && !identifier.MatchString(a.ScriptID) {
+		return fmt.Errorf("invalid ScriptID %q", a.ScriptID)
+	}
+	if a.ScriptURL != "" {
+		u, err := url.Parse(a.ScriptURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("ScriptURL must be an http or https url")
+		}
+	}
+	if a.ScriptFilename == "" {
+		return fmt.Errorf("ScriptFilename is required")
+	}
+	if a.MasterURL != "" {
+		u, err := url.Parse(a.MasterURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("MasterURL must be an http or https url")
+		}
+	}
+	if a.Profile != nil {
+		err := a.Profile.Validate()
+		if err != nil {
+			return fmt.Errorf("invalid Profile: %v", err)
+		}
+	}
+	return nil
+}
+
+type CleanupGridArgs struct {
+	GridID string
+	Region string
+	// TestID is the test that is stopped, empty when the grid is only cleaned.
+	TestID string `json:",omitempty"`
+}
+
+func (a CleanupGridArgs) Validate() error {
+	err := validateIdentifiers("GridID", a.GridID, "Region", a.Region)
+	if err != nil {
+		return err
+	}
+	if a.TestID != "" && !identifier.MatchString(a.TestID) {
+		return fmt.Errorf("invalid TestID %q", a.TestID)
+	}
+	return nil
+}
+
+type DeleteGridArgs struct {
+	GridID string
+	Region string
+}
+
+func (a DeleteGridArgs) Validate() error {
+	return validateIdentifiers("GridID", a.GridID, "Region", a.Region)
+}
+
+// validateIdentifiers checks the required fields, given as name and value pairs.
+func validateIdentifiers(fields ...string) error {
+	for i := 0; i+1 < len(fields); i += 2 {
+		name, value := fields[i], fields[i+1]
+		if value == "" {
+			return fmt.Errorf("%v is required", name)
+		}
+		if !identifier.MatchString(value) {
+			return fmt.Errorf("invalid %v %q", name, value)
+		}
+	}
+	return nil
+}
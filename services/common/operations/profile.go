@@ -0,0 +1,97 @@
+package operations
+
+import (
+	"fmt"
+	"time"
+)
+
+// LoadProfile is the load a test is run with. A simple profile sets Users,
+// SpawnRate and Duration, a staged one sets Stages instead and ramps through them
+// one after the other.
+type LoadProfile struct {
+	Users     int     `json:",omitempty"`
+	SpawnRate float64 `json:",omitempty"`
+	// Duration is how long the load runs, e.g. "30m". Without a duration a simple
+	// profile runs until the test is stopped.
+	Duration string      `json:",omitempty"`
+	Stages   []LoadStage `json:",omitempty"`
+}
+
+// LoadStage runs Users, spawned at SpawnRate users per second, for Duration.
+type LoadStage struct {
+	Users     int
+	SpawnRate float64
+	Duration  string
+}
+
+func (p LoadProfile) Validate() error {
+	if len(p.Stages) > 0 {
+		if p.Users != 0 || p.SpawnRate != 0 || p.Duration != "" {
+			return fmt.Errorf("a profile has either Stages or Users, SpawnRate and Duration")
+		}
+		for i, stage := range p.Stages {
+			err := validateLoad(stage.Users, stage.SpawnRate)
+			if err != nil {
+				return fmt.Errorf("stage %v: %v", i+1, err)
+			}
+			if _, err := parsePositiveDuration(stage.Duration); err != nil {
+				return fmt.Errorf("stage %v: %v", i+1, err)
+			}
+		}
+		return nil
+	}
+
+	err := validateLoad(p.Users, p.SpawnRate)
+	if err != nil {
+		return err
+	}
+	if p.Duration != "" {
+		if _, err := parsePositiveDuration(p.Duration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Steps returns the stages the profile runs through, a simple profile is a single
+// stage. A stage without a duration runs until it is stopped.
+func (p LoadProfile) Steps() []LoadStage {
+	if len(p.Stages) > 0 {
+		return p.Stages
+	}
+	return []LoadStage{{Users: p.Users, SpawnRate: p.SpawnRate, Duration: p.Duration}}
+}
+
+// TotalDuration is how long the profile runs, false when it runs until stopped.
+func (p LoadProfile) TotalDuration() (time.Duration, bool) {
+	var total time.Duration
+	for _, stage := range p.Steps() {
+		if stage.Duration == "" {
+			return 0, false
+		}
+		duration, err := time.ParseDuration(stage.Duration)
+		if err != nil {
+			return 0, false
+		}
+		total += duration
+	}
+	return total, true
+}
+
+func validateLoad(users int, spawnRate float64) error {
+	if users <= 0 {
+		return fmt.Errorf("Users must be positive, got %v", users)
+	}
+	if spawnRate <= 0 {
+		return fmt.Errorf("SpawnRate must be positive, got %v", spawnRate)
+	}
+	return nil
+}
+
+func parsePositiveDuration(raw string) (time.Duration, error) {
+	duration, err := time.ParseDuration(raw)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("Duration must be a positive duration like 10m, got %q", raw)
+	}
+	return duration, nil
+}
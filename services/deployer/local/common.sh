@@ -0,0 +1,103 @@
+#!/bin/bash
+
+# Shared by the local grid scripts. A local grid is a locust master and workers
+# running on the deployer host, either as processes or as docker containers.
+# The instance type of the master picks which one: "process" or "docker".
+
+SWARMHUB_LOCAL_DIR=${SWARMHUB_LOCAL_DIR:-/tmp/swarmhub}
+LOCUST_IMAGE=${LOCUST_IMAGE:-locustio/locust:0.13.5}
+LOCUST_WEB_PORT=${LOCUST_WEB_PORT:-8089}
+LOCUST_MASTER_BIND_PORT=${LOCUST_MASTER_BIND_PORT:-9000}
+LOCAL_SCRIPT_DIR=$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)
+
+grid_dir() {
+  echo "${SWARMHUB_LOCAL_DIR}/${1}"
+}
+
+# reset_scripts puts the default locustfile back for grid ${1}.
+reset_scripts() {
+  local dir
+  dir=$(grid_dir "${1}")
+  rm -rf "${dir}/scripts"
+  mkdir -p "${dir}/scripts"
+  cp "${LOCAL_SCRIPT_DIR}/locustfile.py" "${dir}/scripts/locustfile.py"
+}
+
+start_grid() {
+  local grid_id=${1}
+  local dir mode nodes
+  dir=$(grid_dir "${grid_id}")
+  mode=$(cat "${dir}/mode")
+  nodes=$(cat "${dir}/nodes")
+
+  case "${mode}" in
+  process)
+    cd "${dir}/scripts"
+    if [ -f requirements.txt ]; then
+      pip install -r requirements.txt
+    fi
+    nohup locust -f locustfile.py --master --master-bind-port="${LOCUST_MASTER_BIND_PORT}" --web-port="${LOCUST_WEB_PORT}" > "${dir}/master.log" 2>&1 &
+    echo $! > "${dir}/master.pid"
+    for i in $(seq 1 "${nodes}"); do
+      nohup locust -f locustfile.py --slave --master-host=127.0.0.1 --master-port="${LOCUST_MASTER_BIND_PORT}" > "${dir}/worker-${i}.log" 2>&1 &
+      echo $! > "${dir}/worker-${i}.pid"
+    done
+    ;;
+  docker)
+    docker network inspect "swarmhub-${grid_id}" > /dev/null 2>&1 || docker network create "swarmhub-${grid_id}"
+    run_container "${grid_id}" "locust-master-${grid_id}" -p "${LOCUST_WEB_PORT}:8089" -- --master --master-bind-port=9000
+    for i in $(seq 1 "${nodes}"); do
+      run_container "${grid_id}" "locust-worker-${grid_id}-${i}" -- --slave --master-host="locust-master-${grid_id}" --master-port=9000
+    done
+    ;;
+  *)
+    echo "Unknown local grid mode ${mode}"
+    exit 1
+    ;;
+  esac
+}
+
+# run_container starts a locust container for grid ${1} named ${2}. Options up to
+# "--" are passed to docker, the rest to locust.
+run_container() {
+  local grid_id=${1}
+  local name=${2}
+  shift 2
+  local docker_args=()
+  while [ "${1}" != "--" ]; do
+    docker_args+=("${1}")
+    shift
+  done
+  shift
+
+  docker run -d --name "${name}" --label "swarmhub.grid=${grid_id}" --network "swarmhub-${grid_id}" \
+    -v "$(grid_dir "${grid_id}")/scripts:/locust" -w /locust --entrypoint sh "${docker_args[@]}" "${LOCUST_IMAGE}" \
+    -c 'if [ -f requirements.txt ]; then pip install -r requirements.txt; fi; exec locust -f locustfile.py "$@"' locust "$@"
+}
+
+stop_grid() {
+  local grid_id=${1}
+  local dir mode
+  dir=$(grid_dir "${grid_id}")
+  if [ ! -f "${dir}/mode" ]; then
+    echo "Grid ${grid_id} has no local state, nothing to stop"
+    return
+  fi
+  mode=$(cat "${dir}/mode")
+
+  case "${mode}" in
+  process)
+    for pidfile in "${dir}"/*.pid; do
+      [ -f "${pidfile}" ] || continue
+      kill "$(cat "${pidfile}")" 2> /dev/null || true
+      rm -f "${pidfile}"
+    done
+    ;;
+  docker)
+    containers=$(docker ps -aq --filter "label=swarmhub.grid=${grid_id}")
+    if [ -n "${containers}" ]; then
+      docker rm -f ${containers}
+    fi
+    ;;
+  esac
+}
@@ -0,0 +1,25 @@
+#!/bin/bash
+set -e
+
+# This is for local deployment
+# ${1} is the url the test scripts are downloaded from
+# ${2} is the test filename
+# ${3} is grid id
+# ${4} is the grid region
+# ${5} is does the grid start automatically?
+
+source "$(dirname "${0}")/common.sh"
+
+echo "Running local deployTest script"
+
+dir=$(grid_dir "${3}")
+reset_scripts "${3}"
+
+echo "Download ${2}"
+wget -q -O "${dir}/script.zip" "${1}"
+unzip -o "${dir}/script.zip" -d "${dir}/scripts"
+rm -f "${dir}/script.zip"
+
+echo "Restart locust with the test scripts"
+stop_grid "${3}"
+start_grid "${3}"
@@ -0,0 +1,14 @@
+#!/bin/bash
+set -e
+
+# This is for local deployment cleanup
+# ${1} is grid id
+# ${2} is the grid region
+
+source "$(dirname "${0}")/common.sh"
+
+echo "Running local grid cleanup script"
+
+stop_grid "${1}"
+reset_scripts "${1}"
+start_grid "${1}"
@@ -0,0 +1,16 @@
+#!/bin/bash
+set -e
+
+# This is for local deployment deletion
+# ${1} is grid id
+# ${2} is the grid region
+
+source "$(dirname "${0}")/common.sh"
+
+echo "Running local grid delete script"
+
+stop_grid "${1}"
+if docker network inspect "swarmhub-${1}" > /dev/null 2>&1; then
+  docker network rm "swarmhub-${1}"
+fi
+rm -rf "$(grid_dir "${1}")"
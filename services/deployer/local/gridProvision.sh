@@ -0,0 +1,22 @@
+#!/bin/bash
+set -e
+
+# This is for local deployment
+# ${1} is grid id
+# ${2} is the grid region
+# ${3} is the master instance type, process or docker
+# ${4} is the slave instance type
+# ${5} is the number of slave nodes
+
+source "$(dirname "${0}")/common.sh"
+
+echo "Running local grid provision script"
+
+dir=$(grid_dir "${1}")
+mkdir -p "${dir}"
+echo "${3}" > "${dir}/mode"
+echo "${5}" > "${dir}/nodes"
+reset_scripts "${1}"
+
+echo "Starting locust master and ${5} workers as ${3}"
+start_grid "${1}"
@@ -0,0 +1,5 @@
+from locust import HttpLocust, TaskSet
+
+
+class WebsiteUser(HttpLocust):
+    task_set = TaskSet
@@ -1,6 +1,9 @@
 module github.com/att-cloudnative-labs/swarmhub/services/deployer/src/deployer
 
 require (
-	github.com/nats-io/nats.go v1.8.1
-	github.com/nats-io/stan.go v0.5.0
+	github.com/att-cloudnative-labs/swarmhub/services/common v0.0.0
+	github.com/dgrijalva/jwt-go v3.2.0+incompatible
+	github.com/nats-io/nats.go v1.16.0 // indirect
 )
+
+replace github.com/att-cloudnative-labs/swarmhub/services/common => ../../../common
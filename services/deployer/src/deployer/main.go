@@ -10,21 +10,24 @@ import (
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 
-	"github.com/nats-io/stan.go"
+	"github.com/att-cloudnative-labs/swarmhub/services/common/bus"
+	"github.com/att-cloudnative-labs/swarmhub/services/common/events"
 )
 
 var (
-	cmdMap          = make(map[string]*CommandStruct)
 	natsUsername    string
 	natsPassword    string
 	natsURL         string
-	clusterID       string
-	startCmdMutex   = &sync.Mutex{}
-	message         = make(chan string)
-	sc              stan.Conn
-	subCmdStart     stan.Subscription
-	subDeployerStop stan.Subscription
+	messageBus      bus.Bus
+	jobs            bus.Queue
+	subDeployerStop bus.Subscription
+	// subDeployerStatus watches the statuses to stop the load profiles of tests
+	// that are no longer running.
+	subDeployerStatus bus.Subscription
+	// actor is who the events of this deployer are published as.
+	actor string
 )
 
 type CommandStruct struct {
@@ -34,28 +37,32 @@ type CommandStruct struct {
 	ErrorStream      io.ReadCloser
 	cmd              *exec.Cmd
 	CurrentlyRunning bool
-}
-
-type CommandOutput struct {
-	ID             string
-	DeploymentType string
-	StreamType     string
-	Output         string
-	Running        bool
+	Job              Job
+	// process is the running attempt of the job and done is closed once it exited.
+	process *os.Process
+	done    chan struct{}
+	// stopped is closed and stopReason set by the registry when the job is stopped
+	// before it finished.
+	stopped    chan struct{}
+	stopReason string
+	// attempt is the attempt the output streams belong to.
+	attempt     int
+	maxAttempts int
 }
 
 type Deployment struct {
 	ID             string
 	DeploymentType string
-	Cmd            string
-	Params         []string
-}
-
-type DeploymentStatus struct {
-	ID             string
-	DeploymentType string
-	Status         string
-	Params         []string
+	// Operation is the name of the step to run for the grid's Provider, Args are
+	// its arguments. See operationsByProvider for what is accepted.
+	Operation string
+	Provider  string
+	Args      json.RawMessage
+	// Timeout is how long each attempt of the job may run as a duration, e.g. "45m".
+	// The default of the deployment type is used when it is empty.
+	Timeout string
+	// Retry runs the job again when it fails, it is run once when there is none.
+	Retry *RetryPolicy
 }
 
 func loadNatsFromEnv() {
@@ -73,16 +80,13 @@ func loadNatsFromEnv() {
 	} else {
 		natsURL = "nats://" + natsUsername + ":" + natsPassword + "@" + natsURLRaw
 	}
-
-	clusterID = os.Getenv("STAN_CLUSTER_ID")
-	if clusterID == "" {
-		fmt.Println("Default stan cluster ID.")
-		clusterID = "stan"
-	}
 }
 
 func init() {
 	loadNatsFromEnv()
+	loadWorkersFromEnv()
+	loadTimeoutsFromEnv()
+	loadProfilesFromEnv()
 }
 
 func main() {
@@ -91,30 +95,35 @@ func main() {
 		fmt.Printf("Failed to get Hostname: %v\n", err)
 		os.Exit(2)
 	}
-	sc, err = stan.Connect(clusterID, hostname, stan.NatsURL(natsURL))
+	actor = "deployer/" + hostname
+	messageBus, err = bus.Connect(natsURL, hostname)
 	if err != nil {
-		fmt.Printf("Failed to connect to nats streaming cluster: %v", err)
+		fmt.Printf("Failed to connect to nats: %v", err)
 		os.Exit(2)
 	}
 
-	go startCmd(sc)
-	subDeployerStop, _ = sc.Subscribe("deployer.stop", messageStopHandler)
+	go startCmd()
+	go serveJobs(loadHTTPPortFromEnv(), hostname)
+	subDeployerStop, _ = messageBus.Subscribe("deployer.stop", messageStopHandler, bus.DeliverNew())
+	subDeployerStatus, _ = messageBus.Subscribe(events.StatusSubject, profileStatusHandler, bus.DeliverNew())
 
-	signal_chan := make(chan os.Signal)
+	signal_chan := make(chan os.Signal, 1)
 	signal.Notify(signal_chan, os.Interrupt, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP, syscall.SIGQUIT)
-	shutdown(sc, signal_chan)
+	shutdown(signal_chan)
 }
 
-func shutdown(sc stan.Conn, c <-chan os.Signal) {
+func shutdown(c <-chan os.Signal) {
 	<-c
 	fmt.Println("Shutting Down.")
-	subCmdStart.Close()
-	subDeployerStop.Close()
-	sc.Close()
+	subDeployerStop.Unsubscribe()
+	subDeployerStatus.Unsubscribe()
+	// the deployer.start queue is shared by the deployers, closing the bus leaves
+	// it in place
+	messageBus.Close()
 	os.Exit(0)
 }
 
-func messageStopHandler(msg *stan.Msg) {
+func messageStopHandler(msg *bus.Msg) {
 	fmt.Println("Recieved stop message: ", string(msg.Data))
 	var stopMsg Deployment
 
@@ -123,14 +132,28 @@ func messageStopHandler(msg *stan.Msg) {
 		fmt.Println("failed to unmarshal msg.Data: ", err.Error())
 		return
 	}
-	stopCmdJob(stopMsg.ID)
+	go stopCmdJob(stopMsg.ID)
+	stopLoadProfiles(stopMsg.ID)
 	fmt.Println("Finished running stop handler for ", stopMsg.ID)
 }
 
-// Possibly a very small gap that additional message might get sent before unsubscribed?
-func messageStartHandler(msg *stan.Msg) {
-	msg.Ack()
-	message <- "started"
+// jobHeartbeat is how often a job that is being worked on is reported as in
+// progress, well within bus.AckWait.
+var jobHeartbeat = bus.AckWait / 3
+
+// messageStartHandler runs a job of the work queue. The job is only acked once it
+// succeeded, failed for good or was rejected, so when the deployer dies while it
+// runs the job is handed to another deployer.
+func messageStartHandler(msg *bus.Msg) {
+	stopHeartbeat := heartbeat(msg)
+	defer func() {
+		stopHeartbeat()
+		err := msg.Ack()
+		if err != nil {
+			fmt.Println("Failed to ack start message: ", err.Error())
+		}
+	}()
+
 	fmt.Println("Recieved start message: ", string(msg.Data))
 	var startMsg Deployment
 
@@ -138,128 +161,187 @@ func messageStartHandler(msg *stan.Msg) {
 	fmt.Println("startMsg is: ", startMsg)
 	if err != nil {
 		fmt.Println("failed to unmarshal msg.Data: ", err.Error())
+		return
 	}
 
-	StartCmdJob(startMsg.Cmd, startMsg.Params, startMsg.ID, startMsg.DeploymentType)
+	job, err := resolveOperation(startMsg)
+	if err != nil {
+		rejectDeployment(startMsg, err)
+		return
+	}
+
+	// jobs on the same grid run one after the other, first among the jobs of this
+	// deployer and then among all deployers. Only then the job takes a worker.
+	lockDeployment(job.gridID)
+	defer unlockDeployment(job.gridID)
+	unlockGrid := lockGrid(job.gridID)
+	defer unlockGrid()
+
+	workers <- struct{}{}
+	defer func() { <-workers }()
+
+	StartCmdJob(startMsg.Operation, job, startMsg.ID, startMsg.DeploymentType, jobTimeout(startMsg), startMsg.Retry)
 
 	fmt.Println("Finished running commands on start message ", startMsg.ID)
-	startCmdMutex.Unlock()
 }
 
-func startCmd(sc stan.Conn) {
+// heartbeat reports the job as in progress until the returned func is called.
+func heartbeat(msg *bus.Msg) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(jobHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				err := msg.InProgress()
+				if err != nil {
+					fmt.Println("Failed to report start message in progress: ", err.Error())
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// startCmd pulls jobs from the deployer.start work queue while the deployer holds
+// fewer than it can. The consumer is shared by every deployer so each job only
+// runs once.
+func startCmd() {
 	var err error
+	fmt.Println("Subscribing to deployer.start")
+	jobs, err = messageBus.Queue("deployer.start", "deployer")
+	if err != nil {
+		fmt.Println("Error creating subscription: ", err.Error())
+		os.Exit(1)
+	}
+
 	for {
-		startCmdMutex.Lock()
-		fmt.Println("Subscribing to deployer.start")
-		subCmdStart, err = sc.QueueSubscribe("deployer.start", "deployer", messageStartHandler,
-			stan.DurableName("durable-deployer"), stan.SetManualAckMode(), stan.MaxInflight(1))
+		held <- struct{}{}
+		msg, err := jobs.Next(time.Minute)
 		if err != nil {
-			fmt.Println("Error creating subscription: ", err.Error())
-			os.Exit(1)
-		}
-		_ = <-message
-		fmt.Println("Closing deployer.start")
-		err = subCmdStart.Close()
-		if err != nil {
-			fmt.Println("Error closing connection to deployer.start: ", err.Error())
+			<-held
+			if err != bus.ErrTimeout {
+				fmt.Println("Error fetching from deployer.start: ", err.Error())
+				time.Sleep(5 * time.Second)
+			}
+			continue
 		}
 
+		go func() {
+			defer func() { <-held }()
+			messageStartHandler(msg)
+		}()
 	}
 }
 
-func StartCmdJob(command string, parameters []string, id string, deploymentType string) {
-	runCommand(command, parameters, id, deploymentType)
+func StartCmdJob(operation string, job resolvedJob, id string, deploymentType string, timeout time.Duration, retry *RetryPolicy) {
+	runCommand(operation, job, id, deploymentType, timeout, retry)
 }
 
-func stopCmdJob(id string) {
-	if val, ok := cmdMap[id]; ok {
-		if err := val.cmd.Process.Kill(); err != nil {
-			fmt.Println("failed to kill process: ", err)
-			return
-		}
-		output := CommandOutput{ID: id, Output: "Stopped job.", Running: false, DeploymentType: cmdMap[id].DeploymentType}
-		pubMsg, err := json.Marshal(output)
-		if err != nil {
-			fmt.Println("Failed to convert stdout to json: ", err.Error())
-		}
-		fmt.Println("Publishing: ", string(pubMsg))
-		publishTopic := "deployer.output." + id
-		sc.Publish(publishTopic, pubMsg)
+// rejectDeployment reports a deployment the deployer won't run as failed without
+// running anything.
+func rejectDeployment(deployment Deployment, reason error) {
+	fmt.Printf("Rejecting deployment %v: %v\n", deployment.ID, reason)
+	if deployment.ID == "" {
 		return
 	}
 
-	fmt.Println("No Running process!")
-	return
-}
-
-func runCommand(command string, parameters []string, id string, deploymentType string) error {
-	var err error
+	publishEvent(&events.JobOutput{JobID: deployment.ID, DeploymentType: deployment.DeploymentType, Stream: "stderr", Line: "Rejected: " + reason.Error() + "."})
 
-	if _, ok := cmdMap[id]; ok {
-		fmt.Println("Command already running.")
-		return err
+	// the operation couldn't be read, the ID is all that is known about the job
+	job := resolvedJob{gridID: deployment.ID}
+	if deployment.DeploymentType == "Test" {
+		job = resolvedJob{testID: deployment.ID}
+	}
+	err := publishJobStatus(job, failedStatus(deployment.DeploymentType, "Error"), reason.Error())
+	if err != nil {
+		fmt.Println("Failed to publish the rejection: ", err.Error())
 	}
 
-	data := CommandStruct{}
+	publishEvent(&events.JobFinished{JobID: deployment.ID, DeploymentType: deployment.DeploymentType, Status: events.JobError, Reason: reason.Error(), ExitCode: -1})
+}
 
-	cmdMap[id] = &data
+func stopCmdJob(id string) {
+	stopJob(id, stopCancelled)
+}
 
-	data.ID = id
-	data.cmd = exec.Command(command, parameters...)
-	// SysProcAttr being used to run commands as root
-	//cmd.SysProcAttr = &syscall.SysProcAttr{}
-	//cmd.SysProcAttr.Credential = &syscall.Credential{Uid: 0, Gid: 0}
-	data.cmd.Env = os.Environ()
-	data.OutputStream, err = data.cmd.StdoutPipe()
-	if err != nil {
-		return err
-	}
-	data.ErrorStream, err = data.cmd.StderrPipe()
-	if err != nil {
-		return err
-	}
-	publishTopic := "deployer.output." + id
-	fmt.Println("Publish topic is: ", publishTopic)
+func runCommand(operation string, job resolvedJob, id string, deploymentType string, timeout time.Duration, retry *RetryPolicy) error {
+	attempts := maxAttempts(retry)
+
+	data := CommandStruct{ID: id, DeploymentType: deploymentType, stopped: make(chan struct{})}
+	data.Job = Job{ID: id, DeploymentType: deploymentType, Operation: operation, Command: job.command, Params: job.params, Timeout: timeout.String(), MaxAttempts: attempts}
 
-	go CommandStdOutput(data, publishTopic)
-	go CommandStdError(data, publishTopic)
+	if !registry.add(&data) {
+		fmt.Println("Command already running.")
+		return nil
+	}
 
-	data.cmd.Start()
-	data.CurrentlyRunning = true
-	errUpdate := updateInitialDeploymentStatus(id, deploymentType, parameters)
+	errUpdate := publishJobStatus(job, initialStatus(deploymentType), "")
 	if errUpdate != nil {
-		fmt.Println("Failed to update initial deployment status: ", err.Error())
+		fmt.Println("Failed to update initial deployment status: ", errUpdate.Error())
 	}
 
-	cmdErr := data.cmd.Wait()
-	if cmdErr != nil {
-		fmt.Println("Failed to run command: ", cmdErr.Error())
-		status := DeploymentStatus{ID: id, DeploymentType: deploymentType, Status: "Error", Params: parameters}
-		statusMsg, err := json.Marshal(status)
-		if err != nil {
-			fmt.Println("Failed to convert json: ", err.Error())
+	var err error
+	var exitCode int
+	attempt := 1
+	for {
+		exitCode, err = runAttempt(&data, job.command, job.params, timeout, attempt, attempts)
+		if err == nil || attempt >= attempts || registry.stopReason(id) != "" {
+			break
+		}
+
+		backoff := retryBackoff(retry, attempt)
+		message := fmt.Sprintf("Attempt %v of %v failed: %v. Retrying in %v.", attempt, attempts, err, backoff)
+		fmt.Println(id, message)
+		publishEvent(&events.JobOutput{JobID: id, DeploymentType: deploymentType, Stream: "stdout", Line: message, Attempt: attempt, MaxAttempts: attempts})
+
+		select {
+		case <-time.After(backoff):
+		case <-data.stopped:
 		}
-		sc.Publish("deployer.status", statusMsg)
+		if registry.stopReason(id) != "" {
+			break
+		}
+		attempt++
 	}
-	data.CurrentlyRunning = false
-	output := CommandOutput{ID: data.ID, Running: data.CurrentlyRunning}
-	pubMsg, err2 := json.Marshal(output)
-	if err2 != nil {
-		fmt.Println("Failed to convert stdout to json: ", err.Error())
+
+	finished := events.JobFinished{JobID: id, DeploymentType: deploymentType, Status: events.JobSucceeded, ExitCode: exitCode, Attempt: attempt, MaxAttempts: attempts}
+	stopReason := registry.stopReason(id)
+	if stopReason != "" {
+		finished.Status = stopReason
+		errUpdate = publishJobStatus(job, failedStatus(deploymentType, stopReason), "Job was stopped: "+stopReason)
+		if errUpdate != nil {
+			errUpdate = fmt.Errorf("failed to update stopped deployment status: %v", errUpdate)
+			fmt.Println(errUpdate)
+		}
+	} else if err != nil {
+		fmt.Println("Failed to run command: ", err.Error())
+		finished.Status = events.JobError
+		finished.Reason = err.Error()
+		errUpdate = publishJobStatus(job, failedStatus(deploymentType, "Error"), err.Error())
+		if errUpdate != nil {
+			errUpdate = fmt.Errorf("failed to update failed deployment status: %v", errUpdate)
+			fmt.Println(errUpdate)
+		}
 	}
-	fmt.Println("Publishing: ", string(pubMsg))
-	sc.Publish(publishTopic, pubMsg)
-	sc.Publish("deployer.done", pubMsg)
+	registry.finish(id, exitCode)
+
+	publishEvent(&finished)
 
-	if cmdErr == nil {
-		errUpdate = updateFinalDeploymentStatus(id, deploymentType, parameters)
+	if err == nil && stopReason == "" {
+		errUpdate = publishJobStatus(job, finalStatus(deploymentType), "")
 		if errUpdate != nil {
 			errUpdate = fmt.Errorf("failed to updateDeploymentStatus: %v", errUpdate)
 			fmt.Println(errUpdate)
 		}
+		if deploymentType == "Test" && job.profile != nil {
+			startLoadProfile(job)
+		}
 	}
 
-	delete(cmdMap, id)
 	if err != nil {
 		if exiterr, ok := err.(*exec.ExitError); ok {
 			// The program has exited with an exit code != 0
@@ -278,133 +360,162 @@ func runCommand(command string, parameters []string, id string, deploymentType s
 	return err
 }
 
-func updateInitialDeploymentStatus(id string, deploymentType string, parameters []string) error {
-	var statusUpdates []DeploymentStatus
+// runAttempt runs the command of the job once and returns its exit code once it
+// exited, -1 if it couldn't be started.
+func runAttempt(data *CommandStruct, command string, parameters []string, timeout time.Duration, attempt int, attempts int) (int, error) {
+	var err error
+
+	run := CommandStruct{ID: data.ID, DeploymentType: data.DeploymentType, attempt: attempt, maxAttempts: attempts}
+	run.cmd = exec.Command(command, parameters...)
+	// the command gets its own process group so stopping it also stops everything
+	// ansible started
+	run.cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	// SysProcAttr being used to run commands as root
+	//cmd.SysProcAttr.Credential = &syscall.Credential{Uid: 0, Gid: 0}
+	run.cmd.Env = os.Environ()
+	run.OutputStream, err = run.cmd.StdoutPipe()
+	if err != nil {
+		return -1, err
+	}
+	run.ErrorStream, err = run.cmd.StderrPipe()
+	if err != nil {
+		return -1, err
+	}
+
+	var readers sync.WaitGroup
+	readers.Add(2)
+	go func() {
+		defer readers.Done()
+		CommandStdOutput(run)
+	}()
+	go func() {
+		defer readers.Done()
+		CommandStdError(run)
+	}()
+
+	err = run.cmd.Start()
+	if err != nil {
+		readers.Wait()
+		return -1, err
+	}
+
+	done := make(chan struct{})
+	if !registry.started(data.ID, run.cmd.Process, done, attempt) {
+		// stopped while it was starting
+		go terminate(run.cmd.Process.Pid, done)
+	}
+	timer := time.AfterFunc(timeout, func() {
+		stopJob(data.ID, stopTimeout)
+	})
+
+	// Wait closes the pipes, so the output is read to the end first. That also
+	// publishes every line before the job is reported as finished.
+	readers.Wait()
+	err = run.cmd.Wait()
+	timer.Stop()
+	registry.exited(data.ID)
+	close(done)
+
+	return run.cmd.ProcessState.ExitCode(), err
+}
+
+// jobStatus is what the grid and the test of a job are set to, an empty status
+// leaves them as they are.
+type jobStatus struct {
+	grid string
+	test string
+}
+
+// initialStatus is published when a job of the deployment type starts.
+func initialStatus(deploymentType string) jobStatus {
 	switch deploymentType {
-	case "Grid", "Test":
-		status := DeploymentStatus{ID: id, DeploymentType: deploymentType, Status: "Deploying"}
-		statusUpdates = append(statusUpdates, status)
+	case "Grid":
+		return jobStatus{grid: "Deploying"}
+	case "Test":
+		return jobStatus{test: "Deploying"}
 	case "StopTest":
-		// if there is a grid associated to the deployment mark it as cleaning
-		if len(parameters) > 0 && parameters[0] != "" {
-			gridID := parameters[0]
-			status := DeploymentStatus{ID: gridID, DeploymentType: "Grid", Status: "Cleaning"}
-			statusUpdates = append(statusUpdates, status)
-		}
-		if len(parameters) > 2 && parameters[2] != "" {
-			testID := parameters[2]
-			status := DeploymentStatus{ID: testID, DeploymentType: "Test", Status: "Stopping"}
-			statusUpdates = append(statusUpdates, status)
-		}
-	case "CancelTest":
-		if len(parameters) > 0 && parameters[0] != "" {
-			gridID := parameters[0]
-			status := DeploymentStatus{ID: gridID, DeploymentType: "Grid", Status: "Cleaning"}
-			statusUpdates = append(statusUpdates, status)
-		}
-	case "GridCleanup":
-		status := DeploymentStatus{ID: id, DeploymentType: "Grid", Status: "Cleaning"}
-		statusUpdates = append(statusUpdates, status)
+		return jobStatus{grid: "Cleaning", test: "Stopping"}
+	case "CancelTest", "GridCleanup":
+		return jobStatus{grid: "Cleaning"}
 	}
+	return jobStatus{}
+}
 
-	for i, status := range statusUpdates {
-		err := deploymentMessage(status)
-		if err != nil {
-			err = fmt.Errorf("deploymentMessage %v of %v failed: %v", i+1, len(statusUpdates), err)
-			return err
-		}
+// finalStatus is published when a job of the deployment type succeeded.
+func finalStatus(deploymentType string) jobStatus {
+	switch deploymentType {
+	case "Grid":
+		return jobStatus{grid: "Available"}
+	case "Test":
+		return jobStatus{test: "Deployed"}
+	case "StopTest":
+		return jobStatus{grid: "Available", test: "Stopped"}
+	case "CancelTest", "GridCleanup":
+		return jobStatus{grid: "Available"}
+	case "GridDelete":
+		// swarmhub marks the grid as deleting before the delete script is sent
+		return jobStatus{grid: "Deleted"}
+	case "GridExpire":
+		return jobStatus{grid: "Expired"}
 	}
-	return nil
+	return jobStatus{}
 }
 
-func updateFinalDeploymentStatus(id string, deploymentType string, parameters []string) error {
-	var statusUpdates []DeploymentStatus
+// failedStatus sets what the job was working on to status when it failed or was
+// stopped before it finished.
+func failedStatus(deploymentType string, status string) jobStatus {
 	switch deploymentType {
-	case "Grid":
-		status := DeploymentStatus{ID: id, DeploymentType: deploymentType, Status: "Available"}
-		statusUpdates = append(statusUpdates, status)
 	case "Test":
-		status := DeploymentStatus{ID: id, DeploymentType: deploymentType, Status: "Deployed"}
-		statusUpdates = append(statusUpdates, status)
+		return jobStatus{test: status}
 	case "StopTest":
-		// if there is a grid associated to the deployment mark it available
-		if len(parameters) > 0 && parameters[0] != "" {
-			gridID := parameters[0]
-			status := DeploymentStatus{ID: gridID, DeploymentType: "Grid", Status: "Available"}
-			statusUpdates = append(statusUpdates, status)
-		}
+		return jobStatus{grid: status, test: status}
+	}
+	return jobStatus{grid: status}
+}
 
-		if len(parameters) > 2 && parameters[2] != "" {
-			testID := parameters[2]
-			status := DeploymentStatus{ID: testID, DeploymentType: "Test", Status: "Stopped"}
-			statusUpdates = append(statusUpdates, status)
-		}
-	case "CancelTest":
-		// if there is a grid associated to the deployment mark it available
-		if len(parameters) > 0 && parameters[0] != "" {
-			gridID := parameters[0]
-			status := DeploymentStatus{ID: gridID, DeploymentType: "Grid", Status: "Available"}
-			statusUpdates = append(statusUpdates, status)
-		}
-	case "GridCleanup":
-		status := DeploymentStatus{ID: id, DeploymentType: "Grid", Status: "Available"}
-		statusUpdates = append(statusUpdates, status)
+// publishJobStatus publishes the status changes of the grid and test of the job.
+func publishJobStatus(job resolvedJob, status jobStatus, reason string) error {
+	var statusUpdates []events.Event
+	if status.grid != "" && job.gridID != "" {
+		statusUpdates = append(statusUpdates, &events.GridStatusChanged{GridID: job.gridID, TestID: job.testID, Status: status.grid, Reason: reason})
+	}
+	if status.test != "" && job.testID != "" {
+		statusUpdates = append(statusUpdates, &events.TestStatusChanged{TestID: job.testID, GridID: job.gridID, Status: status.test, Reason: reason})
 	}
 
-	for i, status := range statusUpdates {
-		err := deploymentMessage(status)
+	for i, event := range statusUpdates {
+		err := events.Publish(messageBus, actor, event)
 		if err != nil {
-			err = fmt.Errorf("deploymentMessage %v of %v failed: %v", i+1, len(statusUpdates), err)
+			err = fmt.Errorf("status update %v of %v failed: %v", i+1, len(statusUpdates), err)
 			return err
 		}
 	}
 	return nil
 }
 
-func deploymentMessage(status DeploymentStatus) error {
-	statusMsg, err := json.Marshal(status)
+// publishEvent publishes an event that doesn't change the state of a deployment,
+// failures are only logged.
+func publishEvent(event events.Event) {
+	err := events.Publish(messageBus, actor, event)
 	if err != nil {
-		err = fmt.Errorf("failed to convert json: %v", err)
-		return err
+		fmt.Println(err)
 	}
-	err = sc.Publish("deployer.status", statusMsg)
-	if err != nil {
-		err = fmt.Errorf("failed to publish message: %v", err)
-		return err
-	}
-	return nil
 }
 
-func CommandStdOutput(cmd CommandStruct, publishTopic string) {
-	output := CommandOutput{ID: cmd.ID, Running: true, StreamType: "stdin"}
-	scanner := bufio.NewScanner(cmd.OutputStream)
-	for scanner.Scan() {
-		output.Output = scanner.Text()
-		data, err := json.Marshal(output)
-		if err != nil {
-			fmt.Println("Failed to convert stdout to json: ", err.Error())
-		}
-		fmt.Println("Publishing: ", string(data))
-		err = sc.Publish(publishTopic, data)
-		if err != nil {
-			fmt.Println("Failed to publish to topic: ", err.Error())
-		}
-	}
+func CommandStdOutput(cmd CommandStruct) {
+	publishStream(cmd, cmd.OutputStream, "stdout")
+}
+
+func CommandStdError(cmd CommandStruct) {
+	publishStream(cmd, cmd.ErrorStream, "stderr")
 }
 
-func CommandStdError(cmd CommandStruct, publishTopic string) {
-	output := CommandOutput{ID: cmd.ID, Running: true, StreamType: "stderr"}
-	scanner := bufio.NewScanner(cmd.ErrorStream)
+// publishStream publishes every line of the stream of the command.
+func publishStream(cmd CommandStruct, stream io.Reader, name string) {
+	scanner := bufio.NewScanner(stream)
 	for scanner.Scan() {
-		output.Output = scanner.Text()
-		data, err := json.Marshal(output)
-		if err != nil {
-			fmt.Println("Failed to convert stderr to json: ", err.Error())
-		}
-		fmt.Println("Publishing: ", string(data))
-		err = sc.Publish(publishTopic, data)
-		if err != nil {
-			fmt.Println("Failed to publish to topic: ", err.Error())
-		}
+		line := scanner.Text()
+		fmt.Println(cmd.ID, name+":", line)
+		publishEvent(&events.JobOutput{JobID: cmd.ID, DeploymentType: cmd.DeploymentType, Stream: name, Line: line, Attempt: cmd.attempt, MaxAttempts: cmd.maxAttempts})
 	}
 }
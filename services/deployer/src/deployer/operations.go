@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/common/operations"
+)
+
+// operation is how a deployer runs a named operation for a provider.
+type operation struct {
+	command string
+	// deploymentTypes are the deployment types the operation may be sent with,
+	// they decide which statuses are published.
+	deploymentTypes []string
+	// args validates the arguments of the operation and returns the parameters of
+	// the command with the grid and test it works on.
+	args func(raw json.RawMessage) (resolvedJob, error)
+}
+
+// operationsByProvider holds every operation the deployer runs. Anything else it
+// is sent is rejected.
+var operationsByProvider = map[string]map[string]operation{
+	"AWS": {
+		operations.ProvisionGrid: {command: "/ansible/gridProvision.sh", deploymentTypes: []string{"Grid"}, args: ec2ProvisionGridParams},
+		operations.DeployTest:    {command: "/ansible/deployTest.sh", deploymentTypes: []string{"Test"}, args: ec2DeployTestParams},
+		operations.CleanupGrid:   {command: "/ansible/gridCleanup.sh", deploymentTypes: []string{"StopTest", "CancelTest", "GridCleanup"}, args: cleanupGridParams},
+	},
+	"local": {
+		operations.ProvisionGrid: {command: "/local/gridProvision.sh", deploymentTypes: []string{"Grid"}, args: localProvisionGridParams},
+		operations.DeployTest:    {command: "/local/deployTest.sh", deploymentTypes: []string{"Test"}, args: localDeployTestParams},
+		operations.CleanupGrid:   {command: "/local/gridCleanup.sh", deploymentTypes: []string{"StopTest", "CancelTest", "GridCleanup"}, args: cleanupGridParams},
+		operations.DeleteGrid:    {command: "/local/gridDelete.sh", deploymentTypes: []string{"GridDelete", "GridExpire"}, args: deleteGridParams},
+	},
+}
+
+// resolvedJob is the command a deployment runs and what it works on.
+type resolvedJob struct {
+	command string
+	params  []string
+	gridID  string
+	testID  string
+	// profile is run against the locust master at masterURL once a test is deployed.
+	profile   *operations.LoadProfile
+	masterURL string
+}
+
+// resolveOperation validates the operation of the deployment and maps it to its command.
+func resolveOperation(deployment Deployment) (resolvedJob, error) {
+	var job resolvedJob
+
+	op, ok := operationsByProvider[deployment.Provider][deployment.Operation]
+	if !ok {
+		err := fmt.Errorf("unknown operation %q for provider %q", deployment.Operation, deployment.Provider)
+		return job, err
+	}
+
+	allowed := false
+	for _, deploymentType := range op.deploymentTypes {
+		if deploymentType == deployment.DeploymentType {
+			allowed = true
+		}
+	}
+	if !allowed {
+		err := fmt.Errorf("operation %v can't be sent as deployment type %q", deployment.Operation, deployment.DeploymentType)
+		return job, err
+	}
+
+	job, err := op.args(deployment.Args)
+	if err != nil {
+		err = fmt.Errorf("invalid arguments for %v: %v", deployment.Operation, err)
+		return job, err
+	}
+
+	job.command = op.command
+	if deployment.DeploymentType == "Test" {
+		// a test is deployed with the test ID
+		job.testID = deployment.ID
+	}
+	return job, nil
+}
+
+// decodeArgs unmarshals the arguments and validates them.
+func decodeArgs(raw json.RawMessage, args interface{ Validate() error }) error {
+	if len(raw) == 0 {
+		return fmt.Errorf("no arguments")
+	}
+	err := json.Unmarshal(raw, args)
+	if err != nil {
+		return err
+	}
+	return args.Validate()
+}
+
+func ec2ProvisionGridParams(raw json.RawMessage) (resolvedJob, error) {
+	var args operations.ProvisionGridArgs
+	err := decodeArgs(raw, &args)
+	if err != nil {
+		return resolvedJob{}, err
+	}
+	if args.Expires <= 0 {
+		return resolvedJob{}, fmt.Errorf("Expires is required")
+	}
+
+	masterGroups, err := jsonList(args.MasterSecurityGroups)
+	if err != nil {
+		return resolvedJob{}, err
+	}
+	slaveGroups, err := jsonList(args.SlaveSecurityGroups)
+	if err != nil {
+		return resolvedJob{}, err
+	}
+
+	params := []string{args.GridID, args.Region, args.MasterType, args.SlaveType, strconv.Itoa(args.Nodes), strconv.FormatInt(args.Expires, 10), masterGroups, slaveGroups}
+	return resolvedJob{params: params, gridID: args.GridID}, nil
+}
+
+func localProvisionGridParams(raw json.RawMessage) (resolvedJob, error) {
+	var args operations.ProvisionGridArgs
+	err := decodeArgs(raw, &args)
+	if err != nil {
+		return resolvedJob{}, err
+	}
+
+	params := []string{args.GridID, args.Region, args.MasterType, args.SlaveType, strconv.Itoa(args.Nodes)}
+	return resolvedJob{params: params, gridID: args.GridID}, nil
+}
+
+func ec2DeployTestParams(raw json.RawMessage) (resolvedJob, error) {
+	var args operations.DeployTestArgs
+	err := decodeArgs(raw, &args)
+	if err != nil {
+		return resolvedJob{}, err
+	}
+	if args.ScriptID == "" {
+		return resolvedJob{}, fmt.Errorf("ScriptID is required")
+	}
+	if args.Profile != nil && args.MasterURL == "" {
+		return resolvedJob{}, fmt.Errorf("MasterURL is required to run a Profile")
+	}
+
+	params := []string{args.ScriptID, args.ScriptFilename, args.GridID, args.Region, strconv.FormatBool(args.StartAutomatically)}
+	return resolvedJob{params: params, gridID: args.GridID, profile: args.Profile, masterURL: args.MasterURL}, nil
+}
+
+// localDeployTestParams needs a url for the scripts, the deployer has no s3
+// credentials of its own. The locust master runs on this host so its profile is
+// run against localMasterURL.
+func localDeployTestParams(raw json.RawMessage) (resolvedJob, error) {
+	var args operations.DeployTestArgs
+	err := decodeArgs(raw, &args)
+	if err != nil {
+		return resolvedJob{}, err
+	}
+	if args.ScriptURL == "" {
+		return resolvedJob{}, fmt.Errorf("ScriptURL is required")
+	}
+
+	params := []string{args.ScriptURL, args.ScriptFilename, args.GridID, args.Region, strconv.FormatBool(args.StartAutomatically)}
+	return resolvedJob{params: params, gridID: args.GridID, profile: args.Profile, masterURL: localMasterURL()}, nil
+}
+
+// cleanupGridParams passes the test as the third parameter, the scripts ignore it.
+func cleanupGridParams(raw json.RawMessage) (resolvedJob, error) {
+	var args operations.CleanupGridArgs
+	err := decodeArgs(raw, &args)
+	if err != nil {
+		return resolvedJob{}, err
+	}
+
+	params := []string{args.GridID, args.Region, args.TestID}
+	return resolvedJob{params: params, gridID: args.GridID, testID: args.TestID}, nil
+}
+
+func deleteGridParams(raw json.RawMessage) (resolvedJob, error) {
+	var args operations.DeleteGridArgs
+	err := decodeArgs(raw, &args)
+	if err != nil {
+		return resolvedJob{}, err
+	}
+
+	params := []string{args.GridID, args.Region}
+	return resolvedJob{params: params, gridID: args.GridID}, nil
+}
+
+// jsonList formats a list the way the ansible extra vars expect it.
+func jsonList(list []string) (string, error) {
+	if list == nil {
+		list = []string{}
+	}
+	b, err := json.Marshal(list)
+	if err != nil {
+		return "", fmt.Errorf("failed to convert list to json: %v", err)
+	}
+	return string(b), nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/common/bus"
+	"github.com/att-cloudnative-labs/swarmhub/services/common/events"
+	"github.com/att-cloudnative-labs/swarmhub/services/common/locust"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// jwtKeyFile is the key the tokens of the locust-go proxy on AWS masters are
+// signed with, the same file the playbook copies to the masters. Set with
+// DEPLOYER_JWT_KEY_FILE.
+var jwtKeyFile = "/etc/jwt/jwt"
+
+// localWebPort is the port the web UI of local masters is published on, the
+// LOCUST_WEB_PORT of the local scripts.
+var localWebPort = "8089"
+
+func loadProfilesFromEnv() {
+	if file := os.Getenv("DEPLOYER_JWT_KEY_FILE"); file != "" {
+		jwtKeyFile = file
+	}
+	if port := os.Getenv("LOCUST_WEB_PORT"); port != "" {
+		localWebPort = port
+	}
+}
+
+func localMasterURL() string {
+	return "http://localhost:" + localWebPort
+}
+
+// profileRun is a load profile being run for a test.
+type profileRun struct {
+	testID string
+	gridID string
+	cancel context.CancelFunc
+}
+
+// profileRuns are the load profiles this deployer runs, by test ID.
+var profileRuns = struct {
+	sync.Mutex
+	runs map[string]*profileRun
+}{runs: map[string]*profileRun{}}
+
+// startLoadProfile drives the locust master of a test that was just deployed
+// through the stages of its profile. It runs outside of the job so the grid is
+// free for the job stopping the test.
+func startLoadProfile(job resolvedJob) {
+	client, err := profileClient(job)
+	if err != nil {
+		fmt.Printf("Unable to run the load profile of test %v: %v\n", job.testID, err)
+		publishJobStatus(job, jobStatus{test: "Error"}, "unable to run the load profile: "+err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &profileRun{testID: job.testID, gridID: job.gridID, cancel: cancel}
+
+	profileRuns.Lock()
+	if previous, ok := profileRuns.runs[job.testID]; ok {
+		previous.cancel()
+	}
+	profileRuns.runs[job.testID] = run
+	profileRuns.Unlock()
+
+	go func() {
+		defer func() {
+			profileRuns.Lock()
+			if profileRuns.runs[job.testID] == run {
+				delete(profileRuns.runs, job.testID)
+			}
+			profileRuns.Unlock()
+			cancel()
+		}()
+
+		started := false
+		err := locust.RunProfile(ctx, client, *job.profile, func(line string) {
+			fmt.Println(job.testID, "profile:", line)
+			if !started {
+				started = true
+				publishJobStatus(job, jobStatus{test: "Running"}, line)
+			}
+		})
+		if err == context.Canceled {
+			fmt.Printf("Load profile of test %v was stopped.\n", job.testID)
+			return
+		}
+		if err != nil {
+			fmt.Printf("Load profile of test %v failed: %v\n", job.testID, err)
+			publishJobStatus(job, jobStatus{test: "Error"}, "load profile failed: "+err.Error())
+			return
+		}
+		if _, ends := job.profile.TotalDuration(); ends {
+			publishEvent(&events.LoadProfileFinished{TestID: job.testID, GridID: job.gridID})
+		}
+	}()
+}
+
+// profileClient returns a client for the master of the job. The masters of AWS
+// grids sit behind the locust-go proxy, which wants a token and uses a self
+// signed certificate.
+func profileClient(job resolvedJob) (*locust.Client, error) {
+	if !strings.HasPrefix(job.masterURL, "https://") {
+		return locust.NewClient(job.masterURL, "", false), nil
+	}
+
+	token, err := profileToken()
+	if err != nil {
+		return nil, err
+	}
+	return locust.NewClient(job.masterURL, token, true), nil
+}
+
+// profileToken signs a token for the locust-go proxy.
+func profileToken() (string, error) {
+	key, err := ioutil.ReadFile(jwtKeyFile)
+	if err != nil {
+		err = fmt.Errorf("unable to read the jwt key: %v", err)
+		return "", err
+	}
+
+	claims := jwt.StandardClaims{
+		Subject:   actor,
+		Issuer:    "swarmhub",
+		ExpiresAt: time.Now().Add(24 * time.Hour).Unix(),
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+	if err != nil {
+		err = fmt.Errorf("unable to sign the token: %v", err)
+		return "", err
+	}
+	return token, nil
+}
+
+// stopLoadProfiles stops the load profiles of the test or grid id.
+func stopLoadProfiles(id string) {
+	profileRuns.Lock()
+	defer profileRuns.Unlock()
+	for _, run := range profileRuns.runs {
+		if run.testID == id || run.gridID == id {
+			run.cancel()
+		}
+	}
+}
+
+// runningTestStatuses are the statuses of a test whose load profile keeps running.
+var runningTestStatuses = map[string]bool{"Deployed": true, "Launching": true, "Launched": true, "Running": true}
+
+// busyGridStatuses are the statuses of a grid that still has its test deployed.
+var busyGridStatuses = map[string]bool{"Deployed": true}
+
+// profileStatusHandler stops the load profile of a test once the test or its grid
+// moves on, e.g. because the test is being stopped by another deployer.
+func profileStatusHandler(m *bus.Msg) {
+	event, err := events.Decode(m.Data)
+	if err != nil {
+		return
+	}
+
+	switch e := event.(type) {
+	case *events.TestStatusChanged:
+		if !runningTestStatuses[e.Status] {
+			stopLoadProfiles(e.TestID)
+		}
+	case *events.GridStatusChanged:
+		if !busyGridStatuses[e.Status] {
+			stopLoadProfiles(e.GridID)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// finishedJobsKept is how many finished jobs are kept for inspection.
+const finishedJobsKept = 50
+
+// Job describes a command the deployer is running or has run.
+type Job struct {
+	ID             string
+	DeploymentType string
+	Operation      string
+	// Command and Params are what the operation was mapped to.
+	Command   string
+	Params    []string
+	PID       int
+	StartTime time.Time
+	EndTime   *time.Time `json:",omitempty"`
+	// Duration is how long the job has been running, or ran for once it finished.
+	Duration string
+	ExitCode *int `json:",omitempty"`
+	Running  bool
+	Timeout  string
+	// Attempt is the attempt that is running or ran last.
+	Attempt     int
+	MaxAttempts int
+	// StopReason is Timeout or Cancelled when the job was stopped before it finished.
+	StopReason string `json:",omitempty"`
+}
+
+// jobRegistry keeps the running commands by deployment ID and the most recently
+// finished jobs.
+type jobRegistry struct {
+	mu       sync.Mutex
+	running  map[string]*CommandStruct
+	finished []Job
+}
+
+var registry = &jobRegistry{running: make(map[string]*CommandStruct)}
+
+// add registers the command, false is returned if a command for its ID is already
+// running.
+func (r *jobRegistry) add(data *CommandStruct) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.running[data.ID]; ok {
+		return false
+	}
+	data.Job.StartTime = time.Now()
+	data.Job.Running = true
+	data.CurrentlyRunning = true
+	r.running[data.ID] = data
+	return true
+}
+
+// stop records why the job of id is being stopped and returns it with the process
+// of its running attempt, which is nil while it waits to retry. The first reason is
+// kept when a job is stopped twice, ok is false if the job isn't running.
+func (r *jobRegistry) stop(id string, reason string) (data *CommandStruct, process *os.Process, done <-chan struct{}, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data, ok = r.running[id]
+	if !ok {
+		return nil, nil, nil, false
+	}
+	if data.stopReason == "" {
+		data.stopReason = reason
+		data.Job.StopReason = reason
+		close(data.stopped)
+	}
+	return data, data.process, data.done, true
+}
+
+func (r *jobRegistry) stopReason(id string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if data, ok := r.running[id]; ok {
+		return data.stopReason
+	}
+	return ""
+}
+
+// started records the process of an attempt once it is running. false is returned
+// when the job was stopped in the meantime.
+func (r *jobRegistry) started(id string, process *os.Process, done chan struct{}, attempt int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data, ok := r.running[id]
+	if !ok {
+		return true
+	}
+	data.process = process
+	data.done = done
+	data.Job.PID = process.Pid
+	data.Job.Attempt = attempt
+	return data.stopReason == ""
+}
+
+// exited forgets the process of the attempt that just exited.
+func (r *jobRegistry) exited(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if data, ok := r.running[id]; ok {
+		data.process = nil
+		data.done = nil
+	}
+}
+
+// finish moves the command to the finished jobs.
+func (r *jobRegistry) finish(id string, exitCode int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data, ok := r.running[id]
+	if !ok {
+		return
+	}
+	delete(r.running, id)
+
+	endTime := time.Now()
+	data.CurrentlyRunning = false
+	data.Job.Running = false
+	data.Job.EndTime = &endTime
+	data.Job.ExitCode = &exitCode
+
+	r.finished = append(r.finished, data.Job)
+	if len(r.finished) > finishedJobsKept {
+		r.finished = r.finished[len(r.finished)-finishedJobsKept:]
+	}
+}
+
+// jobs returns the running jobs followed by the finished ones, newest first.
+func (r *jobRegistry) jobs() []Job {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	var running []Job
+	for _, data := range r.running {
+		job := data.Job
+		if !job.StartTime.IsZero() {
+			job.Duration = now.Sub(job.StartTime).Round(time.Second).String()
+		}
+		running = append(running, job)
+	}
+	sort.Slice(running, func(i, j int) bool { return running[i].StartTime.After(running[j].StartTime) })
+
+	jobs := running
+	for i := len(r.finished) - 1; i >= 0; i-- {
+		job := r.finished[i]
+		if !job.StartTime.IsZero() {
+			job.Duration = job.EndTime.Sub(job.StartTime).Round(time.Second).String()
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+// jobsResponse is returned by the jobs endpoint. Host tells the deployers apart
+// when swarmhub collects the jobs of all of them.
+type jobsResponse struct {
+	Host string
+	Jobs []Job
+}
+
+func loadHTTPPortFromEnv() string {
+	port := os.Getenv("DEPLOYER_HTTP_PORT")
+	if port == "" {
+		port = "8080"
+	}
+	return port
+}
+
+// serveJobs serves GET /jobs with every job and GET /jobs/<id> with the latest job
+// of the deployment.
+func serveJobs(port string, hostname string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jobs", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, jobsResponse{Host: hostname, Jobs: registry.jobs()})
+	})
+	mux.HandleFunc("/jobs/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/jobs/")
+		for _, job := range registry.jobs() {
+			if job.ID == id {
+				writeJSON(w, job)
+				return
+			}
+		}
+		http.Error(w, "No job for "+id+".", http.StatusNotFound)
+	})
+
+	fmt.Println("Serving jobs on port", port)
+	err := http.ListenAndServe(":"+port, mux)
+	if err != nil {
+		fmt.Println("Failed to serve jobs: ", err.Error())
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "Failed to convert to json.", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// RetryPolicy says how often a failed job is run again. A job that timed out or
+// was cancelled is never retried.
+type RetryPolicy struct {
+	// MaxAttempts is how often the command is run at most, including the first run.
+	MaxAttempts int
+	// Backoff is the wait before the second attempt as a duration, e.g. "30s". It
+	// doubles with every attempt after that up to MaxBackoff.
+	Backoff    string
+	MaxBackoff string
+}
+
+// defaultBackoff and defaultMaxBackoff are used when a retry policy doesn't set
+// them or they can't be parsed.
+var (
+	defaultBackoff    = 30 * time.Second
+	defaultMaxBackoff = 5 * time.Minute
+)
+
+// maxAttempts is how often a deployment may be run, a deployment without a retry
+// policy is run once.
+func maxAttempts(policy *RetryPolicy) int {
+	if policy == nil || policy.MaxAttempts < 1 {
+		return 1
+	}
+	return policy.MaxAttempts
+}
+
+// retryBackoff is how long to wait after the given attempt failed.
+func retryBackoff(policy *RetryPolicy, attempt int) time.Duration {
+	backoff := parseBackoff(policy.Backoff, defaultBackoff)
+	maxBackoff := parseBackoff(policy.MaxBackoff, defaultMaxBackoff)
+
+	for i := 1; i < attempt && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff
+}
+
+func parseBackoff(raw string, fallback time.Duration) time.Duration {
+	if raw == "" {
+		return fallback
+	}
+	backoff, err := time.ParseDuration(raw)
+	if err != nil || backoff < 0 {
+		fmt.Printf("Backoff %q is not a duration, using %v.\n", raw, fallback)
+		return fallback
+	}
+	return backoff
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/common/events"
+)
+
+// defaultTimeouts is how long a job of each deployment type may run when the
+// deployment doesn't set a timeout. They can be changed with
+// DEPLOYER_TIMEOUT_<TYPE>, e.g. DEPLOYER_TIMEOUT_GRID=45m.
+var defaultTimeouts = map[string]time.Duration{
+	"Grid":        30 * time.Minute,
+	"Test":        20 * time.Minute,
+	"StopTest":    15 * time.Minute,
+	"CancelTest":  15 * time.Minute,
+	"GridCleanup": 15 * time.Minute,
+	"GridDelete":  15 * time.Minute,
+	"GridExpire":  15 * time.Minute,
+}
+
+// fallbackTimeout is used for deployment types without a default.
+var fallbackTimeout = time.Hour
+
+// gracePeriod is how long a job has to exit after SIGTERM before it is killed, set
+// with DEPLOYER_GRACE_PERIOD.
+var gracePeriod = 30 * time.Second
+
+// Reasons a job was stopped before it finished, they are published as the status
+// of the deployment.
+const (
+	stopTimeout   = "Timeout"
+	stopCancelled = "Cancelled"
+)
+
+func loadTimeoutsFromEnv() {
+	for deploymentType := range defaultTimeouts {
+		name := "DEPLOYER_TIMEOUT_" + strings.ToUpper(deploymentType)
+		if timeout, ok := durationFromEnv(name); ok {
+			defaultTimeouts[deploymentType] = timeout
+		}
+	}
+	if grace, ok := durationFromEnv("DEPLOYER_GRACE_PERIOD"); ok {
+		gracePeriod = grace
+	}
+}
+
+func durationFromEnv(name string) (time.Duration, bool) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return 0, false
+	}
+	duration, err := time.ParseDuration(raw)
+	if err != nil || duration <= 0 {
+		fmt.Printf("%v %q is not a positive duration, ignoring it.\n", name, raw)
+		return 0, false
+	}
+	return duration, true
+}
+
+// jobTimeout is the timeout set on the deployment or the default of its type.
+func jobTimeout(deployment Deployment) time.Duration {
+	if deployment.Timeout != "" {
+		timeout, err := time.ParseDuration(deployment.Timeout)
+		if err == nil && timeout > 0 {
+			return timeout
+		}
+		fmt.Printf("Timeout %q of %v is not a positive duration, using the default.\n", deployment.Timeout, deployment.ID)
+	}
+	if timeout, ok := defaultTimeouts[deployment.DeploymentType]; ok {
+		return timeout
+	}
+	return fallbackTimeout
+}
+
+// stopJob stops the job of id, reason is published as its status once it exited.
+func stopJob(id string, reason string) {
+	data, process, done, ok := registry.stop(id, reason)
+	if !ok {
+		fmt.Println("No Running process!")
+		return
+	}
+
+	fmt.Printf("Stopping job %v: %v\n", id, reason)
+	publishEvent(&events.JobOutput{JobID: id, DeploymentType: data.DeploymentType, Stream: "stdout", Line: "Stopping job: " + reason + "."})
+	if process != nil {
+		terminate(process.Pid, done)
+	}
+}
+
+// terminate sends SIGTERM to the process group of the job so the children of
+// ansible are stopped as well, and kills the group if it is still running after
+// the grace period.
+func terminate(pid int, done <-chan struct{}) {
+	err := syscall.Kill(-pid, syscall.SIGTERM)
+	if err != nil {
+		fmt.Printf("failed to send SIGTERM to process group %v: %v\n", pid, err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(gracePeriod):
+		fmt.Printf("Process group %v still running after %v, killing it.\n", pid, gracePeriod)
+		err = syscall.Kill(-pid, syscall.SIGKILL)
+		if err != nil {
+			fmt.Printf("failed to kill process group %v: %v\n", pid, err)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/common/bus"
+)
+
+// defaultWorkers is how many jobs a deployer runs at once when DEPLOYER_WORKERS
+// is not set.
+const defaultWorkers = 4
+
+// defaultMaxWaiting is how many jobs a deployer holds while their grid is busy
+// when DEPLOYER_MAX_WAITING is not set.
+const defaultMaxWaiting = 16
+
+// gridLockRetry is how often a job waiting for a grid locked by another deployer
+// tries to take it.
+var gridLockRetry = 5 * time.Second
+
+var (
+	// workers limits the jobs that are running.
+	workers chan struct{}
+	// held limits the jobs taken from deployer.start that have not finished yet,
+	// running or waiting for their grid. Waiting jobs don't take a worker.
+	held chan struct{}
+
+	deploymentLocksMutex sync.Mutex
+	// deploymentLocks holds the jobs waiting on a grid, in the order they arrived.
+	// A grid is locked while it has an entry.
+	deploymentLocks = make(map[string][]chan struct{})
+)
+
+func loadWorkersFromEnv() {
+	count := defaultWorkers
+	if raw := os.Getenv("DEPLOYER_WORKERS"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			fmt.Printf("DEPLOYER_WORKERS %q is not a positive number, using %v.\n", raw, defaultWorkers)
+		} else {
+			count = n
+		}
+	}
+	fmt.Println("Running up to", count, "jobs at once.")
+	workers = make(chan struct{}, count)
+
+	waiting := defaultMaxWaiting
+	if raw := os.Getenv("DEPLOYER_MAX_WAITING"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			fmt.Printf("DEPLOYER_MAX_WAITING %q is not a number, using %v.\n", raw, defaultMaxWaiting)
+		} else {
+			waiting = n
+		}
+	}
+	held = make(chan struct{}, count+waiting)
+}
+
+// lockGrid waits until the grid isn't locked by any deployer and locks it, the
+// returned func unlocks it. The lock is refreshed while it is held, so it is only
+// released by itself when this deployer dies.
+func lockGrid(gridID string) func() {
+	if gridID == "" {
+		return func() {}
+	}
+
+	var lock bus.Lock
+	for waited := false; ; waited = true {
+		var ok bool
+		var err error
+		lock, ok, err = messageBus.Lock("grid-"+gridID, actor)
+		if err != nil {
+			fmt.Println(err)
+		}
+		if ok {
+			break
+		}
+		if !waited && err == nil {
+			fmt.Println("Waiting for the job of another deployer on", gridID, "to finish.")
+		}
+		time.Sleep(gridLockRetry)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(bus.LockTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				err := lock.Refresh()
+				if err != nil {
+					fmt.Println(err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		err := lock.Unlock()
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+// lockDeployment waits until no other job is running for key. Jobs waiting on the
+// same key get the lock in the order they called lockDeployment.
+func lockDeployment(key string) {
+	deploymentLocksMutex.Lock()
+	waiting, locked := deploymentLocks[key]
+	if !locked {
+		deploymentLocks[key] = nil
+		deploymentLocksMutex.Unlock()
+		return
+	}
+
+	turn := make(chan struct{})
+	deploymentLocks[key] = append(waiting, turn)
+	deploymentLocksMutex.Unlock()
+
+	fmt.Println("Waiting for the running job of", key, "to finish.")
+	<-turn
+}
+
+// unlockDeployment hands the lock of key to the next waiting job.
+func unlockDeployment(key string) {
+	deploymentLocksMutex.Lock()
+	defer deploymentLocksMutex.Unlock()
+
+	waiting := deploymentLocks[key]
+	if len(waiting) == 0 {
+		delete(deploymentLocks, key)
+		return
+	}
+	deploymentLocks[key] = waiting[1:]
+	close(waiting[0])
+}
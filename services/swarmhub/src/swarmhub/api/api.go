@@ -5,89 +5,218 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
+	"github.com/att-cloudnative-labs/swarmhub/services/common/bus"
+	"github.com/att-cloudnative-labs/swarmhub/services/common/events"
 	"github.com/julienschmidt/httprouter"
-	"github.com/nats-io/stan.go"
 )
 
 var PaginationItems = 10
 var ShuttingDown bool
 
+// DeploymentLog is a line of the output of a deployment. The last one has Running
+// set to false and the Status the job finished with.
 type DeploymentLog struct {
-	ID         string
-	StreamType string
-	Output     string
-	Running    bool
-	Timestamp  int64
-	Sequence   uint64
+	ID          string
+	StreamType  string
+	Output      string
+	Running     bool
+	Status      string `json:",omitempty"`
+	Attempt     int    `json:",omitempty"`
+	MaxAttempts int    `json:",omitempty"`
+	Timestamp   int64
+	Sequence    uint64
 }
 
 func deployerLogs(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	subscriptionTopic := "deployer.output." + ps.ByName("id")
+	id := ps.ByName("id")
 
 	if ShuttingDown {
 		http.Error(w, "Can't view, in the process of shutting down.", http.StatusInternalServerError)
 		return
 	}
 
-	fmt.Println("subscribing to topic: ", subscriptionTopic)
-
-	var logsList []DeploymentLog
-	var logTime int64
+	logsList, err := readDeploymentLogs(id)
+	if err != nil {
+		w.Write([]byte("Error subscribing to nats."))
+		return
+	}
 
-	sub, err := sc.Subscribe(subscriptionTopic, func(msg *stan.Msg) {
-		deploymentLog := DeploymentLog{}
-		err := json.Unmarshal(msg.Data, &deploymentLog)
+	// older messages have been removed from the stream, the archive still has them
+	if len(logsList) == 0 || deploymentLogsMayBeIncomplete() {
+		archived, err := archivedDeploymentLogs(id)
 		if err != nil {
-			fmt.Println("Unable to convert deployment log to struct: ", err.Error())
+			fmt.Println(err)
 		}
-		logTime = msg.Timestamp
-		deploymentLog.Timestamp = logTime / 1000000
-		deploymentLog.Sequence = msg.Sequence
+		logsList = mergeDeploymentLogs(archived, logsList)
+	}
 
-		logsList = append(logsList, deploymentLog)
-	}, stan.DeliverAllAvailable())
+	if len(logsList) == 0 {
+		w.Write([]byte("No log messages."))
+		return
+	}
 
+	jsonResponse, err := json.Marshal(logsList)
 	if err != nil {
-		w.Write([]byte("Error subscribing to nats."))
+		fmt.Println("Error converting logs to json: ", err.Error())
 		return
 	}
 
-	previouslyDelivered := int64(0)
+	w.Write(jsonResponse)
+}
+
+// deploymentLogFromMsg converts the JobOutput or JobFinished event of a message of
+// deployer.output.<id>. The sequence is the sequence of the message in the events
+// stream.
+func deploymentLogFromMsg(msg *bus.Msg) (DeploymentLog, error) {
+	var deploymentLog DeploymentLog
+	event, err := events.Decode(msg.Data)
+	if err != nil {
+		err = fmt.Errorf("unable to convert deployment log to struct: %v", err)
+		return deploymentLog, err
+	}
+
+	switch e := event.(type) {
+	case *events.JobOutput:
+		deploymentLog = DeploymentLog{ID: e.JobID, StreamType: e.Stream, Output: e.Line, Running: true, Attempt: e.Attempt, MaxAttempts: e.MaxAttempts}
+	case *events.JobFinished:
+		deploymentLog = DeploymentLog{ID: e.JobID, Output: e.Reason, Running: false, Status: e.Status, Attempt: e.Attempt, MaxAttempts: e.MaxAttempts}
+	default:
+		err = fmt.Errorf("unexpected event on %v: %T", msg.Subject, event)
+		return deploymentLog, err
+	}
+	deploymentLog.Timestamp = msg.Timestamp.UnixNano() / 1000000
+	deploymentLog.Sequence = msg.Sequence
 
-	var currentTime int64
-	for {
-		time.Sleep(2 * time.Millisecond)
-		currentTime = time.Now().UnixNano()
-		delivered, err := sub.Delivered()
-		pending, _, err := sub.Pending()
+	return deploymentLog, nil
+}
+
+// readDeploymentLogs returns all of the messages of the deployment that are still
+// in the events stream.
+func readDeploymentLogs(id string) ([]DeploymentLog, error) {
+	subscriptionTopic := "deployer.output." + id
+	fmt.Println("reading topic: ", subscriptionTopic)
+
+	msgs, err := messageBus.Replay(subscriptionTopic)
+
+	var logsList []DeploymentLog
+	for _, msg := range msgs {
+		deploymentLog, err := deploymentLogFromMsg(msg)
 		if err != nil {
-			fmt.Println("Error reading pending or delivered: ", err.Error())
-			break
+			fmt.Println(err)
+			continue
 		}
+		logsList = append(logsList, deploymentLog)
+	}
+
+	return logsList, err
+}
 
-		if delivered == 0 && pending == 0 {
-			w.Write([]byte("No log messages."))
-			sub.Unsubscribe()
+// deploymentLogsMayBeIncomplete reports if messages have been removed from the
+// events stream, in which case older logs can only be found in the archive.
+func deploymentLogsMayBeIncomplete() bool {
+	truncated, err := messageBus.Truncated("deployer.output.>")
+	if err != nil {
+		fmt.Println("unable to check if deployment logs were removed:", err)
+	}
+	return truncated
+}
+
+// LogStreamKeepAlive is how often a comment is sent on an idle log stream so
+// proxies don't close it.
+var LogStreamKeepAlive = 15 * time.Second
+
+// deployerLogsStream streams the deployment logs as server-sent events. History is
+// replayed from the sequence in ?since= or the Last-Event-ID header, and new lines
+// are pushed until the deployer reports the command is no longer running.
+func deployerLogsStream(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	subscriptionTopic := "deployer.output." + ps.ByName("id")
+
+	if ShuttingDown {
+		http.Error(w, "Can't view, in the process of shutting down.", http.StatusInternalServerError)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported.", http.StatusInternalServerError)
+		return
+	}
+
+	startOption := bus.DeliverAll()
+	if since := r.URL.Query().Get("since"); since != "" {
+		sequence, err := strconv.ParseUint(since, 10, 64)
+		if err != nil {
+			http.Error(w, "since must be a sequence number.", http.StatusBadRequest)
 			return
 		}
-
-		if (pending == 0 && delivered == previouslyDelivered) || logTime > currentTime {
-			fmt.Printf("Delivered: %v, Previously Deliverred: %v, Current Time: %v, Log Time: %v\n", delivered, previouslyDelivered, currentTime, logTime)
-			break
+		startOption = bus.StartSequence(sequence)
+	} else if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		sequence, err := strconv.ParseUint(lastEventID, 10, 64)
+		if err == nil {
+			startOption = bus.StartSequence(sequence + 1)
 		}
-		previouslyDelivered = delivered
 	}
-	sub.Unsubscribe()
 
-	jsonResponse, err := json.Marshal(logsList)
+	fmt.Println("streaming topic: ", subscriptionTopic)
+
+	logs := make(chan DeploymentLog, 64)
+	done := r.Context().Done()
+	sub, err := messageBus.Subscribe(subscriptionTopic, func(msg *bus.Msg) {
+		deploymentLog, err := deploymentLogFromMsg(msg)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		select {
+		case logs <- deploymentLog:
+		case <-done:
+		}
+	}, startOption)
 	if err != nil {
-		fmt.Println("Error converting logs to json: ", err.Error())
+		http.Error(w, "Error subscribing to nats.", http.StatusInternalServerError)
 		return
 	}
+	defer sub.Unsubscribe()
 
-	w.Write(jsonResponse)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(LogStreamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-keepAlive.C:
+			if ShuttingDown {
+				return
+			}
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case deploymentLog := <-logs:
+			b, err := json.Marshal(deploymentLog)
+			if err != nil {
+				fmt.Println("Error converting log to json: ", err.Error())
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\ndata: %s\n\n", deploymentLog.Sequence, b)
+			flusher.Flush()
+
+			if !deploymentLog.Running {
+				fmt.Fprint(w, "event: done\ndata: {}\n\n")
+				flusher.Flush()
+				return
+			}
+		}
+	}
 }
 
 func Shutdown(c <-chan os.Signal) {
@@ -95,7 +224,8 @@ func Shutdown(c <-chan os.Signal) {
 	ShuttingDown = true
 	fmt.Println("Shutting Down.")
 	time.Sleep(2 * time.Second)
-	subStatus.Close()
-	sc.Close()
+	subStatus.Unsubscribe()
+	// the log archive subscription is durable, closing the bus keeps its position
+	messageBus.Close()
 	os.Exit(0)
 }
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/db"
+	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/jwt"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// auditPayloadLimit and auditResultLimit are how much of the request and the
+// response bodies are kept in the audit log.
+const auditPayloadLimit = 512
+const auditResultLimit = 256
+
+// auditRecorder keeps the status and the start of the body of a response.
+type auditRecorder struct {
+	http.ResponseWriter
+	status int
+	body   []byte
+}
+
+func (a *auditRecorder) WriteHeader(status int) {
+	if a.status == 0 {
+		a.status = status
+	}
+	a.ResponseWriter.WriteHeader(status)
+}
+
+func (a *auditRecorder) Write(b []byte) (int, error) {
+	if a.status == 0 {
+		a.status = http.StatusOK
+	}
+	if room := auditResultLimit - len(a.body); room > 0 {
+		if len(b) < room {
+			room = len(b)
+		}
+		a.body = append(a.body, b[:room]...)
+	}
+	return a.ResponseWriter.Write(b)
+}
+
+// Audit records who called the handler, on what and how it went in the audit log.
+// It wraps the authentication of the route so rejected requests are recorded too.
+func Audit(action string, handler httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		entry := db.AuditEntry{
+			User:     jwt.TokenAudienceFromRequest(r),
+			Action:   action,
+			Method:   r.Method,
+			Path:     r.URL.Path,
+			TargetID: ps.ByName("id"),
+			Payload:  auditPayload(r),
+		}
+
+		recorder := &auditRecorder{ResponseWriter: w}
+		handler(recorder, r, ps)
+
+		entry.Status = recorder.status
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK
+		}
+		entry.Result = strings.TrimSpace(string(recorder.body))
+
+		err := db.RecordAudit(entry)
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+// auditPayload summarizes the body of the request and leaves it to be read by the
+// handler. Uploads are only summarized by their type and size.
+func auditPayload(r *http.Request) string {
+	if r.Body == nil {
+		return ""
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if strings.HasPrefix(mediaType, "multipart/") {
+		return fmt.Sprintf("%v, %v bytes", mediaType, r.ContentLength)
+	}
+
+	start, err := ioutil.ReadAll(io.LimitReader(r.Body, auditPayloadLimit+1))
+	r.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(start), r.Body))
+	if err != nil {
+		return fmt.Sprintf("unable to read payload: %v", err)
+	}
+
+	payload := strings.TrimSpace(string(start))
+	if len(start) > auditPayloadLimit {
+		payload = strings.TrimSpace(string(start[:auditPayloadLimit])) + "..."
+	}
+	return payload
+}
+
+// AuditLog returns a page of the audit log as json, newest first. It can be filtered
+// with the user, action, target, startdate and enddate query parameters, items is
+// the size of the page and offset the number of entries to skip.
+func AuditLog(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	query := r.URL.Query()
+
+	items, err := strconv.Atoi(query.Get("items"))
+	if err != nil || items <= 0 {
+		items = PaginationItems
+	}
+	offset, err := strconv.Atoi(query.Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	filter := db.AuditFilter{
+		User:      query.Get("user"),
+		Action:    query.Get("action"),
+		TargetID:  query.Get("target"),
+		StartDate: extractDateFromURLQuery(query.Get("startdate"), defaultStart),
+		EndDate:   extractDateFromURLQuery(query.Get("enddate"), defaultEnd),
+	}
+
+	entries, err := db.AuditLog(filter, items, offset)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(entries)
+}
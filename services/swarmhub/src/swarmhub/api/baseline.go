@@ -0,0 +1,125 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/compare"
+	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/db"
+	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/jwt"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/common/locust"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// regressionLabel is the label of the tests that got worse than their baseline.
+const regressionLabel = "regression"
+
+// TestBaseline returns the baseline of the tests sharing the script of the test.
+func TestBaseline(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	baseline, ok, err := db.BaselineOfTest(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "The tests sharing the script of this test have no baseline.", http.StatusNotFound)
+		return
+	}
+
+	b, err := json.Marshal(baseline)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// SetTestBaseline makes the test the baseline of the tests sharing its script.
+// The others are compared to it when they stop, a metric more than tolerance
+// percent worse is a regression.
+func SetTestBaseline(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	tolerance, err := toleranceParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, ok, err := db.GetTestStats(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "No statistics were collected for this test, it can't be a baseline.", http.StatusBadRequest)
+		return
+	}
+
+	err = db.SetBaseline(id, tolerance, jwt.TokenAudienceFromRequest(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write([]byte("Success!"))
+}
+
+// DeleteTestBaseline stops comparing the tests sharing the script of the test to
+// it.
+func DeleteTestBaseline(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ok, err := db.RemoveBaseline(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "The test is not a baseline.", http.StatusNotFound)
+		return
+	}
+
+	w.Write([]byte("Success!"))
+}
+
+// compareToBaseline compares the statistics of a run to the baseline of its
+// tests, nil when there is none or the test is the baseline. The test is labeled
+// as a regression when a metric got worse.
+func compareToBaseline(testID string, stats locust.Stats) *compare.BaselineComparison {
+	baseline, ok, err := db.BaselineOfTest(testID)
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	if !ok || baseline.TestID == testID {
+		return nil
+	}
+
+	runs, _, err := comparedRuns([]string{baseline.TestID})
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	run := compare.Run{TestID: testID, Stats: &stats}
+
+	comparison := compare.AgainstBaseline(runs[0], run, baseline.Tolerance)
+	if comparison.Error != "" {
+		return &comparison
+	}
+
+	err = db.DeleteLabelFromTest(testID, regressionLabel)
+	if err != nil {
+		fmt.Println(err)
+	}
+	if comparison.Regressions > 0 {
+		fmt.Printf("Test %v has %v regressions against baseline %v\n", testID, comparison.Regressions, baseline.TestID)
+		err = db.AddLabelToTest(testID, regressionLabel)
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+	return &comparison
+}
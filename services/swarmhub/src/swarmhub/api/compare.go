@@ -0,0 +1,102 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/compare"
+	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/db"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// MaxComparedTests is how many tests CompareTests lines up at once.
+var MaxComparedTests = 10
+
+// CompareTests lines up the statistics of the tests in ids, a comma separated
+// list, by endpoint. The first test is the reference, metrics of the others that
+// are more than tolerance percent worse are flagged as regressions.
+// It is served on /api/tests/compare, which TestsPaginate hands over.
+func CompareTests(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var ids []string
+	for _, param := range r.URL.Query()["ids"] {
+		for _, id := range strings.Split(param, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				ids = append(ids, id)
+			}
+		}
+	}
+	if len(ids) < 2 || len(ids) > MaxComparedTests {
+		http.Error(w, fmt.Sprintf("ids needs between 2 and %v test ids, got %v", MaxComparedTests, len(ids)), http.StatusBadRequest)
+		return
+	}
+
+	tolerance, err := toleranceParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	runs, status, err := comparedRuns(ids)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	b, err := json.Marshal(compare.Compare(runs, tolerance))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// toleranceParam reads the tolerance parameter, compare.DefaultTolerance without it.
+func toleranceParam(r *http.Request) (float64, error) {
+	raw := r.FormValue("tolerance")
+	if raw == "" {
+		return compare.DefaultTolerance, nil
+	}
+	tolerance, err := strconv.ParseFloat(raw, 64)
+	if err != nil || tolerance < 0 {
+		return 0, fmt.Errorf("tolerance must be a percentage, got %q", raw)
+	}
+	return tolerance, nil
+}
+
+// comparedRuns loads the tests with their statistics. The status is the one to
+// answer with when it fails.
+func comparedRuns(ids []string) ([]compare.Run, int, error) {
+	var runs []compare.Run
+	for _, id := range ids {
+		b, err := db.TestByID(id)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		var test db.Test
+		err = json.Unmarshal(b, &test)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		if test.ID == "" {
+			return nil, http.StatusNotFound, fmt.Errorf("test %v not found", id)
+		}
+
+		run := compare.Run{TestID: id, Name: test.Name, Status: test.Status, Launched: test.Launched, Stopped: test.Stopped, SnapshotURL: test.SnapshotURL, URL: "/tests/" + id}
+		stats, ok, err := db.GetTestStats(id)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		if ok {
+			run.Stats = &stats.Stats
+			run.HasStats = true
+		}
+		runs = append(runs, run)
+	}
+	return runs, http.StatusOK, nil
+}
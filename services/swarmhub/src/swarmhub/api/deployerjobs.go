@@ -0,0 +1,141 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// DeployerJobsAddress is the host:port of the deployers' jobs endpoint. The host
+// should resolve to every deployer, such as a headless kubernetes service.
+var DeployerJobsAddress string
+
+// DeployerJobsTimeout is how long a deployer is given to list its jobs.
+var DeployerJobsTimeout = 5 * time.Second
+
+type deployerJob struct {
+	Host           string
+	ID             string
+	DeploymentType string
+	Operation      string
+	Command        string
+	Params         []string
+	PID            int
+	StartTime      time.Time
+	EndTime        *time.Time `json:",omitempty"`
+	Duration       string
+	ExitCode       *int `json:",omitempty"`
+	Running        bool
+}
+
+type deployerJobsResponse struct {
+	Host string
+	Jobs []deployerJob
+}
+
+type deployerJobsList struct {
+	Jobs []deployerJob
+	// Unreachable are the deployer addresses that didn't answer.
+	Unreachable []string
+}
+
+// DeployerJobs lists the jobs of every deployer, running jobs first.
+func DeployerJobs(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if DeployerJobsAddress == "" {
+		http.Error(w, "DEPLOYER_JOBS_ADDRESS is not set.", http.StatusNotFound)
+		return
+	}
+
+	list, err := collectDeployerJobs(DeployerJobsAddress)
+	if err != nil {
+		fmt.Println("failed to collect deployer jobs:", err)
+		http.Error(w, "Unable to reach the deployers.", http.StatusBadGateway)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(list)
+	if err != nil {
+		http.Error(w, "Failed to convert jobs to json.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonResponse)
+}
+
+// collectDeployerJobs asks every address the host resolves to for its jobs. An
+// address can resolve more than once to the same deployer, its jobs are only added
+// the first time.
+func collectDeployerJobs(address string) (deployerJobsList, error) {
+	var list deployerJobsList
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		err = fmt.Errorf("invalid deployer jobs address %v: %v", address, err)
+		return list, err
+	}
+
+	ips, err := net.LookupHost(host)
+	if err != nil {
+		err = fmt.Errorf("unable to resolve %v: %v", host, err)
+		return list, err
+	}
+
+	client := &http.Client{Timeout: DeployerJobsTimeout}
+	seen := make(map[string]bool)
+	for _, ip := range ips {
+		deployer := net.JoinHostPort(ip, port)
+		jobs, err := getDeployerJobs(client, deployer)
+		if err != nil {
+			fmt.Printf("failed to get jobs from deployer %v: %v\n", deployer, err)
+			list.Unreachable = append(list.Unreachable, deployer)
+			continue
+		}
+
+		if seen[jobs.Host] {
+			continue
+		}
+		seen[jobs.Host] = true
+
+		for _, job := range jobs.Jobs {
+			job.Host = jobs.Host
+			list.Jobs = append(list.Jobs, job)
+		}
+	}
+
+	sort.SliceStable(list.Jobs, func(i, j int) bool {
+		if list.Jobs[i].Running != list.Jobs[j].Running {
+			return list.Jobs[i].Running
+		}
+		return list.Jobs[i].StartTime.After(list.Jobs[j].StartTime)
+	})
+
+	return list, nil
+}
+
+func getDeployerJobs(client *http.Client, deployer string) (deployerJobsResponse, error) {
+	var jobs deployerJobsResponse
+
+	resp, err := client.Get("http://" + deployer + "/jobs")
+	if err != nil {
+		return jobs, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("unexpected status %v", resp.Status)
+		return jobs, err
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&jobs)
+	if err != nil {
+		err = fmt.Errorf("unable to decode jobs: %v", err)
+		return jobs, err
+	}
+	return jobs, nil
+}
@@ -14,79 +14,61 @@ import (
 )
 
 var (
-	LocustMasterSecurityGroups string
-	LocustSlaveSecurityGroups  string
+	LocustMasterSecurityGroups []string
+	LocustSlaveSecurityGroups  []string
 )
 
-func validateCanRunGrid(id string) (bool, error) {
-	var grid db.GridStruct
-	gridBytes, err := db.GetGridByID(id)
-	if err != nil {
-		fmt.Println("Unable to get test by ID: ", err.Error())
-		return false, err
-	}
-
-	err = json.Unmarshal(gridBytes, &grid)
-	if err != nil {
-		fmt.Println("Error unmarshalling grid: ", err.Error())
-		return false, err
-	}
-
-	if grid.Status == "Available" {
-		return true, nil
-	}
-
-	return false, nil
-}
-
 func StartGrid(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	var grid db.GridStruct
 	id := ps.ByName("id")
 
-	gridBytes, err := db.GetGridByID(id)
+	grid, err := gridByID(id)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	err = json.Unmarshal(gridBytes, &grid)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if grid.Status == "Deploying" || !db.GridStates.CanTransition(grid.Status, "Deploying") {
+		http.Error(w, fmt.Sprintf("Grid has a status %v, needs to be 'Ready'", grid.Status), http.StatusUnauthorized)
 		return
 	}
 
-	if grid.Status != "Ready" {
-		w.WriteHeader(http.StatusUnauthorized)
-		http.Error(w, fmt.Sprintf("Grid has a status %v, needs to be 'Ready'", grid.Status), http.StatusUnauthorized)
+	provider, err := providerForGrid(grid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	err = db.UpdateGridStatus(id, "Deploying")
+	err = provisionGrid(grid, provider, userChange(r, "grid started"))
 	if err != nil {
-		// Print error but continue on
-		fmt.Printf("Failed to update grid status for %v, %v\n", id, err)
+		fmt.Println("Was unable to provision grid! ", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	return
+}
 
+// provisionGrid moves the grid to Deploying, starts its TTL and has the provider
+// build it.
+func provisionGrid(grid db.GridStruct, provider GridProvider, change db.StatusChange) error {
 	ttl, err := strconv.Atoi(grid.TTL)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		err = fmt.Errorf("invalid TTL %v: %v", grid.TTL, err)
+		return err
 	}
 
-	ttlEpoch := strconv.FormatInt(time.Now().Add(time.Minute*time.Duration(ttl)).Unix(), 10)
-	message := &natsMessage{ID: grid.ID, Cmd: "/ansible/gridProvision.sh", Params: []string{grid.ID, grid.Region, grid.Master, grid.Slave, grid.Nodes, ttlEpoch, LocustMasterSecurityGroups, LocustSlaveSecurityGroups}, DeploymentType: "Grid"}
-	b, err := json.Marshal(message)
+	err = db.UpdateGridStatus(grid.ID, "Deploying", change)
 	if err != nil {
-		fmt.Println("Not publishing nats message. Failed to convert to json: ", err.Error())
-		return
+		// Print error but continue on
+		fmt.Printf("Failed to update grid status for %v, %v\n", grid.ID, err)
 	}
 
-	err = sendStartCmd(b)
+	err = db.UpdateGridExpires(grid.ID, time.Now().Add(time.Minute*time.Duration(ttl)))
 	if err != nil {
-		fmt.Println("Was unable to update test status! ", err.Error())
-		return
+		// Print error but continue on
+		fmt.Printf("Failed to update grid expiry for %v, %v\n", grid.ID, err)
 	}
-	return
+
+	return provider.Provision(grid)
 }
 func stopGrid(id string) error {
 	message := &natsMessage{ID: id, DeploymentType: "Grid"}
@@ -104,6 +86,18 @@ func stopGrid(id string) error {
 	return nil
 }
 
+// GridHistory returns the status changes of the grid, oldest first.
+func GridHistory(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	history, err := db.GridStatusHistory(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(history)
+}
+
 // incomplete
 func StopGrid(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	err := stopGrid(ps.ByName("id"))
@@ -165,12 +159,10 @@ func DeleteGrid(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	// TODO:
-	// add if statement here when we want to add logic for different
-	// grid status before deleting. For simplicity just delete now.
-
-	// add if statement to check if linked to a test and change status of both
-	// the test and the grid
+	if status == "Deleted" || !db.GridStates.CanTransition(status, "Deleted") {
+		http.Error(w, fmt.Sprintf("Grid has a status %v and can't be deleted.", status), http.StatusConflict)
+		return
+	}
 
 	if status == "Deploying" {
 		err := stopGrid(ps.ByName("id"))
@@ -181,50 +173,36 @@ func DeleteGrid(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	}
 
 	if status == "Deployed" || status == "Deploying" || status == "Available" {
-		err := deleteDeployedGrid(ps.ByName("id"))
-		// TODO: "This is a test to see if it prints."
+		err := deprovisionGrid(ps.ByName("id"))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 	} else {
-		err = db.DeleteGridByID(ps.ByName("id"))
+		err = db.DeleteGridByID(ps.ByName("id"), userChange(r, "grid deleted"))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		releaseQueuedTests(ps.ByName("id"), userChange(r, "grid "+ps.ByName("id")+" deleted"))
 	}
 
 	// w.Header().Set("Content-Type", "application/json")
 }
 
-func deleteDeployedGrid(id string) error {
-	region, err := db.GetGridRegion(id)
+func deprovisionGrid(id string) error {
+	grid, err := gridByID(id)
 	if err != nil {
-		fmt.Println("Unable to extract the id from Deletegrid function", err.Error())
+		fmt.Println("Unable to get the grid in deprovisionGrid", err.Error())
 		return err
 	}
 
-	type message struct {
-		ID             string
-		DeploymentType string
-		Region         string
-		Status         string
-	}
-
-	status := message{ID: id, DeploymentType: "Grid", Region: region, Status: "Deleting"}
-	statusMsg, err := json.Marshal(status)
-	if err != nil {
-		fmt.Println("Failed to convert json: ", err.Error())
-	}
-	err = sc.Publish("deployer.status", statusMsg)
+	provider, err := providerForGrid(grid)
 	if err != nil {
-		fmt.Println("Was unable to update test status! ", err.Error())
 		return err
 	}
 
-	fmt.Println("Command sent to nats for deleting grid ID:", id)
-	return nil
+	return provider.Deprovision(grid)
 }
 
 func PaginateGridInfo(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
@@ -385,5 +363,9 @@ func CreateGrid(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		return
 	}
 
-	db.CreateGrid(grid.Name, grid.Provider, grid.Region, grid.MasterType, grid.SlaveType, grid.SlaveNodes, grid.TTL, user)
+	_, err = db.CreateGrid(grid.Name, grid.Provider, grid.Region, grid.MasterType, grid.SlaveType, grid.SlaveNodes, grid.TTL, user)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 }
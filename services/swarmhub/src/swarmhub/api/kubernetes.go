@@ -0,0 +1,304 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/db"
+	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/k8s"
+	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/storage"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/common/events"
+	"github.com/att-cloudnative-labs/swarmhub/services/common/locust"
+	"github.com/att-cloudnative-labs/swarmhub/services/common/operations"
+)
+
+// KubernetesReadyTimeout is how long a grid or test is given to have all of its
+// pods available before it is marked as Error.
+var KubernetesReadyTimeout = 10 * time.Minute
+
+// KubernetesPollInterval is how often pods are checked while waiting on them.
+var KubernetesPollInterval = 5 * time.Second
+
+// EnableKubernetesProvider registers the kubernetes provider using the given client.
+func EnableKubernetesProvider(client *k8s.Client) {
+	gridProviders["kubernetes"] = kubernetesProvider{client: client}
+}
+
+// deploymentOutput publishes a line to the deployment logs of id.
+func deploymentOutput(id string, deploymentType string, line string) {
+	publishEvents(&events.JobOutput{JobID: id, DeploymentType: deploymentType, Stream: "stdout", Line: line})
+}
+
+// deploymentFinished ends the deployment logs of id, err is why it failed.
+func deploymentFinished(id string, deploymentType string, err error) {
+	finished := &events.JobFinished{JobID: id, DeploymentType: deploymentType, Status: events.JobSucceeded}
+	if err != nil {
+		finished.Status = events.JobError
+		finished.Reason = err.Error()
+		finished.ExitCode = 1
+	}
+	publishEvents(finished)
+}
+
+// publishEvents publishes the events the same way the deployer does once one of
+// its scripts changes state.
+func publishEvents(evts ...events.Event) {
+	for i, event := range evts {
+		err := events.Publish(messageBus, actor, event)
+		if err != nil {
+			err = fmt.Errorf("event %v of %v failed: %v", i+1, len(evts), err)
+			fmt.Println(err)
+		}
+	}
+}
+
+// kubernetesProvider runs the locust master and workers as pods, the grid's region
+// is the namespace they are created in.
+type kubernetesProvider struct {
+	client *k8s.Client
+}
+
+func (p kubernetesProvider) Provision(grid db.GridStruct) error {
+	workers, err := strconv.Atoi(grid.Nodes)
+	if err != nil {
+		err = fmt.Errorf("invalid number of nodes %v: %v", grid.Nodes, err)
+		return err
+	}
+
+	publishEvents(&events.GridStatusChanged{GridID: grid.ID, Status: "Deploying"})
+	deploymentOutput(grid.ID, "Grid", "Creating locust master and "+grid.Nodes+" workers in namespace "+grid.Region)
+
+	k8sGrid := k8s.Grid{ID: grid.ID, Namespace: grid.Region, MasterSize: grid.Master, WorkerSize: grid.Slave, Workers: int32(workers)}
+	err = p.client.CreateGrid(k8sGrid)
+	if err != nil {
+		deploymentFinished(grid.ID, "Grid", err)
+		publishEvents(&events.GridStatusChanged{GridID: grid.ID, Status: "Error", Reason: err.Error()})
+		return err
+	}
+
+	go func() {
+		err := p.waitForGrid(grid)
+		if err != nil {
+			deploymentFinished(grid.ID, "Grid", err)
+			publishEvents(&events.GridStatusChanged{GridID: grid.ID, Status: "Error", Reason: err.Error()})
+			return
+		}
+		deploymentOutput(grid.ID, "Grid", "Grid is available.")
+		deploymentFinished(grid.ID, "Grid", nil)
+		publishEvents(&events.GridStatusChanged{GridID: grid.ID, Status: "Available"})
+	}()
+
+	return nil
+}
+
+func (p kubernetesProvider) waitForGrid(grid db.GridStruct) error {
+	deadline := time.Now().Add(KubernetesReadyTimeout)
+	for time.Now().Before(deadline) {
+		ready, err := p.client.GridReady(grid.Region, grid.ID)
+		if err != nil {
+			return err
+		}
+		if ready {
+			return nil
+		}
+		time.Sleep(KubernetesPollInterval)
+	}
+	return fmt.Errorf("grid %v was not ready after %v", grid.ID, KubernetesReadyTimeout)
+}
+
+func (p kubernetesProvider) Deprovision(grid db.GridStruct) error {
+	stopKubernetesProfile(grid.ID)
+	err := publishGridDeleting(grid)
+	if err != nil {
+		return err
+	}
+
+	err = p.client.DeleteGrid(grid.Region, grid.ID)
+	if err != nil {
+		return err
+	}
+	publishEvents(&events.GridStatusChanged{GridID: grid.ID, Status: "Deleted"})
+	fmt.Println("Deleted kubernetes resources for grid ID:", grid.ID)
+	return nil
+}
+
+func (p kubernetesProvider) DescribeNodes(grid db.GridStruct) ([]GridNode, error) {
+	var nodes []GridNode
+
+	pods, err := p.client.Nodes(grid.Region, grid.ID)
+	if err != nil {
+		return nodes, err
+	}
+
+	for _, pod := range pods {
+		role := pod.Role
+		if role == "worker" {
+			role = "slave"
+		}
+		nodes = append(nodes, GridNode{ID: pod.Name, Role: role, State: pod.Phase, PrivateIP: pod.IP})
+	}
+
+	return nodes, nil
+}
+
+func (p kubernetesProvider) MasterAddress(grid db.GridStruct) (string, error) {
+	return p.client.MasterAddress(grid.Region, grid.ID)
+}
+
+func (p kubernetesProvider) LocustClient(grid db.GridStruct) (*locust.Client, error) {
+	return locust.NewClient(p.client.MasterWebURL(grid.Region, grid.ID), "", false), nil
+}
+
+// Expire removes the pods, nothing else is watching the TTL of kubernetes grids.
+func (p kubernetesProvider) Expire(grid db.GridStruct) error {
+	stopKubernetesProfile(grid.ID)
+	err := p.client.DeleteGrid(grid.Region, grid.ID)
+	if err != nil {
+		return err
+	}
+	publishEvents(&events.GridStatusChanged{GridID: grid.ID, Status: "Expired"})
+	return nil
+}
+
+// DeployTest rolls the pods with the test scripts. A test with a load profile is
+// started automatically, the profile is run from here as there is no deployer.
+// Without one locust is started by the user from the UI, there is nothing to start
+// it with automatically so that is refused.
+func (p kubernetesProvider) DeployTest(grid db.GridStruct, testID string, startAutomatically bool) error {
+	scriptID, scriptFilename, err := db.GetScriptFilename(testID)
+	if err != nil {
+		err = fmt.Errorf("unable to get script filename: %v", err)
+		return err
+	}
+
+	profile, err := db.TestLoadProfile(testID)
+	if err != nil {
+		return err
+	}
+
+	if startAutomatically && profile == nil {
+		return fmt.Errorf("test %v has no load profile, kubernetes grids only start tests with one automatically", testID)
+	}
+
+	scripts, err := storage.DownloadScript(scriptID, scriptFilename)
+	if err != nil {
+		err = fmt.Errorf("unable to download the test scripts: %v", err)
+		return err
+	}
+
+	publishEvents(&events.TestStatusChanged{TestID: testID, GridID: grid.ID, Status: "Deploying"})
+	deploymentOutput(testID, "Test", "Loading test scripts onto grid "+grid.ID)
+
+	err = p.client.SetScripts(grid.Region, grid.ID, scripts.Bytes())
+	if err != nil {
+		deploymentFinished(testID, "Test", err)
+		publishEvents(&events.TestStatusChanged{TestID: testID, GridID: grid.ID, Status: "Error", Reason: err.Error()})
+		return err
+	}
+
+	go func() {
+		err := p.waitForGrid(grid)
+		if err != nil {
+			deploymentFinished(testID, "Test", err)
+			publishEvents(&events.TestStatusChanged{TestID: testID, GridID: grid.ID, Status: "Error", Reason: err.Error()})
+			return
+		}
+		deploymentOutput(testID, "Test", "Test is deployed.")
+		deploymentFinished(testID, "Test", nil)
+		publishEvents(&events.TestStatusChanged{TestID: testID, GridID: grid.ID, Status: "Deployed"})
+
+		if profile != nil {
+			p.runLoadProfile(grid, testID, *profile)
+		}
+	}()
+
+	return nil
+}
+
+// kubernetesProfiles cancels the load profiles running for kubernetes grids, by grid ID.
+var kubernetesProfiles = struct {
+	sync.Mutex
+	cancels map[string]context.CancelFunc
+}{cancels: map[string]context.CancelFunc{}}
+
+// runLoadProfile drives the master of the grid through the load profile of the
+// test until it finished or the test is stopped.
+func (p kubernetesProvider) runLoadProfile(grid db.GridStruct, testID string, profile operations.LoadProfile) {
+	ctx, cancel := context.WithCancel(context.Background())
+	kubernetesProfiles.Lock()
+	if previous, ok := kubernetesProfiles.cancels[grid.ID]; ok {
+		previous()
+	}
+	kubernetesProfiles.cancels[grid.ID] = cancel
+	kubernetesProfiles.Unlock()
+	defer stopKubernetesProfile(grid.ID)
+
+	client := locust.NewClient(p.client.MasterWebURL(grid.Region, grid.ID), "", false)
+	started := false
+	err := locust.RunProfile(ctx, client, profile, func(line string) {
+		deploymentOutput(testID, "Test", line)
+		if !started {
+			started = true
+			publishEvents(&events.TestStatusChanged{TestID: testID, GridID: grid.ID, Status: "Running", Reason: line})
+		}
+	})
+	if err == context.Canceled {
+		return
+	}
+	if err != nil {
+		fmt.Printf("Load profile of test %v failed: %v\n", testID, err)
+		publishEvents(&events.TestStatusChanged{TestID: testID, GridID: grid.ID, Status: "Error", Reason: "load profile failed: " + err.Error()})
+		return
+	}
+	if _, ends := profile.TotalDuration(); ends {
+		publishEvents(&events.LoadProfileFinished{TestID: testID, GridID: grid.ID})
+	}
+}
+
+func stopKubernetesProfile(gridID string) {
+	kubernetesProfiles.Lock()
+	defer kubernetesProfiles.Unlock()
+	if cancel, ok := kubernetesProfiles.cancels[gridID]; ok {
+		cancel()
+		delete(kubernetesProfiles.cancels, gridID)
+	}
+}
+
+// StopTest puts the default locustfile back on the grid, which restarts locust.
+func (p kubernetesProvider) StopTest(grid db.GridStruct, testID string, deploymentType string) error {
+	stopKubernetesProfile(grid.ID)
+	initial := []events.Event{&events.GridStatusChanged{GridID: grid.ID, TestID: testID, Status: "Cleaning"}}
+	final := []events.Event{&events.GridStatusChanged{GridID: grid.ID, TestID: testID, Status: "Available"}}
+	if deploymentType == "StopTest" && testID != "" {
+		initial = append(initial, &events.TestStatusChanged{TestID: testID, GridID: grid.ID, Status: "Stopping"})
+		final = append(final, &events.TestStatusChanged{TestID: testID, GridID: grid.ID, Status: "Stopped"})
+	}
+
+	publishEvents(initial...)
+	deploymentOutput(grid.ID, deploymentType, "Cleaning test off of grid "+grid.ID)
+
+	err := p.client.SetScripts(grid.Region, grid.ID, nil)
+	if err != nil {
+		deploymentFinished(grid.ID, deploymentType, err)
+		publishEvents(&events.GridStatusChanged{GridID: grid.ID, Status: "Error", Reason: err.Error()})
+		return err
+	}
+
+	go func() {
+		err := p.waitForGrid(grid)
+		if err != nil {
+			deploymentFinished(grid.ID, deploymentType, err)
+			publishEvents(&events.GridStatusChanged{GridID: grid.ID, Status: "Error", Reason: err.Error()})
+			return
+		}
+		deploymentOutput(grid.ID, deploymentType, "Grid is clean.")
+		deploymentFinished(grid.ID, deploymentType, nil)
+		publishEvents(final...)
+	}()
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/db"
+	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/storage"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/common/locust"
+	"github.com/att-cloudnative-labs/swarmhub/services/common/operations"
+)
+
+// LocalScriptURLExpiry is how long the deployer has to download the test scripts
+// of a local grid.
+var LocalScriptURLExpiry = 30 * time.Minute
+
+// EnableLocalProvider registers the local provider. masterAddress is where the
+// locust master of a local grid can be reached, usually the deployer host.
+func EnableLocalProvider(masterAddress string) {
+	gridProviders["local"] = localProvider{masterAddress: masterAddress}
+}
+
+// localProvider runs the locust master and workers on the deployer host with the
+// scripts in the deployer's local folder. The master instance type decides if they
+// run as processes or docker containers.
+type localProvider struct {
+	masterAddress string
+}
+
+func (localProvider) Provision(grid db.GridStruct) error {
+	nodes, err := strconv.Atoi(grid.Nodes)
+	if err != nil {
+		err = fmt.Errorf("invalid number of nodes %v: %v", grid.Nodes, err)
+		return err
+	}
+
+	args := operations.ProvisionGridArgs{GridID: grid.ID, Region: grid.Region, MasterType: grid.Master, SlaveType: grid.Slave, Nodes: nodes}
+	return sendOperation(grid.ID, "Grid", "local", operations.ProvisionGrid, args)
+}
+
+func (localProvider) Deprovision(grid db.GridStruct) error {
+	err := publishGridDeleting(grid)
+	if err != nil {
+		return err
+	}
+
+	args := operations.DeleteGridArgs{GridID: grid.ID, Region: grid.Region}
+	return sendOperation(grid.ID, "GridDelete", "local", operations.DeleteGrid, args)
+}
+
+// DescribeNodes reports the nodes the grid was created with, the processes and
+// containers live on the deployer host and can't be seen from here.
+func (p localProvider) DescribeNodes(grid db.GridStruct) ([]GridNode, error) {
+	nodes := []GridNode{{ID: "locust-master-" + grid.ID, Role: "master", State: grid.Status, PrivateIP: p.masterAddress}}
+
+	workers, err := strconv.Atoi(grid.Nodes)
+	if err != nil {
+		err = fmt.Errorf("invalid number of nodes %v: %v", grid.Nodes, err)
+		return nodes, err
+	}
+
+	for i := 1; i <= workers; i++ {
+		nodes = append(nodes, GridNode{ID: "locust-worker-" + grid.ID + "-" + strconv.Itoa(i), Role: "slave", State: grid.Status})
+	}
+
+	return nodes, nil
+}
+
+func (p localProvider) MasterAddress(grid db.GridStruct) (string, error) {
+	return p.masterAddress, nil
+}
+
+// LocustClient uses the web UI of the master, masterAddress includes its port.
+func (p localProvider) LocustClient(grid db.GridStruct) (*locust.Client, error) {
+	return locust.NewClient("http://"+p.masterAddress, "", false), nil
+}
+
+// Expire marks the grid as deleting before the delete job is queued, so it isn't
+// expired again while the job waits for a deployer.
+func (localProvider) Expire(grid db.GridStruct) error {
+	err := db.UpdateGridStatus(grid.ID, "Deleting", db.StatusChange{Actor: actor, Reason: "TTL expired"})
+	if err != nil {
+		return err
+	}
+
+	args := operations.DeleteGridArgs{GridID: grid.ID, Region: grid.Region}
+	err = sendOperation(grid.ID, "GridExpire", "local", operations.DeleteGrid, args)
+	if err != nil {
+		errUpdate := db.UpdateGridStatus(grid.ID, "Error", db.StatusChange{Actor: actor, Reason: "unable to queue the deletion of the expired grid: " + err.Error()})
+		if errUpdate != nil {
+			fmt.Println(errUpdate)
+		}
+		return err
+	}
+	return nil
+}
+
+// DeployTest hands the deployer a presigned url for the scripts so it doesn't need
+// s3 credentials of its own.
+func (localProvider) DeployTest(grid db.GridStruct, testID string, startAutomatically bool) error {
+	scriptID, scriptFilename, err := db.GetScriptFilename(testID)
+	if err != nil {
+		err = fmt.Errorf("unable to get script filename: %v", err)
+		return err
+	}
+
+	scriptURL, err := storage.ScriptURL(scriptID, scriptFilename, LocalScriptURLExpiry)
+	if err != nil {
+		return err
+	}
+
+	// the deployer runs the profile against the master on its own host
+	profile, err := db.TestLoadProfile(testID)
+	if err != nil {
+		return err
+	}
+
+	args := operations.DeployTestArgs{GridID: grid.ID, Region: grid.Region, ScriptURL: scriptURL, ScriptFilename: scriptFilename, StartAutomatically: startAutomatically, Profile: profile}
+	return sendOperation(testID, "Test", "local", operations.DeployTest, args)
+}
+
+func (localProvider) StopTest(grid db.GridStruct, testID string, deploymentType string) error {
+	args := operations.CleanupGridArgs{GridID: grid.ID, Region: grid.Region, TestID: testID}
+	return sendOperation(grid.ID, deploymentType, "local", operations.CleanupGrid, args)
+}
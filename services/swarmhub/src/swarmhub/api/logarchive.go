@@ -0,0 +1,112 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/storage"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/common/bus"
+	"github.com/att-cloudnative-labs/swarmhub/services/common/events"
+)
+
+var subDone bus.Subscription
+
+// subscribeLogArchive archives the logs of every deployment the deployer reports as
+// done. It is durable so deployments that finish while swarmhub is down are archived
+// once it is back.
+func subscribeLogArchive() error {
+	var err error
+	subDone, err = messageBus.Subscribe("deployer.done", func(m *bus.Msg) {
+		event, err := events.Decode(m.Data)
+		if err != nil {
+			fmt.Println("failed to decode deployer.done message:", err)
+			m.Ack()
+			return
+		}
+
+		finished, ok := event.(*events.JobFinished)
+		if !ok {
+			fmt.Printf("unexpected event on deployer.done: %T\n", event)
+			m.Ack()
+			return
+		}
+
+		err = archiveDeploymentLogs(finished.JobID)
+		if err != nil {
+			fmt.Printf("failed to archive logs for %v: %v\n", finished.JobID, err)
+		}
+		m.Ack()
+	}, bus.QueueGroup("swarmhub"), bus.Durable("swarmhub-log-archive"), bus.ManualAck(), bus.DeliverAll())
+	return err
+}
+
+// archiveDeploymentLogs stores the output of a deployment. Messages that are already
+// archived but no longer in the channel are kept.
+func archiveDeploymentLogs(id string) error {
+	if id == "" {
+		return fmt.Errorf("no deployment id")
+	}
+
+	current, err := readDeploymentLogs(id)
+	if err != nil {
+		err = fmt.Errorf("unable to read deployment logs: %v", err)
+		return err
+	}
+
+	if len(current) == 0 {
+		return nil
+	}
+
+	logsList := current
+	if deploymentLogsMayBeIncomplete() {
+		archived, err := archivedDeploymentLogs(id)
+		if err != nil {
+			fmt.Println(err)
+		}
+		logsList = mergeDeploymentLogs(archived, current)
+	}
+
+	b, err := json.Marshal(logsList)
+	if err != nil {
+		err = fmt.Errorf("failed to convert logs to json: %v", err)
+		return err
+	}
+
+	return storage.UploadDeploymentLogs(id, b)
+}
+
+func archivedDeploymentLogs(id string) ([]DeploymentLog, error) {
+	var logsList []DeploymentLog
+
+	buff, err := storage.DownloadDeploymentLogs(id)
+	if err != nil {
+		err = fmt.Errorf("no archived logs for %v: %v", id, err)
+		return logsList, err
+	}
+
+	err = json.Unmarshal(buff.Bytes(), &logsList)
+	if err != nil {
+		err = fmt.Errorf("failed to unmarshal archived logs for %v: %v", id, err)
+		return logsList, err
+	}
+
+	return logsList, nil
+}
+
+// mergeDeploymentLogs puts the archived messages that are older than the first
+// current message in front of the current messages.
+func mergeDeploymentLogs(archived []DeploymentLog, current []DeploymentLog) []DeploymentLog {
+	if len(current) == 0 {
+		return archived
+	}
+
+	var logsList []DeploymentLog
+	for _, deploymentLog := range archived {
+		if deploymentLog.Sequence < current[0].Sequence {
+			logsList = append(logsList, deploymentLog)
+		}
+	}
+
+	return append(logsList, current...)
+}
@@ -9,37 +9,82 @@ import (
 
 	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/db"
 
-	nats "github.com/nats-io/nats.go"
-	"github.com/nats-io/stan.go"
+	"github.com/att-cloudnative-labs/swarmhub/services/common/bus"
+	"github.com/att-cloudnative-labs/swarmhub/services/common/events"
+	"github.com/att-cloudnative-labs/swarmhub/services/common/operations"
 	"github.com/spf13/viper"
 )
 
-var sc stan.Conn
+// messageBus is how swarmhub talks to the deployers, it is set by StartNats.
+var messageBus bus.Bus
 var natsUsername string
 var natsPassword string
 var natsURL string
-var subStatus stan.Subscription
-var stanClusterID string
+var subStatus bus.Subscription
 
+// natsMessage asks the deployers to run an operation, the ID and DeploymentType
+// are what the statuses and logs of the job are published for. Stop messages only
+// carry the ID.
 type natsMessage struct {
 	ID             string
-	Cmd            string
-	Params         []string
 	DeploymentType string
+	Operation      string       `json:",omitempty"`
+	Provider       string       `json:",omitempty"`
+	Args           interface{}  `json:",omitempty"`
+	Retry          *retryPolicy `json:",omitempty"`
 }
 
-type deploymentStatus struct {
-	ID             string
-	DeploymentType string
-	Status         string
-	Params         []string
+// retryPolicy tells the deployer how often to run a failed job again and how long
+// to wait in between.
+type retryPolicy struct {
+	MaxAttempts int
+	Backoff     string
+}
+
+// DeployerRetryAttempts is how often the deployer runs a job that fails, including
+// the first run. DeployerRetryBackoff is the wait before the first retry, it
+// doubles with every retry.
+var DeployerRetryAttempts = 3
+var DeployerRetryBackoff = "30s"
+
+// retriedOperations are the operations that can safely be run again after failing
+// part way through, provisioning and deleting a grid converge on the same state.
+// Deploying a test or cleaning a grid is not retried, a second run could start
+// or remove a test the first run already got to.
+var retriedOperations = map[string]bool{
+	operations.ProvisionGrid: true,
+	operations.DeleteGrid:    true,
+}
+
+// deployerRetry is the retry policy sent with the operation, nil when it is not
+// retried.
+func deployerRetry(operation string) *retryPolicy {
+	if DeployerRetryAttempts <= 1 || !retriedOperations[operation] {
+		return nil
+	}
+	return &retryPolicy{MaxAttempts: DeployerRetryAttempts, Backoff: DeployerRetryBackoff}
+}
+
+// sendOperation asks a deployer to run the operation of the provider with args,
+// one of the argument types of the operations package.
+func sendOperation(id string, deploymentType string, provider string, operation string, args interface{}) error {
+	message := &natsMessage{ID: id, DeploymentType: deploymentType, Operation: operation, Provider: provider, Args: args, Retry: deployerRetry(operation)}
+	b, err := json.Marshal(message)
+	if err != nil {
+		err = fmt.Errorf("not publishing nats message, failed to convert to json: %v", err)
+		return err
+	}
+
+	return sendStartCmd(b)
 }
 
+// actor is who the events published by swarmhub are attributed to.
+const actor = "swarmhub"
+
 func loadNatsSettings(conf *viper.Viper) {
 	natsUsername = conf.GetString("NATS_USERNAME")
 	natsPassword = conf.GetString("NATS_PASSWORD")
 	natsURL = conf.GetString("NATS_URL")
-	stanClusterID = conf.GetString("STAN_CLUSTER_ID")
 }
 
 func StartNats(config *viper.Viper) {
@@ -52,33 +97,38 @@ func StartNats(config *viper.Viper) {
 		natsServerURL = "nats://" + natsUsername + ":" + natsPassword + "@" + natsURL
 	}
 
-	if stanClusterID == "" {
-		fmt.Println("Default stan cluster ID.")
-		stanClusterID = "stan"
+	hostname, _ := os.Hostname()
+	natsBus, err := bus.Connect(natsServerURL, hostname)
+	if err != nil {
+		log.Fatal("Failed to connect to nats: ", err)
 	}
 
-	nc, err := nats.Connect(natsServerURL)
+	err = subscribeStatus(natsBus)
 	if err != nil {
-		log.Fatal("Failed to connect to nats: ", err)
+		fmt.Println("Failed to subscribe to topic deployer.status: ", err.Error())
+		os.Exit(2)
 	}
 
-	hostname, err := os.Hostname()
-	sc, err = stan.Connect(stanClusterID, hostname, stan.NatsConn(nc))
+	err = subscribeLogArchive()
 	if err != nil {
-		log.Fatal("Failed to connect to nats streaming: ", err)
+		fmt.Println("Failed to subscribe to topic deployer.done: ", err.Error())
+		os.Exit(2)
 	}
+}
+
+// subscribeStatus makes b the message bus and keeps the database up to date with
+// the statuses the deployers publish from now on.
+func subscribeStatus(b bus.Bus) error {
+	messageBus = b
 	startTime := time.Now()
 
 	// Used to receive messages on the state of deployments. Used to update the database
 	// on the current state of a deployment.
-	subStatus, err = sc.Subscribe("deployer.status", func(m *stan.Msg) {
+	var err error
+	subStatus, err = messageBus.Subscribe("deployer.status", func(m *bus.Msg) {
 		deployerStatusHandler(m)
-	}, stan.StartAtTime(startTime))
-
-	if err != nil {
-		fmt.Println("Failed to subscribe to topic deployer.status: ", err.Error())
-		os.Exit(2)
-	}
+	}, bus.StartTime(startTime))
+	return err
 }
 
 func SendStartCmd(message []byte) error {
@@ -88,7 +138,7 @@ func SendStartCmd(message []byte) error {
 
 func sendStartCmd(message []byte) error {
 	topic := "deployer.start"
-	err := publishNatsMessage(sc, topic, message)
+	err := publishNatsMessage(topic, message)
 	if err != nil {
 		return err
 	}
@@ -102,17 +152,17 @@ func SendStopCmd(message []byte) error {
 
 func sendStopCmd(message []byte) error {
 	topic := "deployer.stop"
-	err := publishNatsMessage(sc, topic, message)
+	err := publishNatsMessage(topic, message)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func publishNatsMessage(sc stan.Conn, topic string, message []byte) error {
+func publishNatsMessage(topic string, message []byte) error {
 
 	fmt.Println("publishing:", string(message), "To Topic:", topic)
-	err := sc.Publish(topic, message)
+	err := messageBus.Publish(topic, message)
 	if err != nil {
 		fmt.Println("Failed to publish message: ", err.Error())
 		return err
@@ -121,79 +171,93 @@ func publishNatsMessage(sc stan.Conn, topic string, message []byte) error {
 	return nil
 }
 
-func deployerStatusHandler(m *stan.Msg) {
-	// createGrafanaSnapshot is run before updateDeployerStatus so grid information
-	// is still attached to the test
-	createGrafanaSnapshot(m)
-	updateDeployerStatus(m)
-
-}
-
-func updateDeployerStatus(m *stan.Msg) {
+func deployerStatusHandler(m *bus.Msg) {
 	fmt.Printf("Msg received on [%s] : %s\n", m.Subject, string(m.Data))
 
-	var deployedStatus deploymentStatus
-
-	err := json.Unmarshal(m.Data, &deployedStatus)
+	event, err := events.Decode(m.Data)
 	if err != nil {
-		fmt.Println("failed to unmarshal struct")
+		fmt.Println("failed to decode status event:", err)
 		return
 	}
 
-	if deployedStatus.DeploymentType == "Test" {
-		db.UpdateTestStatus(deployedStatus.ID, deployedStatus.Status)
-	} else if deployedStatus.DeploymentType == "Grid" {
-		db.UpdateGridStatus(deployedStatus.ID, deployedStatus.Status)
-		updateTestStatusFromGridStatus(deployedStatus.ID, deployedStatus.Status)
-	} else if deployedStatus.DeploymentType == "StopTest" {
-		// StopTest the ID is the gridID and the Param first value is the testID.
-		if len(deployedStatus.Params) == 0 {
-			fmt.Println("Deployment type", deployedStatus.DeploymentType, "is expecting a parameter for testID.")
+	// createGrafanaSnapshot is run before updateDeployerStatus so grid information
+	// is still attached to the test
+	createGrafanaSnapshot(event)
+	updateDeployerStatus(event)
+	updateScheduledRun(event)
+
+}
+
+func updateDeployerStatus(event events.Event) {
+	switch e := event.(type) {
+	case *events.TestStatusChanged:
+		status := e.Status
+		switch status {
+		case "Cancelled":
+			// a cancelled deployment leaves the test as it was before, so it can be
+			// deployed again. A cancelled stop still ends the run, the grid it
+			// leaves half cleaned is an error.
+			status = "Ready"
+			current, err := db.GetTestStatus(e.TestID)
+			if err == nil && current == "Stopping" {
+				status = "Stopped"
+			}
+		case "Timeout":
+			status = "Error"
+		}
+		db.UpdateTestStatus(e.TestID, status, eventChange(e.Header, e.Status, e.Reason))
+	case *events.GridStatusChanged:
+		status := e.Status
+		if status == "Cancelled" || status == "Timeout" {
+			// the job on the grid didn't finish, there is no telling what state it is in
+			status = "Error"
+		}
+		change := eventChange(e.Header, e.Status, e.Reason)
+		err := db.UpdateGridStatus(e.GridID, status, change)
+		if err != nil {
 			return
 		}
-		gridID := deployedStatus.ID
-		testID := deployedStatus.Params[0]
-		db.UpdateGridStatus(gridID, deployedStatus.Status)
-		db.UpdateTestStatus(testID, deployedStatus.Status)
-	} else {
-		fmt.Println("Deployment status type of", deployedStatus.DeploymentType, "Was not expected.")
-	}
+		updateTestStatusFromGridStatus(e.GridID, status, change)
 
+		switch status {
+		case "Available":
+			startNextQueuedTest(e.GridID)
+		case "Deleted", "Expired":
+			releaseQueuedTests(e.GridID, db.StatusChange{Actor: change.Actor, Reason: fmt.Sprintf("grid %v is %v", e.GridID, status)})
+		}
+	case *events.LoadProfileFinished:
+		// the test keeps its status until it is stopped, see updateScheduledRun
+	default:
+		fmt.Printf("Status event %T was not expected.\n", event)
+	}
 }
 
-func createGrafanaSnapshot(m *stan.Msg) {
+func createGrafanaSnapshot(event events.Event) {
 	if !GrafanaEnabled {
 		return
 	}
-	var deployedStatus deploymentStatus
-
-	err := json.Unmarshal(m.Data, &deployedStatus)
-	if err != nil {
-		err = fmt.Errorf("failed to unmarshal struct for deployedStatus: %v", err)
-		fmt.Println(err)
-		return
-	}
 
 	var testID string
 	var status string
-
-	switch deployedStatus.DeploymentType {
-	case "Test":
-		testID = deployedStatus.ID
-		status = deployedStatus.Status
-	case "StopTest":
-		testID = deployedStatus.Params[0]
-		status = "Stopped"
-	case "Grid":
-		if !(deployedStatus.Status == "Deleted" || deployedStatus.Status == "expired") {
+	var err error
+
+	switch e := event.(type) {
+	case *events.TestStatusChanged:
+		testID = e.TestID
+		status = e.Status
+	case *events.GridStatusChanged:
+		if !(e.Status == "Deleted" || e.Status == "Expired") {
 			return
 		}
 		status = "Stopped"
-		testID, err = db.GetTestByGridID(deployedStatus.ID)
-		if err != nil {
-			err = fmt.Errorf("failed to GetTestByGridID using ID %v: %v", deployedStatus.ID, err)
-			fmt.Println(err)
-			return
+		testID = e.TestID
+		if testID == "" {
+			testID, err = db.GetTestByGridID(e.GridID)
+			if err != nil {
+				err = fmt.Errorf("failed to GetTestByGridID using ID %v: %v", e.GridID, err)
+				fmt.Println(err)
+				return
+			}
 		}
 
 		if testID == "" {
@@ -223,8 +287,19 @@ func createGrafanaSnapshot(m *stan.Msg) {
 	}()
 }
 
+// eventChange attributes a status change to the publisher of the event. When the
+// status was mapped to another one the status of the event is kept in the reason.
+func eventChange(h events.Header, status string, reason string) db.StatusChange {
+	if (status == "Cancelled" || status == "Timeout") && reason == "" {
+		reason = status
+	} else if status == "Cancelled" || status == "Timeout" {
+		reason = status + ": " + reason
+	}
+	return db.StatusChange{Actor: h.Actor, Reason: reason}
+}
+
 // TODO:
-func updateTestStatusFromGridStatus(gridID string, gridStatus string) {
+func updateTestStatusFromGridStatus(gridID string, gridStatus string, change db.StatusChange) {
 	var testStatus string
 	switch gridStatus {
 	case "Expired":
@@ -236,7 +311,8 @@ func updateTestStatusFromGridStatus(gridID string, gridStatus string) {
 	}
 
 	fmt.Printf("(grid: %v, status: %v) Update associated test to status %v\n", gridID, gridStatus, testStatus)
-	testID, err := db.UpdateTestStatusThatUsesGrid(gridID, testStatus)
+	change.Reason = fmt.Sprintf("grid %v is %v", gridID, gridStatus)
+	testID, err := db.UpdateTestStatusThatUsesGrid(gridID, testStatus, change)
 	if err != nil {
 		err = fmt.Errorf("failed: %v", err)
 		fmt.Println(err)
@@ -0,0 +1,235 @@
+package api
+
+import (
+	"database/sql"
+	"regexp"
+	"testing"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/db"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/common/bus"
+	"github.com/att-cloudnative-labs/swarmhub/services/common/events"
+	"github.com/att-cloudnative-labs/swarmhub/services/common/operations"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectTransition expects the status of the test or grid id to be read as from
+// and, when allowed, changed to to.
+func expectTransition(mock sqlmock.Sqlmock, kind string, id string, from string, to string, allowed bool) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT s.status FROM portal." + kind + " t")).
+		WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(from))
+	if !allowed {
+		mock.ExpectRollback()
+		return
+	}
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE portal."+kind+" SET")).
+		WithArgs(id, to).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO portal."+kind+"_status_history")).
+		WithArgs(id, from, to, "deployer/test", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+// expectTestStatus expects the status of the test id to be read as status.
+func expectTestStatus(mock sqlmock.Sqlmock, id string, status string) {
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT ts.status FROM portal.test t")).
+		WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(status))
+}
+
+// expectEmptyQueue expects the queue of the Available grid to be checked and
+// found empty.
+func expectEmptyQueue(mock sqlmock.Sqlmock, gridID string) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT s.status, g.test_id FROM portal.grid g")).
+		WithArgs(gridID).
+		WillReturnRows(sqlmock.NewRows([]string{"status", "test_id"}).AddRow("Available", nil))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM portal.grid_queue WHERE grid_id = $1 AND test_id NOT IN")).
+		WithArgs(gridID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("DELETE FROM portal.grid_queue WHERE id = (")).
+		WithArgs(gridID).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectCommit()
+}
+
+// expectNoScheduleRun expects id to be looked up as part of a schedule run that
+// it isn't part of.
+func expectNoScheduleRun(mock sqlmock.Sqlmock, id string) {
+	mock.ExpectQuery(regexp.QuoteMeta("FROM portal.schedule_run r WHERE r.finished IS NULL")).
+		WithArgs(id).
+		WillReturnError(sql.ErrNoRows)
+}
+
+// TestDeployerStatus publishes status events on a memory bus and checks the
+// statuses they lead to in the database.
+func TestDeployerStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		event  events.Event
+		expect func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:  "test deploying",
+			event: &events.TestStatusChanged{TestID: "t1", GridID: "g1", Status: "Deploying"},
+			expect: func(mock sqlmock.Sqlmock) {
+				expectTransition(mock, "test", "t1", "Queued", "Deploying", true)
+				expectNoScheduleRun(mock, "t1")
+			},
+		},
+		{
+			name:  "cancelled test is ready again",
+			event: &events.TestStatusChanged{TestID: "t1", GridID: "g1", Status: "Cancelled"},
+			expect: func(mock sqlmock.Sqlmock) {
+				expectTestStatus(mock, "t1", "Deploying")
+				expectTransition(mock, "test", "t1", "Deploying", "Ready", true)
+				expectNoScheduleRun(mock, "t1")
+			},
+		},
+		{
+			name:  "cancelled stop stops the test",
+			event: &events.TestStatusChanged{TestID: "t1", GridID: "g1", Status: "Cancelled"},
+			expect: func(mock sqlmock.Sqlmock) {
+				expectTestStatus(mock, "t1", "Stopping")
+				expectTransition(mock, "test", "t1", "Stopping", "Stopped", true)
+				expectNoScheduleRun(mock, "t1")
+			},
+		},
+		{
+			name:  "timed out test is an error",
+			event: &events.TestStatusChanged{TestID: "t1", GridID: "g1", Status: "Timeout"},
+			expect: func(mock sqlmock.Sqlmock) {
+				expectTransition(mock, "test", "t1", "Running", "Error", true)
+				expectNoScheduleRun(mock, "t1")
+			},
+		},
+		{
+			name:  "stopped test doesn't run again",
+			event: &events.TestStatusChanged{TestID: "t1", GridID: "g1", Status: "Running"},
+			expect: func(mock sqlmock.Sqlmock) {
+				expectTransition(mock, "test", "t1", "Stopped", "Running", false)
+				expectNoScheduleRun(mock, "t1")
+			},
+		},
+		{
+			name:  "grid deploying",
+			event: &events.GridStatusChanged{GridID: "g1", Status: "Deploying"},
+			expect: func(mock sqlmock.Sqlmock) {
+				expectTransition(mock, "grid", "g1", "Ready", "Deploying", true)
+				expectNoScheduleRun(mock, "g1")
+			},
+		},
+		{
+			name:  "available grid takes the next queued test",
+			event: &events.GridStatusChanged{GridID: "g1", Status: "Available"},
+			expect: func(mock sqlmock.Sqlmock) {
+				expectTransition(mock, "grid", "g1", "Cleaning", "Available", true)
+				expectEmptyQueue(mock, "g1")
+				expectNoScheduleRun(mock, "g1")
+			},
+		},
+		{
+			name:  "deleted grid isn't available again",
+			event: &events.GridStatusChanged{GridID: "g1", Status: "Available"},
+			expect: func(mock sqlmock.Sqlmock) {
+				expectTransition(mock, "grid", "g1", "Deleted", "Available", false)
+				expectNoScheduleRun(mock, "g1")
+			},
+		},
+		{
+			name:  "deleted grid stops its test and releases its queue",
+			event: &events.GridStatusChanged{GridID: "g1", Status: "Deleted"},
+			expect: func(mock sqlmock.Sqlmock) {
+				expectTransition(mock, "grid", "g1", "Deleting", "Deleted", true)
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT test_id FROM portal.grid WHERE id = $1")).
+					WithArgs("g1").
+					WillReturnRows(sqlmock.NewRows([]string{"test_id"}).AddRow("t1"))
+				expectTransition(mock, "test", "t1", "Running", "Stopped", true)
+				mock.ExpectQuery(regexp.QuoteMeta("DELETE FROM portal.grid_queue WHERE grid_id = $1 RETURNING test_id")).
+					WithArgs("g1").
+					WillReturnRows(sqlmock.NewRows([]string{"test_id"}).AddRow("t2"))
+				expectTransition(mock, "test", "t2", "Queued", "Ready", true)
+				expectNoScheduleRun(mock, "g1")
+			},
+		},
+		{
+			name:  "finished load profile outside a schedule run leaves the test running",
+			event: &events.LoadProfileFinished{TestID: "t1", GridID: "g1"},
+			expect: func(mock sqlmock.Sqlmock) {
+				expectNoScheduleRun(mock, "t1")
+			},
+		},
+		{
+			name:  "expired grid expires its test",
+			event: &events.GridStatusChanged{GridID: "g1", Status: "Expired"},
+			expect: func(mock sqlmock.Sqlmock) {
+				expectTransition(mock, "grid", "g1", "Available", "Expired", true)
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT test_id FROM portal.grid WHERE id = $1")).
+					WithArgs("g1").
+					WillReturnRows(sqlmock.NewRows([]string{"test_id"}).AddRow("t1"))
+				expectTransition(mock, "test", "t1", "Running", "Expired", true)
+				mock.ExpectQuery(regexp.QuoteMeta("DELETE FROM portal.grid_queue WHERE grid_id = $1 RETURNING test_id")).
+					WithArgs("g1").
+					WillReturnRows(sqlmock.NewRows([]string{"test_id"}))
+				expectNoScheduleRun(mock, "g1")
+			},
+		},
+		{
+			name:  "grid that timed out is an error",
+			event: &events.GridStatusChanged{GridID: "g1", Status: "Timeout"},
+			expect: func(mock sqlmock.Sqlmock) {
+				expectTransition(mock, "grid", "g1", "Deploying", "Error", true)
+				expectNoScheduleRun(mock, "g1")
+			},
+		},
+	}
+
+	for _, test := range tests {
+		conn, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.Use(conn)
+		test.expect(mock)
+
+		b := bus.NewMemory()
+		err = subscribeStatus(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = events.Publish(b, "deployer/test", test.event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Flush()
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%v: %v", test.name, err)
+		}
+		b.Close()
+		conn.Close()
+	}
+}
+
+func TestDeployerRetry(t *testing.T) {
+	tests := []struct {
+		operation string
+		retried   bool
+	}{
+		{operations.ProvisionGrid, true},
+		{operations.DeleteGrid, true},
+		{operations.DeployTest, false},
+		{operations.CleanupGrid, false},
+	}
+	for _, test := range tests {
+		policy := deployerRetry(test.operation)
+		if (policy != nil) != test.retried {
+			t.Errorf("%v has retry policy %v, want retried %v", test.operation, policy, test.retried)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/db"
+	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/ec2"
+	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/jwt"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/common/events"
+	"github.com/att-cloudnative-labs/swarmhub/services/common/locust"
+	"github.com/att-cloudnative-labs/swarmhub/services/common/operations"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// GridProvider manages the machines a grid runs on. There is one implementation
+// for every row in portal.providers, the grid's provider decides which one is used.
+type GridProvider interface {
+	// Provision starts building the grid, status updates are expected on deployer.status.
+	Provision(grid db.GridStruct) error
+	// Deprovision tears the grid down.
+	Deprovision(grid db.GridStruct) error
+	// DescribeNodes lists the nodes that currently make up the grid.
+	DescribeNodes(grid db.GridStruct) ([]GridNode, error)
+	// MasterAddress is the address the locust master can be reached on.
+	MasterAddress(grid db.GridStruct) (string, error)
+	// LocustClient returns a client for the web API of the locust master.
+	LocustClient(grid db.GridStruct) (*locust.Client, error)
+	// Expire is called once the grid has outlived its TTL.
+	Expire(grid db.GridStruct) error
+	// DeployTest loads the test scripts onto the grid and starts locust.
+	DeployTest(grid db.GridStruct, testID string, startAutomatically bool) error
+	// StopTest stops locust and cleans the test off of the grid. deploymentType
+	// is either StopTest or CancelTest.
+	StopTest(grid db.GridStruct, testID string, deploymentType string) error
+}
+
+// GridNode is a single machine of a grid.
+type GridNode struct {
+	ID        string
+	Role      string
+	State     string
+	PrivateIP string
+	PublicIP  string
+}
+
+// gridProviders maps the name in portal.providers to its implementation.
+var gridProviders = map[string]GridProvider{
+	"AWS": ec2Provider{},
+}
+
+// GridExpiryInterval is how often grids are checked for an expired TTL.
+var GridExpiryInterval = time.Minute
+
+func providerForGrid(grid db.GridStruct) (GridProvider, error) {
+	provider, ok := gridProviders[grid.Provider]
+	if !ok {
+		return nil, fmt.Errorf("grid %v uses provider %v which is not supported", grid.ID, grid.Provider)
+	}
+	return provider, nil
+}
+
+func gridByID(id string) (db.GridStruct, error) {
+	var grid db.GridStruct
+	gridBytes, err := db.GetGridByID(id)
+	if err != nil {
+		err = fmt.Errorf("unable to get grid %v: %v", id, err)
+		return grid, err
+	}
+
+	err = json.Unmarshal(gridBytes, &grid)
+	if err != nil {
+		err = fmt.Errorf("unable to unmarshal grid %v: %v", id, err)
+		return grid, err
+	}
+
+	return grid, nil
+}
+
+// MasterIP returns the address of the locust master for the grid the test is deployed on.
+func MasterIP(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	testID := ps.ByName("id")
+	type output struct {
+		Status      string
+		IP          string
+		Auth        string
+		Description string
+	}
+
+	cookie, err := r.Cookie("Authorization")
+	if err != nil {
+		_output := output{Status: "Failed", IP: "", Auth: "", Description: "Failed to get auth token from cookie."}
+		b, _ := json.Marshal(_output)
+		w.Write(b)
+		return
+	}
+
+	gridID, _, err := db.GetGridByTestID(testID)
+	if err != nil {
+		_output := output{Status: "Failed", IP: "", Auth: "", Description: err.Error()}
+		b, _ := json.Marshal(_output)
+		w.Write(b)
+		return
+	}
+
+	grid, err := gridByID(gridID)
+	if err != nil {
+		_output := output{Status: "Failed", IP: "", Auth: "", Description: err.Error()}
+		b, _ := json.Marshal(_output)
+		w.Write(b)
+		return
+	}
+
+	provider, err := providerForGrid(grid)
+	if err != nil {
+		_output := output{Status: "Failed", IP: "", Auth: "", Description: err.Error()}
+		b, _ := json.Marshal(_output)
+		w.Write(b)
+		return
+	}
+
+	address, err := provider.MasterAddress(grid)
+	if err != nil {
+		_output := output{Status: "Failed", IP: "", Auth: "", Description: err.Error()}
+		b, _ := json.Marshal(_output)
+		w.Write(b)
+		return
+	}
+
+	_output := output{Status: "Success", IP: address, Auth: cookie.Value, Description: "Call was a success."}
+	b, _ := json.Marshal(_output)
+	w.Write(b)
+}
+
+// GridNodes lists the nodes of a grid as reported by its provider.
+func GridNodes(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	grid, err := gridByID(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	provider, err := providerForGrid(grid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	nodes, err := provider.DescribeNodes(grid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(nodes) == 0 {
+		nodes = []GridNode{}
+	}
+
+	b, err := json.Marshal(nodes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// ExpireGrids periodically hands grids that are past their TTL to their provider.
+func ExpireGrids() {
+	for {
+		time.Sleep(GridExpiryInterval)
+		if ShuttingDown {
+			return
+		}
+
+		grids, err := db.GetExpiredGrids()
+		if err != nil {
+			fmt.Println("failed to get expired grids:", err)
+			continue
+		}
+
+		for _, grid := range grids {
+			provider, err := providerForGrid(grid)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			err = provider.Expire(grid)
+			if err != nil {
+				fmt.Printf("failed to expire grid %v: %v\n", grid.ID, err)
+			}
+		}
+	}
+}
+
+// ec2Provider builds grids out of EC2 instances with the ansible playbooks in the deployer.
+type ec2Provider struct{}
+
+func (ec2Provider) Provision(grid db.GridStruct) error {
+	ttl, err := strconv.Atoi(grid.TTL)
+	if err != nil {
+		return err
+	}
+
+	nodes, err := strconv.Atoi(grid.Nodes)
+	if err != nil {
+		err = fmt.Errorf("invalid number of nodes %v: %v", grid.Nodes, err)
+		return err
+	}
+
+	args := operations.ProvisionGridArgs{
+		GridID:               grid.ID,
+		Region:               grid.Region,
+		MasterType:           grid.Master,
+		SlaveType:            grid.Slave,
+		Nodes:                nodes,
+		Expires:              time.Now().Add(time.Minute * time.Duration(ttl)).Unix(),
+		MasterSecurityGroups: LocustMasterSecurityGroups,
+		SlaveSecurityGroups:  LocustSlaveSecurityGroups,
+	}
+	return sendOperation(grid.ID, "Grid", "AWS", operations.ProvisionGrid, args)
+}
+
+// Deprovision marks the grid as deleting, ttl-enforcer picks the message up and
+// terminates the instances.
+func (ec2Provider) Deprovision(grid db.GridStruct) error {
+	err := publishGridDeleting(grid)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Command sent to nats for deleting grid ID:", grid.ID)
+	return nil
+}
+
+// publishGridDeleting marks the grid as deleting. The provider is part of the
+// message so ttl-enforcer only acts on the grids it manages.
+func publishGridDeleting(grid db.GridStruct) error {
+	event := &events.GridStatusChanged{GridID: grid.ID, Status: "Deleting", Provider: grid.Provider, Region: grid.Region}
+	err := events.Publish(messageBus, actor, event)
+	if err != nil {
+		err = fmt.Errorf("unable to publish grid deletion: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (ec2Provider) DescribeNodes(grid db.GridStruct) ([]GridNode, error) {
+	var nodes []GridNode
+
+	instances, err := ec2.GridInstances(grid.ID, grid.Region)
+	if err != nil {
+		return nodes, err
+	}
+
+	for _, instance := range instances {
+		role := "slave"
+		if instance.Name == "locust-master" {
+			role = "master"
+		}
+		nodes = append(nodes, GridNode{ID: instance.ID, Role: role, State: instance.State, PrivateIP: instance.PrivateIP, PublicIP: instance.PublicIP})
+	}
+
+	return nodes, nil
+}
+
+func (ec2Provider) MasterAddress(grid db.GridStruct) (string, error) {
+	return ec2.MasterIP(grid.ID, grid.Region)
+}
+
+// Expire has nothing to do, the instances carry a TTL tag and are terminated by ttl-enforcer.
+// LocustClient reaches the master through the locust-go proxy, which takes the
+// same tokens as swarmhub and has a self signed certificate.
+func (ec2Provider) LocustClient(grid db.GridStruct) (*locust.Client, error) {
+	masterIP, err := ec2.MasterIP(grid.ID, grid.Region)
+	if err != nil {
+		err = fmt.Errorf("unable to get the master of grid %v: %v", grid.ID, err)
+		return nil, err
+	}
+
+	token, err := jwt.CreateToken(actor, jwt.RoleReadOnly)
+	if err != nil {
+		return nil, err
+	}
+	return locust.NewClient("https://"+masterIP, token, true), nil
+}
+
+func (ec2Provider) Expire(grid db.GridStruct) error {
+	return nil
+}
+
+func (ec2Provider) DeployTest(grid db.GridStruct, testID string, startAutomatically bool) error {
+	scriptID, scriptFilename, err := db.GetScriptFilename(testID)
+	if err != nil {
+		err = fmt.Errorf("unable to get script filename: %v", err)
+		return err
+	}
+
+	args := operations.DeployTestArgs{GridID: grid.ID, Region: grid.Region, ScriptID: scriptID, ScriptFilename: scriptFilename, StartAutomatically: startAutomatically}
+
+	args.Profile, err = db.TestLoadProfile(testID)
+	if err != nil {
+		return err
+	}
+	if args.Profile != nil {
+		// the deployer reaches the master through the locust-go proxy
+		masterIP, err := ec2.MasterIP(grid.ID, grid.Region)
+		if err != nil {
+			err = fmt.Errorf("unable to get the master of grid %v for the load profile: %v", grid.ID, err)
+			return err
+		}
+		args.MasterURL = "https://" + masterIP
+	}
+	return sendOperation(testID, "Test", "AWS", operations.DeployTest, args)
+}
+
+func (ec2Provider) StopTest(grid db.GridStruct, testID string, deploymentType string) error {
+	args := operations.CleanupGridArgs{GridID: grid.ID, Region: grid.Region, TestID: testID}
+	return sendOperation(grid.ID, deploymentType, "AWS", operations.CleanupGrid, args)
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/db"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// gridAcceptsQueue reports if tests can be started on a grid in the status. They
+// always go through the queue of the grid, the grid is either available or being
+// provisioned, busy with another test or being cleaned up.
+func gridAcceptsQueue(status string) bool {
+	return status == "Available" || status == "Deploying" || status == "Deployed" || status == "Cleaning"
+}
+
+// queueTest puts the test in the queue of the grid, it is deployed by
+// startNextQueuedTest once the grid is available. It returns the position of the
+// test in the queue, callers run startNextQueuedTest afterwards as the grid may
+// have become available in the meantime.
+func queueTest(grid db.GridStruct, testID string, startAutomatically bool, user string) (int, error) {
+	err := db.UpdateTestStatus(testID, "Queued", db.StatusChange{Actor: user, Reason: "waiting for grid " + grid.ID})
+	if err != nil {
+		return 0, err
+	}
+
+	position, err := db.QueueTest(grid.ID, testID, startAutomatically, user)
+	if err != nil {
+		db.UpdateTestStatus(testID, "Ready", db.StatusChange{Actor: user, Reason: "could not be queued"})
+		return 0, err
+	}
+	return position, nil
+}
+
+// startNextQueuedTest deploys the test that has been waiting the longest for the
+// grid when the grid is available and free. It is the only place tests are taken
+// off a queue and is run whenever that might have become possible: a test was
+// queued, the grid became Available or its test was taken off it. A test that
+// can't be deployed is made Ready again and the next one is tried. It returns the
+// test that was deployed, if any, and why the others failed.
+func startNextQueuedTest(gridID string) (string, map[string]error) {
+	failed := map[string]error{}
+	for {
+		next, ok, err := db.NextQueuedTest(gridID)
+		if err != nil {
+			fmt.Println("Failed to get the next queued test:", err)
+			return "", failed
+		}
+		if !ok {
+			return "", failed
+		}
+
+		grid, err := gridByID(gridID)
+		if err == nil {
+			fmt.Printf("Deploying queued test %v to grid %v\n", next.TestID, gridID)
+			err = deployTest(grid, next.TestID, next.StartAutomatically, db.StatusChange{Actor: next.QueuedBy, Reason: "next in the queue of grid " + gridID})
+			if err == nil {
+				return next.TestID, failed
+			}
+		}
+		fmt.Printf("Failed to deploy queued test %v: %v\n", next.TestID, err)
+		failed[next.TestID] = err
+		db.UpdateTestStatus(next.TestID, "Ready", db.StatusChange{Actor: actor, Reason: err.Error()})
+
+		// free the grid claimed for the test so the next one can have it
+		err = db.UpdateTestIDinGrid(gridID, "")
+		if err != nil {
+			fmt.Println(err)
+			return "", failed
+		}
+	}
+}
+
+// releaseQueuedTests empties the queue of a grid that is gone and makes its tests
+// Ready again so they can be started on another grid.
+func releaseQueuedTests(gridID string, change db.StatusChange) {
+	testIDs, err := db.ClearGridQueue(gridID)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	for _, testID := range testIDs {
+		fmt.Printf("(grid: %v) Releasing queued test %v\n", gridID, testID)
+		db.UpdateTestStatus(testID, "Ready", change)
+	}
+}
+
+// GridQueue returns the tests waiting for the grid, in the order they are deployed.
+func GridQueue(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	queue, err := db.GridQueue(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(queue)
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/db"
+	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/jwt"
+	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/report"
+	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/storage"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// TestReport renders the report of the test as a self-contained HTML page. With
+// download set it is served as a file.
+func TestReport(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	b, status, err := renderTestReport(id, jwt.TokenAudienceFromRequest(r))
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	if r.FormValue("download") != "" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", reportName(id)))
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(b)
+}
+
+// ArchiveTestReport renders the report of the test and keeps it as an attachment
+// of the test, so it outlives the grid and the Grafana snapshot.
+func ArchiveTestReport(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	type response struct {
+		Attachment string
+	}
+
+	id := ps.ByName("id")
+	b, status, err := renderTestReport(id, jwt.TokenAudienceFromRequest(r))
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	name := reportName(id)
+	err = storage.UploadAttachment(id, name, reportFile{bytes.NewReader(b)})
+	if err != nil {
+		http.Error(w, "Failed to upload the report, "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = db.PutTestAttachment(id, name)
+	if err != nil {
+		http.Error(w, "Failed to add the report to the database, "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	b, err = json.Marshal(response{name})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// reportFile is a rendered report in the form storage.UploadAttachment takes.
+type reportFile struct {
+	*bytes.Reader
+}
+
+func (reportFile) Close() error {
+	return nil
+}
+
+// reportName is the file name of a report, the time keeps archived reports of a
+// test apart.
+func reportName(testID string) string {
+	return fmt.Sprintf("report-%v-%v.html", testID, time.Now().UTC().Format("20060102T150405Z"))
+}
+
+// renderTestReport gathers what the report of the test shows and renders it. The
+// status is the one to answer with when it fails.
+func renderTestReport(id string, user string) ([]byte, int, error) {
+	rep, status, err := testReport(id)
+	if err != nil {
+		return nil, status, err
+	}
+	rep.Generated = time.Now().UTC().Format(time.RFC3339)
+	rep.GeneratedBy = user
+
+	var buf bytes.Buffer
+	err = report.Render(&buf, rep)
+	if err != nil {
+		err = fmt.Errorf("unable to render the report of test %v: %v", id, err)
+		return nil, http.StatusInternalServerError, err
+	}
+	return buf.Bytes(), http.StatusOK, nil
+}
+
+func testReport(id string) (report.Report, int, error) {
+	var rep report.Report
+
+	b, err := db.TestByID(id)
+	if err != nil {
+		return rep, http.StatusInternalServerError, err
+	}
+	err = json.Unmarshal(b, &rep.Test)
+	if err != nil {
+		return rep, http.StatusInternalServerError, err
+	}
+	if rep.Test.ID == "" {
+		return rep, http.StatusNotFound, fmt.Errorf("test %v not found", id)
+	}
+
+	gridID, _, err := db.GetGridByTestID(id)
+	if err != nil {
+		return rep, http.StatusInternalServerError, err
+	}
+	if gridID != "" {
+		b, err = db.GetGridByID(gridID)
+		if err != nil {
+			return rep, http.StatusInternalServerError, err
+		}
+		var grid db.GridStruct
+		err = json.Unmarshal(b, &grid)
+		if err != nil {
+			return rep, http.StatusInternalServerError, err
+		}
+		rep.Grid = &grid
+	}
+
+	rep.Profile, err = db.TestLoadProfile(id)
+	if err != nil {
+		return rep, http.StatusInternalServerError, err
+	}
+
+	b, err = db.TestStatusHistory(id)
+	if err != nil {
+		return rep, http.StatusInternalServerError, err
+	}
+	err = json.Unmarshal(b, &rep.History)
+	if err != nil {
+		return rep, http.StatusInternalServerError, err
+	}
+
+	stats, ok, err := db.GetTestStats(id)
+	if err != nil {
+		return rep, http.StatusInternalServerError, err
+	}
+	if ok {
+		rep.Stats = &stats
+	}
+
+	b, err = db.TestEvaluation(id)
+	if err != nil {
+		return rep, http.StatusInternalServerError, err
+	}
+	err = json.Unmarshal(b, &rep.Result)
+	if err != nil {
+		return rep, http.StatusInternalServerError, err
+	}
+	return rep, http.StatusOK, nil
+}
@@ -2,57 +2,92 @@ package api
 
 import (
 	"net/http"
-	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/ec2"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/db"
 	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/jwt"
 
 	"github.com/julienschmidt/httprouter"
 )
 
+// SetRouterPaths registers the API. Every route changing something is wrapped in Audit.
 func SetRouterPaths(router *httprouter.Router) {
 	router.GET("/api/test/:id/attachment/:attachmentid", TokenApiAuth(GetTestAttachment))
 	router.GET("/api/grafana/info", TokenApiAuth(GrafanaConfigs))
 	router.GET("/api/test/:id/attachments", TokenApiAuth(TestAttachments))
-	router.POST("/api/grid/:id/start", PowerTokenAPIAuth(StartGrid))
-	router.POST("/api/test/:id/edit", PowerTokenAPIAuth(EditTest))
-	router.POST("/api/test/:id/label/:label", PowerTokenAPIAuth(LabelToTest))
-	router.DELETE("/api/test/:id/label/:label", PowerTokenAPIAuth(LabelToTest))
-	router.POST("/api/grid/:id/stop", PowerTokenAPIAuth(StopGrid))
+	router.GET("/api/test/:id/history", TokenApiAuth(TestHistory))
+	router.POST("/api/grid/:id/start", Audit("StartGrid", PowerTokenAPIAuth(StartGrid)))
+	router.POST("/api/test/:id/edit", Audit("EditTest", PowerTokenAPIAuth(EditTest)))
+	router.GET("/api/test/:id/profile", TokenApiAuth(TestProfile))
+	router.POST("/api/test/:id/profile", Audit("SetTestProfile", PowerTokenAPIAuth(SetTestProfile)))
+	router.DELETE("/api/test/:id/profile", Audit("DeleteTestProfile", PowerTokenAPIAuth(DeleteTestProfile)))
+	router.GET("/api/test/:id/thresholds", TokenApiAuth(TestThresholds))
+	router.POST("/api/test/:id/thresholds", Audit("SetTestThresholds", PowerTokenAPIAuth(SetTestThresholds)))
+	router.DELETE("/api/test/:id/thresholds", Audit("DeleteTestThresholds", PowerTokenAPIAuth(DeleteTestThresholds)))
+	router.GET("/api/test/:id/evaluation", TokenApiAuth(TestEvaluation))
+	router.GET("/api/test/:id/stats", TokenApiAuth(TestStats))
+	router.GET("/api/test/:id/baseline", TokenApiAuth(TestBaseline))
+	router.POST("/api/test/:id/baseline", Audit("SetTestBaseline", PowerTokenAPIAuth(SetTestBaseline)))
+	router.DELETE("/api/test/:id/baseline", Audit("DeleteTestBaseline", PowerTokenAPIAuth(DeleteTestBaseline)))
+	router.GET("/api/test/:id/report", TokenApiAuth(TestReport))
+	router.POST("/api/test/:id/report", Audit("ArchiveTestReport", PowerTokenAPIAuth(ArchiveTestReport)))
+	router.POST("/api/test/:id/label/:label", Audit("LabelToTest", PowerTokenAPIAuth(LabelToTest)))
+	router.DELETE("/api/test/:id/label/:label", Audit("LabelToTest", PowerTokenAPIAuth(LabelToTest)))
+	router.POST("/api/grid/:id/stop", Audit("StopGrid", PowerTokenAPIAuth(StopGrid)))
 	router.GET("/api/test/:id/deploylogs", TokenApiAuth(deployerLogs))
+	router.GET("/api/test/:id/deploylogs/stream", TokenApiAuth(deployerLogsStream))
 	router.GET("/api/paginate/test/info", TokenApiAuth(PaginateTestInfo))
 	router.GET("/api/paginate/grid/info", TokenApiAuth(PaginateGridInfo))
 	router.GET("/api/paginate/test/key/:id", TokenApiAuth(GetTestPaginateKey))
 	router.GET("/api/paginate/grid/key/:id", TokenApiAuth(GetGridPaginateKey))
-	router.GET("/api/test/:id/ip", TokenApiAuth(ec2.GetMasterIP))
+	router.GET("/api/test/:id/ip", TokenApiAuth(MasterIP))
 	router.GET("/api/status/test", TokenApiAuth(GetTestStatus))
-	router.GET("/api/status/test/refresh", TokenApiAuth(RefreshTestStatus))
+	router.GET("/api/status/test/refresh", Audit("RefreshTestStatus", TokenApiAuth(RefreshTestStatus)))
 	router.GET("/api/test/:id/files", TokenApiAuth(TestFiles))
 	router.GET("/api/test/:id/files/download", TokenApiAuth(DownloadScriptFiles))
 	router.GET("/api/status/grid", TokenApiAuth(GetGridStatus))
 	router.GET("/api/grid/:id/deploylogs", TokenApiAuth(deployerLogs))
+	router.GET("/api/grid/:id/deploylogs/stream", TokenApiAuth(deployerLogsStream))
+	router.GET("/api/deployer/jobs", PowerTokenAPIAuth(DeployerJobs))
+	router.GET("/api/audit", PowerTokenAPIAuth(AuditLog))
 	router.GET("/api/grids/providers", TokenApiAuth(GetGridProviderTypes))
 	router.GET("/api/grids/regions", TokenApiAuth(GetGridRegionTypes))
 	router.GET("/api/grids/instances", TokenApiAuth(GetGridInstanceTypes))
 	router.GET("/api/grid/:id", TokenApiAuth(Grid))
-	router.POST("/api/grid/:id/delete", PowerTokenAPIAuth(DeleteGrid))
-	router.POST("/api/test/:id/delete", PowerTokenAPIAuth(DeleteTest))
-	router.POST("/api/test/:id/stop", PowerTokenAPIAuth(StopTest))
-	router.POST("/api/test/:id/cancel", PowerTokenAPIAuth(CancelTestDeployment))
+	router.GET("/api/grid/:id/nodes", TokenApiAuth(GridNodes))
+	router.GET("/api/grid/:id/history", TokenApiAuth(GridHistory))
+	router.GET("/api/grid/:id/queue", TokenApiAuth(GridQueue))
+	router.POST("/api/grid/:id/delete", Audit("DeleteGrid", PowerTokenAPIAuth(DeleteGrid)))
+	router.POST("/api/test/:id/delete", Audit("DeleteTest", PowerTokenAPIAuth(DeleteTest)))
+	router.POST("/api/test/:id/stop", Audit("StopTest", PowerTokenAPIAuth(StopTest)))
+	router.POST("/api/test/:id/cancel", Audit("CancelTestDeployment", PowerTokenAPIAuth(CancelTestDeployment)))
 	router.GET("/api/grids", TokenApiAuth(Grids))
-	router.POST("/api/grid", TokenApiAuth(CreateGrid))
+	router.POST("/api/grid", Audit("CreateGrid", TokenApiAuth(CreateGrid)))
 	router.GET("/api/tests", TokenApiAuth(Tests))
 	router.GET("/api/tests/:id", TokenApiAuth(TestsPaginate))
-	router.POST("/api/test/:id/start", PowerTokenAPIAuth(StartTest))
-	router.POST("/api/test/:id/duplicate", PowerTokenAPIAuth(DuplicateTest))
-	router.POST("/api/test/:id/attachment", PowerTokenAPIAuth(UploadTestAttachment))
-	router.POST("/api/test/:id/attachment/:attachmentid/delete", PowerTokenAPIAuth(DeleteTestAttachment))
+	router.POST("/api/test/:id/start", Audit("StartTest", PowerTokenAPIAuth(StartTest)))
+	router.POST("/api/test/:id/duplicate", Audit("DuplicateTest", PowerTokenAPIAuth(DuplicateTest)))
+	router.POST("/api/test/:id/attachment", Audit("UploadTestAttachment", PowerTokenAPIAuth(UploadTestAttachment)))
+	router.POST("/api/test/:id/attachment/:attachmentid/delete", Audit("DeleteTestAttachment", PowerTokenAPIAuth(DeleteTestAttachment)))
 	router.GET("/api/grids/list/:id", TokenApiAuth(GridsPaginate))
 	router.GET("/api/test", TokenApiAuth(Test))
-	router.POST("/api/test", TokenApiAuth(CreateTest))
-	router.POST("/api/grid_template", TokenApiAuth(CreateGridTemplate))
+	router.POST("/api/test", Audit("CreateTest", TokenApiAuth(CreateTest)))
+	router.POST("/api/grid_template", Audit("CreateGridTemplate", TokenApiAuth(CreateGridTemplate)))
 	router.GET("/api/grid_templates", TokenApiAuth(GetAllGridTemplates))
 	router.GET("/api/grid_template/:id", TokenApiAuth(GetGridTemplateById))
-	router.PUT("/api/grid_template/:id", TokenApiAuth(UpdateGridTemplate))
-	router.DELETE("/api/grid_template/:id", TokenApiAuth(DeleteGridTemplate))
+	router.PUT("/api/grid_template/:id", Audit("UpdateGridTemplate", TokenApiAuth(UpdateGridTemplate)))
+	router.DELETE("/api/grid_template/:id", Audit("DeleteGridTemplate", TokenApiAuth(DeleteGridTemplate)))
+	router.GET("/api/schedules", TokenApiAuth(Schedules))
+	router.POST("/api/schedule", Audit("CreateSchedule", PowerTokenAPIAuth(CreateSchedule)))
+	router.GET("/api/schedule/:id", TokenApiAuth(Schedule))
+	router.PUT("/api/schedule/:id", Audit("UpdateSchedule", PowerTokenAPIAuth(UpdateSchedule)))
+	router.DELETE("/api/schedule/:id", Audit("DeleteSchedule", PowerTokenAPIAuth(DeleteSchedule)))
+	router.POST("/api/schedule/:id/run", Audit("RunSchedule", PowerTokenAPIAuth(RunSchedule)))
+	router.GET("/api/schedule/:id/runs", TokenApiAuth(ScheduleRuns))
+}
+
+// userChange attributes a status change made by a request to the user of its token.
+func userChange(r *http.Request, reason string) db.StatusChange {
+	return db.StatusChange{Actor: jwt.TokenAudienceFromRequest(r), Reason: reason}
 }
 
 func TokenApiAuth(handler httprouter.Handle) httprouter.Handle {
@@ -0,0 +1,481 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/cron"
+	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/db"
+	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/jwt"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/common/events"
+	"github.com/att-cloudnative-labs/swarmhub/services/common/locust"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// ScheduleInterval is how often schedules are checked for a run that is due.
+var ScheduleInterval = time.Minute
+
+// scheduleRequest is the body of CreateSchedule and UpdateSchedule, a schedule is
+// enabled unless Enabled is false.
+type scheduleRequest struct {
+	Name           string
+	Cron           string
+	TestID         string
+	GridTemplateID string
+	MaxDuration    string
+	Enabled        *bool
+}
+
+// schedule validates the request and returns the schedule and its next run. The
+// cron expressions are evaluated in UTC.
+func (req scheduleRequest) schedule() (db.Schedule, time.Time, error) {
+	s := db.Schedule{Name: req.Name, Cron: req.Cron, TestID: req.TestID, GridTemplateID: req.GridTemplateID, MaxDuration: req.MaxDuration, Enabled: req.Enabled == nil || *req.Enabled}
+	var next time.Time
+
+	if s.Name == "" {
+		return s, next, fmt.Errorf("Name is required")
+	}
+
+	spec, err := cron.Parse(s.Cron)
+	if err != nil {
+		return s, next, err
+	}
+	if s.Enabled {
+		next = spec.Next(time.Now().UTC())
+		if next.IsZero() {
+			return s, next, fmt.Errorf("cron expression %q never fires", s.Cron)
+		}
+	}
+
+	if s.MaxDuration != "" {
+		d, err := time.ParseDuration(s.MaxDuration)
+		if err != nil || d < time.Second {
+			return s, next, fmt.Errorf("MaxDuration must be a duration of at least 1s, e.g. 30m, got %q", s.MaxDuration)
+		}
+	}
+
+	status, err := db.GetTestStatus(s.TestID)
+	if err != nil {
+		return s, next, fmt.Errorf("unknown test %q", s.TestID)
+	}
+	if status == "Deleted" {
+		return s, next, fmt.Errorf("test %v is deleted", s.TestID)
+	}
+
+	if s.MaxDuration == "" {
+		_, ok := profileDuration(s.TestID)
+		if !ok {
+			return s, next, fmt.Errorf("MaxDuration is required unless test %v has a load profile that ends", s.TestID)
+		}
+	}
+
+	_, err = db.GetGridTemplateById(s.GridTemplateID)
+	if err != nil {
+		return s, next, fmt.Errorf("unknown grid template %q", s.GridTemplateID)
+	}
+
+	return s, next, nil
+}
+
+// Schedules returns all schedules.
+func Schedules(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	schedules, err := db.GetSchedules()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	b, err := json.Marshal(schedules)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// Schedule returns a single schedule.
+func Schedule(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	schedule, err := db.GetScheduleByID(ps.ByName("id"))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Schedule not found.", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	b, err := json.Marshal(schedule)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// CreateSchedule stores a schedule that runs a copy of TestID on a grid built
+// from GridTemplateID whenever Cron fires.
+func CreateSchedule(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var req scheduleRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Unable to read the schedule: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	schedule, next, err := req.schedule()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid schedule: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	schedule.ID, err = db.CreateSchedule(schedule, next, jwt.TokenAudienceFromRequest(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	schedule, err = db.GetScheduleByID(schedule.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	b, err := json.Marshal(schedule)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(b)
+}
+
+// UpdateSchedule replaces the settings of a schedule, runs that already started
+// are not affected.
+func UpdateSchedule(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var req scheduleRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Unable to read the schedule: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	schedule, next, err := req.schedule()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid schedule: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	err = db.UpdateSchedule(ps.ByName("id"), schedule, next, jwt.TokenAudienceFromRequest(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write([]byte("Success!"))
+}
+
+// DeleteSchedule removes a schedule, runs that already started still finish.
+func DeleteSchedule(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	err := db.DeleteSchedule(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write([]byte("Success!"))
+}
+
+// RunSchedule starts a run of the schedule right away, e.g. before a release. The
+// times the schedule runs at are not changed.
+func RunSchedule(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	schedule, err := db.GetScheduleByID(ps.ByName("id"))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Schedule not found.", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	runID, err := startScheduledRun(schedule, jwt.TokenAudienceFromRequest(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write([]byte("Started run " + runID + " of schedule " + schedule.ID))
+}
+
+// ScheduleRuns returns the last runs of the schedule, up to items of them.
+func ScheduleRuns(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	items, err := strconv.Atoi(r.URL.Query().Get("items"))
+	if err != nil || items <= 0 {
+		items = 20
+	}
+
+	runs, err := db.ScheduleRuns(ps.ByName("id"), items)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(runs)
+}
+
+// RunSchedules periodically starts the runs of the schedules that are due. A run
+// missed while swarmhub was down is started once when it is back.
+func RunSchedules() {
+	for {
+		time.Sleep(ScheduleInterval)
+		if ShuttingDown {
+			return
+		}
+
+		now := time.Now().UTC()
+		schedules, err := db.DueSchedules(now)
+		if err != nil {
+			fmt.Println("failed to get due schedules:", err)
+			continue
+		}
+
+		for _, schedule := range schedules {
+			spec, err := cron.Parse(schedule.Cron)
+			if err != nil {
+				// schedules are validated when they are saved, this one was changed
+				// in the database
+				fmt.Printf("Schedule %v has an invalid cron expression, it won't run again: %v\n", schedule.ID, err)
+				_, err = db.ClaimScheduleRun(schedule.ID, schedule.NextRun, time.Time{})
+				if err != nil {
+					fmt.Println(err)
+				}
+				continue
+			}
+
+			claimed, err := db.ClaimScheduleRun(schedule.ID, schedule.NextRun, spec.Next(now))
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			if !claimed {
+				continue
+			}
+
+			_, err = startScheduledRun(schedule, actor)
+			if err != nil {
+				fmt.Printf("failed to run schedule %v: %v\n", schedule.ID, err)
+			}
+		}
+	}
+}
+
+// startScheduledRun copies the test of the schedule, builds a grid from its
+// template and queues the copy on the grid. The queue deploys and starts the test
+// once the grid is Available, updateScheduledRun takes it from there.
+func startScheduledRun(schedule db.Schedule, user string) (string, error) {
+	runID, err := db.CreateScheduleRun(schedule.ID, user)
+	if err != nil {
+		return "", err
+	}
+
+	fail := func(err error) (string, error) {
+		db.FinishScheduleRun(runID, "Failed", err.Error())
+		return runID, err
+	}
+
+	template, err := db.GetGridTemplateById(schedule.GridTemplateID)
+	if err != nil {
+		return fail(fmt.Errorf("unable to get grid template %v: %v", schedule.GridTemplateID, err))
+	}
+
+	testID, err := db.DuplicateTest(schedule.TestID)
+	if err != nil {
+		return fail(fmt.Errorf("unable to copy test %v: %v", schedule.TestID, err))
+	}
+
+	name := schedule.Name + " " + time.Now().UTC().Format("2006-01-02 15:04")
+	err = db.EditTestTitle(testID, name)
+	if err != nil {
+		fmt.Println(err)
+	}
+	maxDuration, _ := time.ParseDuration(schedule.MaxDuration)
+	if schedule.MaxDuration == "" {
+		// the run ends with its load profile, the maximum duration only catches a
+		// profile that never reports back
+		total, ok := profileDuration(testID)
+		if !ok {
+			return fail(fmt.Errorf("test %v has neither a MaxDuration nor a load profile that ends", testID))
+		}
+		maxDuration = total + locust.StartTimeout
+	}
+	err = db.UpdateTestMaxDuration(testID, maxDuration, user)
+	if err != nil {
+		return fail(err)
+	}
+
+	gridID, err := db.CreateGrid(name, template.Provider, template.Region, template.Master, template.Slave, template.Nodes, template.TTL, user)
+	if err != nil {
+		return fail(fmt.Errorf("unable to create a grid from template %v: %v", template.ID, err))
+	}
+
+	err = db.UpdateScheduleRunTest(runID, testID, gridID)
+	if err != nil {
+		return fail(err)
+	}
+
+	grid, err := gridByID(gridID)
+	if err != nil {
+		return fail(err)
+	}
+
+	provider, err := providerForGrid(grid)
+	if err != nil {
+		return fail(err)
+	}
+
+	change := db.StatusChange{Actor: user, Reason: "run of schedule " + schedule.Name}
+	err = provisionGrid(grid, provider, change)
+	if err != nil {
+		db.UpdateGridStatus(gridID, "Error", db.StatusChange{Actor: actor, Reason: err.Error()})
+		return fail(fmt.Errorf("unable to provision grid %v: %v", gridID, err))
+	}
+
+	_, err = queueTest(grid, testID, true, user)
+	if err != nil {
+		tearDownScheduledRun(db.ScheduleRun{ID: runID, GridID: gridID}, "Failed", err.Error())
+		return runID, err
+	}
+	startNextQueuedTest(gridID)
+
+	fmt.Printf("Schedule %v started run %v with test %v on grid %v\n", schedule.ID, runID, testID, gridID)
+	db.UpdateScheduleRunStatus(runID, "Provisioning", "")
+	return runID, nil
+}
+
+// profileDuration is how long the load profile of the test runs, false when it
+// has none or it runs until stopped.
+func profileDuration(testID string) (time.Duration, bool) {
+	profile, err := db.TestLoadProfile(testID)
+	if err != nil {
+		fmt.Println(err)
+		return 0, false
+	}
+	if profile == nil {
+		return 0, false
+	}
+	return profile.TotalDuration()
+}
+
+// finishedTestStatuses are the statuses of a test that doesn't run anymore.
+var finishedTestStatuses = map[string]bool{"Stopped": true, "Expired": true, "Error": true, "Ready": true, "Deleted": true}
+
+// updateScheduledRun follows the test and grid of a schedule run and tears the
+// grid down once the test is done.
+func updateScheduledRun(event events.Event) {
+	var id, status string
+	switch e := event.(type) {
+	case *events.TestStatusChanged:
+		id, status = e.TestID, e.Status
+	case *events.GridStatusChanged:
+		id, status = e.GridID, e.Status
+	case *events.LoadProfileFinished:
+		id = e.TestID
+	default:
+		return
+	}
+
+	run, ok, err := db.ActiveScheduleRun(id)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	if _, isProfile := event.(*events.LoadProfileFinished); isProfile {
+		// the run is done once the load profile ran, the test is stopped so the
+		// grid is cleaned and finishScheduledRunIfDone tears it down
+		db.UpdateTestStopReason(run.TestID, "load profile finished")
+		err = stopTest(run.GridID, run.TestID, "StopTest")
+		if err != nil {
+			tearDownScheduledRun(run, "Failed", fmt.Sprintf("unable to stop test %v: %v", run.TestID, err))
+		}
+		return
+	}
+
+	if _, isGrid := event.(*events.GridStatusChanged); isGrid {
+		switch status {
+		case "Error", "Timeout":
+			tearDownScheduledRun(run, "Failed", "grid "+run.GridID+" failed")
+		case "Deleted", "Expired", "Destroyed":
+			db.FinishScheduleRun(run.ID, "Failed", fmt.Sprintf("grid %v is %v before the test finished", run.GridID, status))
+		case "Available":
+			finishScheduledRunIfDone(run)
+		}
+		return
+	}
+
+	switch status {
+	case "Deployed":
+		db.UpdateScheduleRunStatus(run.ID, "Running", "")
+	case "Error", "Timeout":
+		tearDownScheduledRun(run, "Failed", "test "+run.TestID+" failed")
+	case "Stopped", "Expired":
+		finishScheduledRunIfDone(run)
+	}
+}
+
+// finishScheduledRunIfDone tears the grid down once the test finished and the
+// grid was cleaned. A test that is Ready again on an available grid could not be
+// deployed.
+func finishScheduledRunIfDone(run db.ScheduleRun) {
+	testStatus, err := db.GetTestStatus(run.TestID)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	gridStatus, err := db.GetGridStatus(run.GridID)
+	if err != nil {
+		return
+	}
+	if gridStatus != "Available" || !finishedTestStatuses[testStatus] {
+		return
+	}
+
+	if testStatus == "Stopped" {
+		tearDownScheduledRun(run, "Finished", "")
+		return
+	}
+	tearDownScheduledRun(run, "Failed", "test "+run.TestID+" is "+testStatus)
+}
+
+// tearDownScheduledRun ends the run and deletes its grid.
+func tearDownScheduledRun(run db.ScheduleRun, status string, reason string) {
+	finished, err := db.FinishScheduleRun(run.ID, status, reason)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if !finished {
+		return
+	}
+
+	fmt.Printf("Schedule run %v is %v, deleting grid %v\n", run.ID, status, run.GridID)
+	err = deprovisionGrid(run.GridID)
+	if err != nil {
+		fmt.Printf("failed to delete grid %v of schedule run %v: %v\n", run.GridID, run.ID, err)
+	}
+}
@@ -0,0 +1,29 @@
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestScheduleRequest checks the schedules that are rejected before they are
+// looked up in the database, so they are never stored.
+func TestScheduleRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     scheduleRequest
+		wantErr string
+	}{
+		{"no name", scheduleRequest{Cron: "@daily"}, "Name is required"},
+		{"cron expression that doesn't parse", scheduleRequest{Name: "nightly", Cron: "61 * * * *"}, "invalid minute"},
+		{"missing cron expression", scheduleRequest{Name: "nightly"}, "needs 5 fields"},
+		{"cron expression that never fires", scheduleRequest{Name: "nightly", Cron: "0 0 31 2 *"}, "never fires"},
+		{"max duration that isn't one", scheduleRequest{Name: "nightly", Cron: "@daily", MaxDuration: "soon"}, "MaxDuration must be a duration"},
+		{"max duration below a second", scheduleRequest{Name: "nightly", Cron: "@daily", MaxDuration: "10ms"}, "MaxDuration must be a duration"},
+	}
+	for _, test := range tests {
+		_, _, err := test.req.schedule()
+		if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%v: got error %v, want %q", test.name, err, test.wantErr)
+		}
+	}
+}
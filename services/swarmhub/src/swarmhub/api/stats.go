@@ -0,0 +1,84 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/db"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/common/locust"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// StatsTimeout is how long stopping a test waits for the statistics of its master.
+var StatsTimeout = 30 * time.Second
+
+// collectTestStats reads the statistics of the master of the grid, before it is
+// cleaned and they are gone.
+func collectTestStats(grid db.GridStruct, provider GridProvider) (locust.Stats, error) {
+	client, err := provider.LocustClient(grid)
+	if err != nil {
+		return locust.Stats{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), StatsTimeout)
+	defer cancel()
+	return client.Stats(ctx)
+}
+
+// recordTestStats keeps the statistics of a test that is being stopped, evaluates
+// its thresholds and compares it to its baseline.
+func recordTestStats(grid db.GridStruct, provider GridProvider, testID string) {
+	stats, err := collectTestStats(grid, provider)
+	if err != nil {
+		// without statistics there is nothing to judge the run by, its result is
+		// left to be set by hand
+		fmt.Printf("Unable to collect the statistics of test %v: %v\n", testID, err)
+		return
+	}
+
+	err = db.StoreTestStats(testID, stats)
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	result := db.DetailedResult{
+		Evaluation: evaluateThresholds(testID, stats),
+		Baseline:   compareToBaseline(testID, stats),
+	}
+	if result.Evaluation == nil && result.Baseline == nil {
+		return
+	}
+
+	err = db.UpdateTestDetailedResult(testID, result)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// TestStats returns the request counts, failures and response times of the test
+// by endpoint and in total, as they were when it was stopped.
+func TestStats(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	stats, ok, err := db.GetTestStats(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "No statistics were collected for this test.", http.StatusNotFound)
+		return
+	}
+
+	b, err := json.Marshal(stats)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
@@ -15,6 +15,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/att-cloudnative-labs/swarmhub/services/common/operations"
 	"github.com/julienschmidt/httprouter"
 )
 
@@ -53,62 +54,38 @@ func StartTest(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	var body struct {
 		GridID             string
 		StartAutomatically bool
-		GridRegion         string
 	}
 
 	json.NewDecoder(r.Body).Decode(&body)
 
-	gridReady, err := validateCanRunGrid(body.GridID)
-	if err != nil {
-		w.Write([]byte(fmt.Sprintf("Unable to validate grid state: %v", err.Error())))
-		return
-	}
-
-	if gridReady == false {
-		w.Write([]byte("This grid is not in a deployed state."))
-		return
-	}
-
 	testID := ps.ByName("id")
-	scriptID, scriptFilename, err := db.GetScriptFilename(testID)
-	if err != nil {
-		w.Write([]byte(fmt.Sprintf("Unable to get script filename %v", err.Error())))
-		return
-	}
-
 	gridID := body.GridID
-	gridRegion := body.GridRegion
-	gridStartAuto := strconv.FormatBool(body.StartAutomatically)
-
-	message := &natsMessage{ID: testID, Cmd: "/ansible/deployTest.sh", Params: []string{scriptID, scriptFilename, gridID, gridRegion, gridStartAuto}, DeploymentType: "Test"}
-	b, err := json.Marshal(message)
+	grid, err := gridByID(gridID)
 	if err != nil {
-		w.Write([]byte(fmt.Sprintf("Not publishing nats message. Failed to convert to json: %v", err.Error())))
+		w.Write([]byte(fmt.Sprintf("Unable to get grid %v", err.Error())))
 		return
 	}
 
-	err = db.UpdateTestStatus(ps.ByName("id"), "Queued")
-	if err != nil {
-		fmt.Println("Was unable to update test status! ", err.Error())
+	if !gridAcceptsQueue(grid.Status) {
+		w.Write([]byte("This grid is not in a deployed state."))
 		return
 	}
 
-	err = sendStartCmd(b)
+	// the test is deployed right away when the grid is available and free,
+	// otherwise it waits in the queue of the grid
+	position, err := queueTest(grid, testID, body.StartAutomatically, jwt.TokenAudienceFromRequest(r))
 	if err != nil {
-		w.Write([]byte(fmt.Sprintf("Was unable to send start command! %v", err.Error())))
-		db.UpdateTestStatus(ps.ByName("id"), "Ready")
+		w.Write([]byte(fmt.Sprintf("Was unable to queue the test! %v", err.Error())))
 		return
 	}
 
-	err = db.UpdateTestIDinGrid(gridID, testID)
-	if err != nil {
-		w.Write([]byte(fmt.Sprintf("Wasn't able to update Test ID in grid: %v", err.Error())))
+	deployed, failed := startNextQueuedTest(gridID)
+	if err, ok := failed[testID]; ok {
+		w.Write([]byte(err.Error()))
 		return
 	}
-
-	err = db.UpdateGridStatus(gridID, "Deployed")
-	if err != nil {
-		w.Write([]byte(fmt.Sprintf("Wasn't able to update Grid ID status: %v", err.Error())))
+	if deployed != testID {
+		w.Write([]byte(fmt.Sprintf("queued test id: %v on grid %v at position %v", testID, gridID, position)))
 		return
 	}
 
@@ -174,6 +151,40 @@ func UploadTestAttachment(w http.ResponseWriter, r *http.Request, ps httprouter.
 	w.Write(b)
 }
 
+// deployTest deploys the test to the available grid and assigns it to the grid.
+func deployTest(grid db.GridStruct, testID string, startAutomatically bool, change db.StatusChange) error {
+	provider, err := providerForGrid(grid)
+	if err != nil {
+		return err
+	}
+
+	err = db.UpdateTestStatus(testID, "Queued", change)
+	if err != nil {
+		err = fmt.Errorf("Was unable to update test status! %v", err.Error())
+		return err
+	}
+
+	err = provider.DeployTest(grid, testID, startAutomatically)
+	if err != nil {
+		db.UpdateTestStatus(testID, "Ready", db.StatusChange{Actor: change.Actor, Reason: "start command could not be sent"})
+		err = fmt.Errorf("Was unable to send start command! %v", err.Error())
+		return err
+	}
+
+	err = db.UpdateTestIDinGrid(grid.ID, testID)
+	if err != nil {
+		err = fmt.Errorf("Wasn't able to update Test ID in grid: %v", err.Error())
+		return err
+	}
+
+	err = db.UpdateGridStatus(grid.ID, "Deployed", db.StatusChange{Actor: change.Actor, Reason: "test " + testID + " deployed to the grid"})
+	if err != nil {
+		err = fmt.Errorf("Wasn't able to update Grid ID status: %v", err.Error())
+		return err
+	}
+	return nil
+}
+
 func validateCanRunTest(id string) (bool, error) {
 	var test db.Test
 	testBytes, err := db.TestByID(id)
@@ -188,11 +199,7 @@ func validateCanRunTest(id string) (bool, error) {
 		return false, err
 	}
 
-	if test.Status == "Ready" || test.Status == "Error" {
-		return true, nil
-	}
-
-	return false, nil
+	return test.Status != "Queued" && db.TestStates.CanTransition(test.Status, "Queued"), nil
 }
 
 func DuplicateTest(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
@@ -221,14 +228,31 @@ func DuplicateTest(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 // CancelTestDeployment cancels the test, marks it back as Ready, and cleans up the grid
 func CancelTestDeployment(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	testID := ps.ByName("id")
-	gridID, gridRegion, err := db.GetGridByTestID(testID)
+
+	// a test waiting in the queue of a grid only has to leave the queue
+	queueGridID, err := db.RemoveQueuedTest(testID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed to remove " + testID + " from its queue: " + err.Error()))
+		return
+	}
+	if queueGridID != "" {
+		err = db.UpdateTestStatus(testID, "Ready", userChange(r, "removed from the queue of grid "+queueGridID))
+		if err != nil {
+			fmt.Println("Was unable to update test status!", err.Error())
+		}
+		w.Write([]byte("removed test id: " + testID + " from the queue of grid " + queueGridID))
+		return
+	}
+
+	gridID, _, err := db.GetGridByTestID(testID)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("Failed to send stop command for: " + testID + " " + err.Error()))
 		return
 	}
 
-	message := &natsMessage{ID: testID, DeploymentType: "Test", Params: []string{gridID}}
+	message := &natsMessage{ID: testID, DeploymentType: "Test"}
 	b, err := json.Marshal(message)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -244,13 +268,13 @@ func CancelTestDeployment(w http.ResponseWriter, r *http.Request, ps httprouter.
 
 	// since there is no need to wait for the test cancellation to completely finish
 	// go ahead and update the test status so it can be redeployed if need be.
-	err = db.UpdateTestStatus(testID, "Ready")
+	err = db.UpdateTestStatus(testID, "Ready", userChange(r, "deployment cancelled"))
 	if err != nil {
 		fmt.Println("Was unable to update test status!", err.Error())
 	}
 
 	time.Sleep(1 * time.Second) // give some time to help ensure the stop command is run
-	err = stopTest(gridID, gridRegion, testID, "CancelTest")
+	err = stopTest(gridID, testID, "CancelTest")
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("Failed to stopTest within CancelTestDeployment: " + err.Error()))
@@ -264,7 +288,7 @@ func CancelTestDeployment(w http.ResponseWriter, r *http.Request, ps httprouter.
 // the grids database. Status changes are taken care of in the deployer microservice.
 func StopTest(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	testID := ps.ByName("id")
-	gridID, gridRegion, err := db.GetGridByTestID(testID)
+	gridID, _, err := db.GetGridByTestID(testID)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("Failed to send stop command for: " + ps.ByName("id") + " " + err.Error()))
@@ -277,7 +301,12 @@ func StopTest(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		return
 	}
 
-	err = stopTest(gridID, gridRegion, testID, "StopTest")
+	err = db.UpdateTestStopReason(testID, "stopped by "+jwt.TokenAudienceFromRequest(r))
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	err = stopTest(gridID, testID, "StopTest")
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("Failed to stopTest within StopTest: " + err.Error()))
@@ -285,16 +314,23 @@ func StopTest(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	}
 }
 
-func stopTest(gridID string, gridRegion string, testID string, deploymentType string) error {
-	message := &natsMessage{ID: gridID, Cmd: "/ansible/gridCleanup.sh", Params: []string{gridID, gridRegion, testID}, DeploymentType: deploymentType}
+func stopTest(gridID string, testID string, deploymentType string) error {
+	grid, err := gridByID(gridID)
+	if err != nil {
+		return err
+	}
 
-	b, err := json.Marshal(message)
+	provider, err := providerForGrid(grid)
 	if err != nil {
-		err = fmt.Errorf("Not publishing nats message. Failed to convert to json: %v", err.Error())
 		return err
 	}
 
-	err = sendStartCmd(b)
+	// the statistics are read before the stop, cleaning the grid resets locust
+	if deploymentType == "StopTest" && testID != "" {
+		recordTestStats(grid, provider, testID)
+	}
+
+	err = provider.StopTest(grid, testID, deploymentType)
 	if err != nil {
 		err = fmt.Errorf("Was unable to send start command! %v", err.Error())
 		return err
@@ -306,6 +342,61 @@ func stopTest(gridID string, gridRegion string, testID string, deploymentType st
 		return err
 	}
 
+	// the grid may already be Available again
+	startNextQueuedTest(gridID)
+	return nil
+}
+
+// TestDurationInterval is how often tests are checked for having run longer than
+// their maximum duration.
+var TestDurationInterval = time.Minute
+
+// durationReached is the stop reason of tests stopped by EnforceMaxDurations.
+const durationReached = "duration reached"
+
+// EnforceMaxDurations periodically stops the tests that have been running longer
+// than their maximum duration, the same way StopTest does.
+func EnforceMaxDurations() {
+	for {
+		time.Sleep(TestDurationInterval)
+		if ShuttingDown {
+			return
+		}
+
+		tests, err := db.GetOverdueTests()
+		if err != nil {
+			fmt.Println("failed to get overdue tests:", err)
+			continue
+		}
+
+		for _, test := range tests {
+			fmt.Printf("Test %v was launched at %v and reached its maximum duration of %v, stopping it.\n", test.ID, test.Launched, test.MaxDuration)
+			err := stopOverdueTest(test)
+			if err != nil {
+				fmt.Printf("failed to stop test %v: %v\n", test.ID, err)
+			}
+		}
+	}
+}
+
+// stopOverdueTest moves the test to Stopping before the stop is sent so it isn't
+// picked up again while the deployer works on it.
+func stopOverdueTest(test db.OverdueTest) error {
+	err := db.UpdateTestStopReason(test.ID, durationReached)
+	if err != nil {
+		return err
+	}
+
+	err = db.UpdateTestStatus(test.ID, "Stopping", db.StatusChange{Actor: actor, Reason: durationReached})
+	if err != nil {
+		return err
+	}
+
+	err = stopTest(test.GridID, test.ID, "StopTest")
+	if err != nil {
+		db.UpdateTestStatus(test.ID, "Error", db.StatusChange{Actor: actor, Reason: err.Error()})
+		return err
+	}
 	return nil
 }
 
@@ -392,6 +483,11 @@ func DownloadScriptFiles(w http.ResponseWriter, r *http.Request, ps httprouter.P
 
 func TestsPaginate(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	testID := ps.ByName("id")
+	// httprouter can't have /api/tests/compare next to /api/tests/:id
+	if testID == "compare" {
+		CompareTests(w, r, ps)
+		return
+	}
 	var itemsPerPage int
 	var err error
 
@@ -439,11 +535,16 @@ func extractDateFromURLQuery(dateString string, defaultDate time.Time) time.Time
 }
 
 func DeleteTest(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	err := db.DeleteTestByID(ps.ByName("id"))
+	err := db.DeleteTestByID(ps.ByName("id"), userChange(r, "test deleted"))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+
+	_, err = db.RemoveQueuedTest(ps.ByName("id"))
+	if err != nil {
+		fmt.Println(err)
+	}
 }
 
 func Tests(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
@@ -631,6 +732,79 @@ func EditTest(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		}
 	}
 
+	// an empty MaxDuration lets the test run until it is stopped by hand
+	if maxDuration, ok := r.Form["MaxDuration"]; ok {
+		var duration time.Duration
+		if maxDuration[0] != "" {
+			duration, err = time.ParseDuration(maxDuration[0])
+			if err != nil || duration < time.Second {
+				http.Error(w, fmt.Sprintf("MaxDuration must be a duration of at least 1s, e.g. 30m, got %q", maxDuration[0]), http.StatusBadRequest)
+				return
+			}
+		}
+
+		err := db.UpdateTestMaxDuration(id, duration, jwt.TokenAudienceFromRequest(r))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Write([]byte("Success!"))
+}
+
+// TestProfile returns the load profile of the test, null when it has none.
+func TestProfile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	profile, err := db.TestLoadProfile(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	b, err := json.Marshal(profile)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// SetTestProfile stores the load profile in the body for the test. Once the test is
+// deployed the deployer runs locust with it.
+func SetTestProfile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var profile operations.LoadProfile
+	err := json.NewDecoder(r.Body).Decode(&profile)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Unable to read the load profile: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	err = profile.Validate()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid load profile: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	err = db.UpdateTestLoadProfile(ps.ByName("id"), &profile, jwt.TokenAudienceFromRequest(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write([]byte("Success!"))
+}
+
+// DeleteTestProfile removes the load profile of the test, locust is then started
+// from its UI again.
+func DeleteTestProfile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	err := db.UpdateTestLoadProfile(ps.ByName("id"), nil, jwt.TokenAudienceFromRequest(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	w.Write([]byte("Success!"))
 }
 
@@ -714,7 +888,7 @@ func CreateTest(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	testFiles.Name = fileheader.Filename
 
 	for _, zipFile := range zipReader.File {
-		fileInfo := db.TestFile{zipFile.Name, zipFile.UncompressedSize, zipFile.Modified}
+		fileInfo := db.TestFile{FileName: zipFile.Name, Size: zipFile.UncompressedSize, Modified: zipFile.Modified}
 
 		// locustfile.py needs to be in the base directory for the zip file to be valid.
 		if zipFile.Name == "locustfile.py" {
@@ -758,7 +932,7 @@ func CreateTest(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		return
 	}
 
-	go storage.UploadScript(testID, scriptID, testFiles.Name, file)
+	go storage.UploadScript(testID, scriptID, testFiles.Name, file, user)
 
 	desc := "Looks good, sent off to upload!"
 	resp := response{success, desc}
@@ -803,9 +977,21 @@ func GetGridStatus(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 	w.Write(b)
 }
 
+// TestHistory returns the status changes of the test, oldest first.
+func TestHistory(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	history, err := db.TestStatusHistory(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(history)
+}
+
 // RefreshTestStatus looks at the current tests that are in a deployed state
 func RefreshTestStatus(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	err := db.RefreshTestStatus()
+	err := db.RefreshTestStatus(jwt.TokenAudienceFromRequest(r))
 	if err != nil {
 		message := fmt.Sprintf("was unable to perform db.RefreshTestStatus: %v\n", err)
 		http.Error(w, message, http.StatusBadRequest)
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/db"
+	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/jwt"
+	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/slo"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/common/locust"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// TestThresholds returns the threshold rules of the test, null when it has none.
+func TestThresholds(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	rules, err := db.TestThresholds(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	b, err := json.Marshal(rules)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// SetTestThresholds stores the list of threshold rules in the body for the test.
+// They are evaluated when the test is stopped and decide its result.
+func SetTestThresholds(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var rules []slo.Rule
+	err := json.NewDecoder(r.Body).Decode(&rules)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Unable to read the thresholds: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	err = slo.ValidateRules(rules)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid thresholds: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	err = db.UpdateTestThresholds(ps.ByName("id"), rules, jwt.TokenAudienceFromRequest(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write([]byte("Success!"))
+}
+
+// DeleteTestThresholds removes the threshold rules of the test, its result is then
+// set by hand again.
+func DeleteTestThresholds(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	err := db.UpdateTestThresholds(ps.ByName("id"), nil, jwt.TokenAudienceFromRequest(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write([]byte("Success!"))
+}
+
+// TestEvaluation returns the outcome of every threshold rule and the comparison to
+// the baseline from when the test was last stopped.
+func TestEvaluation(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	evaluation, err := db.TestEvaluation(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(evaluation)
+}
+
+// evaluateThresholds evaluates the threshold rules of a test against the
+// statistics of its run, nil when it has none.
+func evaluateThresholds(testID string, stats locust.Stats) *slo.Evaluation {
+	rules, err := db.TestThresholds(testID)
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	evaluation := slo.Evaluate(rules, stats)
+
+	fmt.Printf("Test %v evaluated to %q, %v of %v thresholds passed\n", testID, evaluation.Result, evaluation.Passed, len(rules))
+	return &evaluation
+}
@@ -0,0 +1,53 @@
+package compare
+
+// Regression is a metric of an endpoint that got worse than in the baseline.
+type Regression struct {
+	Endpoint     string
+	Metric       string
+	Baseline     float64
+	Value        float64
+	DeltaPercent *float64 `json:",omitempty"`
+}
+
+// BaselineComparison is the outcome of comparing a run to the baseline of its
+// tests. Error says why it could not be compared, Regressions is 0 then.
+type BaselineComparison struct {
+	BaselineTestID string
+	Tolerance      float64
+	Regressions    int
+	Regressed      []Regression
+	Error          string `json:",omitempty"`
+}
+
+// AgainstBaseline compares the run to the baseline and lists the metrics that are
+// more than tolerance percent worse.
+func AgainstBaseline(baseline Run, run Run, tolerance float64) BaselineComparison {
+	result := BaselineComparison{BaselineTestID: baseline.TestID, Tolerance: tolerance, Regressed: []Regression{}}
+	switch {
+	case baseline.Stats == nil:
+		result.Error = "the baseline has no statistics"
+		return result
+	case run.Stats == nil:
+		result.Error = "the run has no statistics"
+		return result
+	}
+
+	comparison := Compare([]Run{baseline, run}, tolerance)
+	for _, endpoint := range comparison.Endpoints {
+		for _, metric := range endpoint.Metrics {
+			v := metric.Values[1]
+			if !v.Regression {
+				continue
+			}
+			result.Regressed = append(result.Regressed, Regression{
+				Endpoint:     endpoint.Endpoint,
+				Metric:       metric.Metric,
+				Baseline:     metric.Values[0].Value,
+				Value:        v.Value,
+				DeltaPercent: v.DeltaPercent,
+			})
+		}
+	}
+	result.Regressions = len(result.Regressed)
+	return result
+}
@@ -0,0 +1,172 @@
+// Package compare lines up the locust statistics of several runs of a test by
+// endpoint and flags the metrics that got worse than in the first run.
+package compare
+
+import (
+	"math"
+	"sort"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/slo"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/common/locust"
+)
+
+// DefaultTolerance is how much worse than the reference, in percent, a metric may
+// get before it is a regression.
+const DefaultTolerance = 10.0
+
+// Metric is a metric that is compared, as named by the slo rules. Direction is -1
+// when lower is better, 1 when higher is better and 0 when it only informs.
+type Metric struct {
+	Name      string
+	Direction int
+}
+
+// Metrics are the metrics runs are compared by.
+var Metrics = []Metric{
+	{"requests", 0},
+	{"rps", 1},
+	{"failure_ratio", -1},
+	{"avg", -1},
+	{"median", -1},
+	{"p95", -1},
+	{"p99", -1},
+	{"max", -1},
+}
+
+// Run is a test run to compare, Stats is nil when none were collected.
+type Run struct {
+	TestID      string
+	Name        string
+	Status      string
+	Launched    string
+	Stopped     string
+	SnapshotURL string
+	URL         string
+	Stats       *locust.Stats `json:"-"`
+	HasStats    bool
+}
+
+// Value is a metric of a run. Delta is the difference to the reference run,
+// DeltaPercent relative to it when the reference isn't 0. Missing is set when the
+// run has no such endpoint or metric.
+type Value struct {
+	Value        float64
+	Delta        float64
+	DeltaPercent *float64 `json:",omitempty"`
+	Regression   bool
+	Missing      bool `json:",omitempty"`
+}
+
+// MetricComparison is a metric of an endpoint in every run, in the order of the runs.
+type MetricComparison struct {
+	Metric string
+	Values []Value
+}
+
+// EndpointComparison is an endpoint, empty for all requests together.
+type EndpointComparison struct {
+	Endpoint    string
+	Metrics     []MetricComparison
+	Regressions int
+}
+
+// Comparison lines up the runs, the first one is the reference the others are
+// compared to.
+type Comparison struct {
+	Tolerance   float64
+	Runs        []Run
+	Endpoints   []EndpointComparison
+	Regressions int
+}
+
+// Compare compares the runs to the first one. A metric is a regression when it is
+// more than tolerance percent worse, or worse at all when it was 0.
+func Compare(runs []Run, tolerance float64) Comparison {
+	comparison := Comparison{Tolerance: tolerance, Runs: runs}
+	if len(runs) == 0 {
+		return comparison
+	}
+
+	for _, endpoint := range endpoints(runs) {
+		ec := EndpointComparison{Endpoint: endpoint}
+		for _, metric := range Metrics {
+			mc := MetricComparison{Metric: metric.Name}
+			reference, referenceOK := value(runs[0], endpoint, metric.Name)
+			for _, run := range runs {
+				v, ok := value(run, endpoint, metric.Name)
+				if !ok {
+					mc.Values = append(mc.Values, Value{Missing: true})
+					continue
+				}
+
+				cell := Value{Value: v}
+				if referenceOK {
+					cell.Delta = v - reference
+					if reference != 0 {
+						percent := cell.Delta / reference * 100
+						cell.DeltaPercent = &percent
+					}
+					cell.Regression = regressed(metric, reference, v, tolerance)
+				}
+				if cell.Regression {
+					ec.Regressions++
+				}
+				mc.Values = append(mc.Values, cell)
+			}
+			ec.Metrics = append(ec.Metrics, mc)
+		}
+		comparison.Regressions += ec.Regressions
+		comparison.Endpoints = append(comparison.Endpoints, ec)
+	}
+	return comparison
+}
+
+func regressed(metric Metric, reference float64, v float64, tolerance float64) bool {
+	switch metric.Direction {
+	case -1:
+		if reference == 0 {
+			return v > 0
+		}
+		return v > reference*(1+tolerance/100)
+	case 1:
+		return v < reference*(1-tolerance/100)
+	}
+	return false
+}
+
+// endpoints returns the endpoints of all runs, the total first and the others
+// sorted.
+func endpoints(runs []Run) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, run := range runs {
+		if run.Stats == nil {
+			continue
+		}
+		for _, r := range run.Stats.Requests {
+			name := r.Method + " " + r.Name
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return append([]string{""}, names...)
+}
+
+func value(run Run, endpoint string, metric string) (float64, bool) {
+	if run.Stats == nil {
+		return 0, false
+	}
+	s, err := run.Stats.Endpoint(endpoint)
+	if err != nil || s.Requests == 0 {
+		return 0, false
+	}
+	v, err := slo.MetricValue(s, metric)
+	if err != nil || math.IsNaN(v) {
+		return 0, false
+	}
+	return v, true
+}
@@ -0,0 +1,134 @@
+package compare
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/common/locust"
+)
+
+func stats(total locust.RequestStats, requests ...locust.RequestStats) *locust.Stats {
+	return &locust.Stats{Requests: requests, Total: total}
+}
+
+var baselineStats = stats(
+	locust.RequestStats{Requests: 1000, Failures: 0, AvgResponseTime: 100, MedianResponseTime: 90, MaxResponseTime: 500, RPS: 50, Percentiles: map[string]float64{"95": 200, "99": 300}},
+	locust.RequestStats{Method: "GET", Name: "/", Requests: 800, AvgResponseTime: 80, MedianResponseTime: 70, MaxResponseTime: 300, RPS: 40},
+	locust.RequestStats{Method: "POST", Name: "/login", Requests: 200, AvgResponseTime: 180, MedianResponseTime: 170, MaxResponseTime: 500, RPS: 10},
+)
+
+// regressions lists the regressed metrics like "GET / avg".
+func regressions(c BaselineComparison) []string {
+	var names []string
+	for _, r := range c.Regressed {
+		names = append(names, strings.TrimSpace(r.Endpoint+" "+r.Metric))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestAgainstBaseline(t *testing.T) {
+	tests := []struct {
+		name      string
+		baseline  *locust.Stats
+		run       *locust.Stats
+		tolerance float64
+		want      []string
+		wantErr   string
+	}{
+		{
+			name:      "same run",
+			baseline:  baselineStats,
+			run:       baselineStats,
+			tolerance: DefaultTolerance,
+		},
+		{
+			name:     "within tolerance",
+			baseline: baselineStats,
+			run: stats(
+				locust.RequestStats{Requests: 1000, AvgResponseTime: 109, MedianResponseTime: 90, MaxResponseTime: 500, RPS: 46, Percentiles: map[string]float64{"95": 210, "99": 300}},
+				locust.RequestStats{Method: "GET", Name: "/", Requests: 800, AvgResponseTime: 85, MedianResponseTime: 70, MaxResponseTime: 300, RPS: 40},
+			),
+			tolerance: DefaultTolerance,
+		},
+		{
+			name:     "slower and fewer requests per second",
+			baseline: baselineStats,
+			run: stats(
+				locust.RequestStats{Requests: 1000, AvgResponseTime: 150, MedianResponseTime: 90, MaxResponseTime: 500, RPS: 30, Percentiles: map[string]float64{"95": 200, "99": 300}},
+				locust.RequestStats{Method: "GET", Name: "/", Requests: 800, AvgResponseTime: 80, MedianResponseTime: 70, MaxResponseTime: 300, RPS: 40},
+				locust.RequestStats{Method: "POST", Name: "/login", Requests: 200, AvgResponseTime: 180, MedianResponseTime: 170, MaxResponseTime: 900, RPS: 10},
+			),
+			tolerance: DefaultTolerance,
+			want:      []string{"POST /login max", "avg", "rps"},
+		},
+		{
+			name:     "failures where there were none",
+			baseline: baselineStats,
+			run: stats(
+				locust.RequestStats{Requests: 1000, Failures: 1, AvgResponseTime: 100, MedianResponseTime: 90, MaxResponseTime: 500, RPS: 50, Percentiles: map[string]float64{"95": 200, "99": 300}},
+			),
+			tolerance: 50,
+			want:      []string{"failure_ratio"},
+		},
+		{
+			name:     "a larger tolerance",
+			baseline: baselineStats,
+			run: stats(
+				locust.RequestStats{Requests: 1000, AvgResponseTime: 140, MedianResponseTime: 90, MaxResponseTime: 500, RPS: 50, Percentiles: map[string]float64{"95": 200, "99": 300}},
+			),
+			tolerance: 50,
+		},
+		{
+			name:     "a new endpoint isn't a regression",
+			baseline: baselineStats,
+			run: stats(
+				locust.RequestStats{Requests: 1000, AvgResponseTime: 100, MedianResponseTime: 90, MaxResponseTime: 500, RPS: 50, Percentiles: map[string]float64{"95": 200, "99": 300}},
+				locust.RequestStats{Method: "GET", Name: "/new", Requests: 10, AvgResponseTime: 5000, RPS: 1},
+			),
+			tolerance: DefaultTolerance,
+		},
+		{
+			name:      "baseline without statistics",
+			run:       baselineStats,
+			tolerance: DefaultTolerance,
+			wantErr:   "the baseline has no statistics",
+		},
+		{
+			name:      "run without statistics",
+			baseline:  baselineStats,
+			tolerance: DefaultTolerance,
+			wantErr:   "the run has no statistics",
+		},
+	}
+
+	for _, test := range tests {
+		c := AgainstBaseline(Run{TestID: "baseline", Stats: test.baseline}, Run{TestID: "run", Stats: test.run}, test.tolerance)
+		if c.Error != test.wantErr {
+			t.Errorf("%v: got error %q, want %q", test.name, c.Error, test.wantErr)
+		}
+		if c.BaselineTestID != "baseline" || c.Tolerance != test.tolerance {
+			t.Errorf("%v: compared to %v with tolerance %v", test.name, c.BaselineTestID, c.Tolerance)
+		}
+		got := regressions(c)
+		if strings.Join(got, ",") != strings.Join(test.want, ",") || c.Regressions != len(test.want) {
+			t.Errorf("%v: got %v regressions %v, want %v", test.name, c.Regressions, got, test.want)
+		}
+		if c.Regressed == nil {
+			t.Errorf("%v: regressed is nil, it is kept as json", test.name)
+		}
+	}
+}
+
+func TestAgainstBaselineDelta(t *testing.T) {
+	run := stats(locust.RequestStats{Requests: 1000, AvgResponseTime: 125, MedianResponseTime: 90, MaxResponseTime: 500, RPS: 50, Percentiles: map[string]float64{"95": 200, "99": 300}})
+	c := AgainstBaseline(Run{Stats: baselineStats}, Run{Stats: run}, DefaultTolerance)
+	if len(c.Regressed) != 1 {
+		t.Fatalf("got regressions %v", regressions(c))
+	}
+	r := c.Regressed[0]
+	if r.Endpoint != "" || r.Metric != "avg" || r.Baseline != 100 || r.Value != 125 || r.DeltaPercent == nil || *r.DeltaPercent != 25 {
+		t.Errorf("got %+v", r)
+	}
+}
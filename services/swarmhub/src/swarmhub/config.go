@@ -6,6 +6,7 @@ import (
 
 	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/api"
 	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/db"
+	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/k8s"
 	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/storage"
 	"github.com/spf13/viper"
 )
@@ -26,18 +27,21 @@ func ConfigSet() {
 	ldapSet()
 	storageSet()
 	grafanaSet()
+	kubernetesSet()
+	localSet()
 
 	tlsCertFileLoc = Registry.GetString("TLS_CERT_FILE_LOC")
 	tlsKeyFileLoc = Registry.GetString("TLS_KEY_FILE_LOC")
 	UserFileLocation = Registry.GetString("USER_FILE_LOCATION")
 	htmlDir = Registry.GetString("HTML_DIR")
+	api.DeployerJobsAddress = Registry.GetString("DEPLOYER_JOBS_ADDRESS")
+	api.DeployerRetryAttempts = Registry.GetInt("DEPLOYER_RETRY_MAX_ATTEMPTS")
+	api.DeployerRetryBackoff = Registry.GetString("DEPLOYER_RETRY_BACKOFF")
 	db.SourceName = Registry.GetString("DB_SOURCE_NAME")
 	db.Set()
 
-	lmSecGroups := Registry.GetStringSlice("LOCUST_MASTER_SECURITY_GROUPS")
-	lsSecGroups := Registry.GetStringSlice("LOCUST_SLAVE_SECURITY_GROUPS")
-	api.LocustMasterSecurityGroups = convertSliceToBashString(lmSecGroups)
-	api.LocustSlaveSecurityGroups = convertSliceToBashString(lsSecGroups)
+	api.LocustMasterSecurityGroups = Registry.GetStringSlice("LOCUST_MASTER_SECURITY_GROUPS")
+	api.LocustSlaveSecurityGroups = Registry.GetStringSlice("LOCUST_SLAVE_SECURITY_GROUPS")
 
 }
 
@@ -71,6 +75,7 @@ func storageSet() {
 	region := Registry.GetString("AWS_S3_REGION")
 	bucket := Registry.GetString("AWS_S3_BUCKET")
 
+	storage.Endpoint = Registry.GetString("AWS_S3_ENDPOINT")
 	err := storage.SetS3(accessKey, secretAccessKey, region, bucket)
 	if err != nil {
 		log.Fatal("Failed to performa storage.SetS3:", err)
@@ -86,15 +91,22 @@ func grafanaSet() {
 	api.GrafanaDashboardUID = Registry.GetString("GRAFANA_DASHBOARD_UID")
 }
 
-func convertSliceToBashString(list []string) string {
-	listBytes := []byte("[")
-	for i, v := range list {
-		if i == 0 {
-			listBytes = append(listBytes, "\""+v+"\""...)
-			continue
-		}
-		listBytes = append(listBytes, ", \""+v+"\""...)
+func kubernetesSet() {
+	if !Registry.GetBool("KUBERNETES_ENABLED") {
+		return
+	}
+
+	client, err := k8s.NewInClusterClient(Registry.GetString("KUBERNETES_LOCUST_IMAGE"), Registry.GetString("KUBERNETES_PROXY_IMAGE"))
+	if err != nil {
+		log.Fatal("Failed to create kubernetes client:", err)
 	}
-	listBytes = append(listBytes, "]"...)
-	return string(listBytes)
+	api.EnableKubernetesProvider(client)
+}
+
+func localSet() {
+	if !Registry.GetBool("LOCAL_GRIDS_ENABLED") {
+		return
+	}
+
+	api.EnableLocalProvider(Registry.GetString("LOCAL_MASTER_ADDRESS"))
 }
@@ -0,0 +1,101 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"* * * foo *",
+		"@sometimes",
+	}
+	for _, spec := range tests {
+		_, err := Parse(spec)
+		if err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	at := func(value string) time.Time {
+		v, err := time.Parse("2006-01-02 15:04:05", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	tests := []struct {
+		spec string
+		from string
+		want string
+	}{
+		{"30 2 * * 1-5", "2024-01-01 00:00:00", "2024-01-01 02:30:00"},
+		{"30 2 * * 1-5", "2024-01-05 03:00:00", "2024-01-08 02:30:00"},
+		{"30 2 * * mon-fri", "2024-01-05 03:00:00", "2024-01-08 02:30:00"},
+		{"@daily", "2024-01-01 00:00:00", "2024-01-02 00:00:00"},
+		{"@DAILY", "2024-01-01 00:00:00", "2024-01-02 00:00:00"},
+		{"@hourly", "2024-01-01 10:59:30", "2024-01-01 11:00:00"},
+		{"@weekly", "2024-01-01 00:00:00", "2024-01-07 00:00:00"},
+		{"@yearly", "2024-06-01 00:00:00", "2025-01-01 00:00:00"},
+		{"*/15 * * * *", "2024-01-01 10:07:00", "2024-01-01 10:15:00"},
+		{"5/15 * * * *", "2024-01-01 10:21:00", "2024-01-01 10:35:00"},
+		{"0,30 8-9 1 * *", "2024-01-01 09:30:00", "2024-02-01 08:00:00"},
+		{"0 0 29 2 *", "2024-03-01 00:00:00", "2028-02-29 00:00:00"},
+		// either day field matches when both are restricted
+		{"0 12 13 * 5", "2024-01-01 00:00:00", "2024-01-05 12:00:00"},
+		{"0 12 13 * 5", "2024-01-12 13:00:00", "2024-01-13 12:00:00"},
+		{"0 9 * jan-mar sun", "2024-03-31 10:00:00", "2025-01-05 09:00:00"},
+		{"0 0 * * 7", "2024-01-01 00:00:00", "2024-01-07 00:00:00"},
+		{"0 0 * * 0", "2024-01-01 00:00:00", "2024-01-07 00:00:00"},
+	}
+	for _, test := range tests {
+		s, err := Parse(test.spec)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", test.spec, err)
+			continue
+		}
+		got := s.Next(at(test.from))
+		if !got.Equal(at(test.want)) {
+			t.Errorf("%q after %v is %v, want %v", test.spec, test.from, got, test.want)
+		}
+	}
+}
+
+func TestNextNever(t *testing.T) {
+	s, err := Parse("0 0 31 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := s.Next(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	if !got.IsZero() {
+		t.Errorf("the 31st of February is %v, want the zero time", got)
+	}
+}
+
+func TestNextKeepsLocation(t *testing.T) {
+	location := time.FixedZone("UTC+2", 2*60*60)
+	s, err := Parse("0 3 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := s.Next(time.Date(2024, 1, 1, 0, 0, 0, 0, location))
+	want := time.Date(2024, 1, 1, 3, 0, 0, 0, location)
+	if !got.Equal(want) || got.Location() != location {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AuditEntry is an API request that changed, or tried to change, something.
+type AuditEntry struct {
+	ID       string
+	Created  string
+	User     string
+	Action   string
+	Method   string
+	Path     string
+	TargetID string
+	Payload  string
+	// Status is the HTTP status of the response and Result the start of its body.
+	Status int
+	Result string
+}
+
+// AuditFilter narrows down the audit log, empty fields match everything.
+type AuditFilter struct {
+	User      string
+	Action    string
+	TargetID  string
+	StartDate time.Time
+	EndDate   time.Time
+}
+
+// RecordAudit adds the entry to portal.audit_log. Its ID and Created are set by the database.
+func RecordAudit(entry AuditEntry) error {
+	sqlString := `INSERT INTO portal.audit_log (username, action, method, path, target_id, payload, status, result)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	_, err := db.Exec(sqlString, entry.User, entry.Action, entry.Method, entry.Path, entry.TargetID, entry.Payload, entry.Status, entry.Result)
+	if err != nil {
+		err = fmt.Errorf("unable to record audit entry for %v: %v", entry.Action, err)
+		return err
+	}
+	return nil
+}
+
+// AuditLog returns the entries matching the filter as json, newest first. It returns
+// at most limit entries after skipping offset of them, along with the number of
+// entries matching the filter.
+func AuditLog(filter AuditFilter, limit int, offset int) ([]byte, error) {
+	var b []byte
+
+	var conditions []string
+	var args []interface{}
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	addCondition("created >= $%v", filter.StartDate.UTC())
+	addCondition("created <= $%v", filter.EndDate.UTC())
+	if filter.User != "" {
+		addCondition("username = $%v", filter.User)
+	}
+	if filter.Action != "" {
+		addCondition("action = $%v", filter.Action)
+	}
+	if filter.TargetID != "" {
+		addCondition("target_id = $%v", filter.TargetID)
+	}
+	where := " WHERE " + strings.Join(conditions, " AND ")
+
+	var total int
+	err := db.QueryRow("SELECT count(*) FROM portal.audit_log"+where, args...).Scan(&total)
+	if err != nil {
+		err = fmt.Errorf("unable to count audit entries: %v", err)
+		return b, err
+	}
+
+	query := `SELECT id, created, username, action, method, path, target_id, payload, status, result
+		FROM portal.audit_log` + where + fmt.Sprintf(" ORDER BY created DESC LIMIT %d OFFSET %d", limit, offset)
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		err = fmt.Errorf("unable to get audit entries: %v", err)
+		return b, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		var created time.Time
+		if err := rows.Scan(&entry.ID, &created, &entry.User, &entry.Action, &entry.Method, &entry.Path, &entry.TargetID, &entry.Payload, &entry.Status, &entry.Result); err != nil {
+			err = fmt.Errorf("unable to read audit entry: %v", err)
+			return b, err
+		}
+		entry.Created = created.UTC().Format(time.RFC3339)
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		err = fmt.Errorf("unable to read audit entries: %v", err)
+		return b, err
+	}
+
+	type auditPage struct {
+		Entries []AuditEntry
+		Total   int
+	}
+
+	return json.Marshal(auditPage{Entries: entries, Total: total})
+}
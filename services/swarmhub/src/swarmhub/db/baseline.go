@@ -0,0 +1,77 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Baseline is the run that the other tests sharing its script, the copies of a
+// test, are compared to when they stop.
+type Baseline struct {
+	ScriptID string
+	TestID   string
+	// Tolerance is how much worse than the baseline, in percent, a metric may
+	// get before it is a regression.
+	Tolerance float64
+	SetBy     string
+	Created   string
+}
+
+// SetBaseline makes the test the baseline of the tests sharing its script,
+// replacing the previous one.
+func SetBaseline(testID string, tolerance float64, user string) error {
+	sqlString := `UPSERT INTO portal.test_baseline (script_id, test_id, tolerance, set_by_user, created)
+		SELECT script_id, id, $2, $3, current_timestamp() FROM portal.test WHERE id = $1 AND script_id IS NOT NULL`
+	res, err := db.Exec(sqlString, testID, tolerance, user)
+	if err != nil {
+		err = fmt.Errorf("unable to set test %v as baseline: %v", testID, err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("test %v has no script", testID)
+	}
+	return nil
+}
+
+// RemoveBaseline stops the test from being a baseline, ok is false when it wasn't
+// one.
+func RemoveBaseline(testID string) (bool, error) {
+	res, err := db.Exec("DELETE FROM portal.test_baseline WHERE test_id = $1", testID)
+	if err != nil {
+		err = fmt.Errorf("unable to remove test %v as baseline: %v", testID, err)
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		err = fmt.Errorf("unable to remove test %v as baseline: %v", testID, err)
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// BaselineOfTest returns the baseline of the tests sharing the script of the test,
+// ok is false when there is none.
+func BaselineOfTest(testID string) (baseline Baseline, ok bool, err error) {
+	sqlString := `SELECT b.script_id, b.test_id, b.tolerance, b.set_by_user, b.created FROM portal.test_baseline b
+		INNER JOIN portal.test t ON t.script_id = b.script_id
+		INNER JOIN portal.test bt ON bt.id = b.test_id
+		WHERE t.id = $1 AND bt.deleted = false`
+
+	var created pq.NullTime
+	err = db.QueryRow(sqlString, testID).Scan(&baseline.ScriptID, &baseline.TestID, &baseline.Tolerance, &baseline.SetBy, &created)
+	if err == sql.ErrNoRows {
+		return baseline, false, nil
+	}
+	if err != nil {
+		err = fmt.Errorf("unable to get the baseline of test %v: %v", testID, err)
+		return baseline, false, err
+	}
+
+	if created.Valid {
+		baseline.Created = created.Time.Format(time.RFC3339)
+	}
+	return baseline, true, nil
+}
@@ -37,6 +37,10 @@ type Test struct {
 	Launched    string
 	Stopped     string
 	SnapshotURL string
+	// MaxDuration is how long the test may run before it is stopped, empty when
+	// it runs until stopped by hand. StopReason is why it was last stopped.
+	MaxDuration string
+	StopReason  string
 }
 
 type TestFiles struct {
@@ -89,6 +93,12 @@ func Set() {
 	}
 }
 
+// Use makes the package work with an already opened database instead of
+// connecting with Set, e.g. a mock in tests.
+func Use(conn *sql.DB) {
+	db = conn
+}
+
 // UpdateTestIDinGrid is used to update the test id that is associated with a grid.
 func UpdateTestIDinGrid(gridID string, testID string) error {
 	var err error
@@ -223,7 +233,7 @@ func LatestTests(limit int, startDate time.Time, endDate time.Time, search strin
 			stopped = "-"
 		}
 
-		tests = append(tests, Test{id, name, desc, status, nullStringToStringSlice(labels), result, created, launched, stopped, ""})
+		tests = append(tests, Test{id, name, desc, status, nullStringToStringSlice(labels), result, created, launched, stopped, "", "", ""})
 	}
 
 	b, err = json.Marshal(tests)
@@ -268,7 +278,7 @@ func GetScriptFilename(id string) (string, string, error) {
 
 // UpdateTestStatusThatUsesGrid makes a call to get the test id associated to the grid id
 // it then makes a call to the function that updates test status.
-func UpdateTestStatusThatUsesGrid(gridid string, status string) (string, error) {
+func UpdateTestStatusThatUsesGrid(gridid string, status string, change StatusChange) (string, error) {
 	query := "SELECT test_id FROM portal.grid WHERE id = $1"
 
 	var testid string
@@ -281,7 +291,7 @@ func UpdateTestStatusThatUsesGrid(gridid string, status string) (string, error)
 		return testid, err
 	}
 
-	err = UpdateTestStatus(testid, status)
+	err = UpdateTestStatus(testid, status, change)
 	return testid, err
 }
 
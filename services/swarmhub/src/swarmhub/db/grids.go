@@ -4,13 +4,17 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"time"
 )
 
-func UpdateGridStatus(id string, status string) error {
+// UpdateGridStatus changes the status of the grid. Changes GridStates doesn't allow are
+// rejected with a *TransitionError, the others are recorded in portal.grid_status_history
+// with the change.
+func UpdateGridStatus(id string, status string, change StatusChange) error {
 
 	sql := "UPDATE portal.grid SET status_id = (SELECT id from portal.grid_status WHERE status=$2) WHERE id=$1"
 
-	_, err := db.Exec(sql, id, status)
+	err := GridStates.transition(id, status, sql, change)
 	if err != nil {
 		fmt.Println("Error inserting into database: ", err)
 		return err
@@ -18,6 +22,60 @@ func UpdateGridStatus(id string, status string) error {
 	return nil
 }
 
+// GridStatusHistory returns the status changes of the grid as json, oldest first.
+func GridStatusHistory(id string) ([]byte, error) {
+	entries, err := GridStates.history(id)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(entries)
+}
+
+// UpdateGridExpires sets the time the grid is due to be torn down.
+func UpdateGridExpires(id string, expires time.Time) error {
+	sqlString := "UPDATE portal.grid SET expires = $2 WHERE id=$1"
+
+	_, err := db.Exec(sqlString, id, expires.UTC())
+	if err != nil {
+		err = fmt.Errorf("unable to update grid expiry: %v", err)
+		return err
+	}
+	return nil
+}
+
+// GetExpiredGrids returns the grids that are still up after their expiry time.
+func GetExpiredGrids() ([]GridStruct, error) {
+	sqlString := `SELECT g.id, g.name, gs.status, g.ttl, p.name, r.region, vm.name, vs.name, nodes FROM portal.grid g 
+	 INNER JOIN portal.providers p ON g.provider_id = p.id 
+	 INNER JOIN portal.provider_regions r ON g.region_id = r.id 
+	 INNER JOIN portal.region_vm_sizes vm on g.master_instance_type_id = vm.id
+	 INNER JOIN portal.region_vm_sizes vs on g.slave_instance_type_id = vs.id
+	 INNER JOIN portal.grid_status gs on gs.id = g.status_id
+	 WHERE gs.status IN ('Deploying', 'Available', 'Deployed')
+	 AND g.expires IS NOT NULL AND g.expires < $1
+	 ORDER BY g.created DESC`
+
+	rows, err := db.Query(sqlString, time.Now().UTC())
+	if err != nil {
+		err = fmt.Errorf("failed to query expired grids: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var grids []GridStruct
+
+	for rows.Next() {
+		var grid GridStruct
+		if err := rows.Scan(&grid.ID, &grid.Name, &grid.Status, &grid.TTL, &grid.Provider, &grid.Region, &grid.Master, &grid.Slave, &grid.Nodes); err != nil {
+			err = fmt.Errorf("failed to scan expired grid: %v", err)
+			return grids, err
+		}
+		grids = append(grids, grid)
+	}
+
+	return grids, nil
+}
+
 func GetGridProviders() ([]byte, error) {
 
 	type jsonStruct struct {
@@ -151,7 +209,8 @@ func GetAllInstanceTypes() ([]byte, error) {
 	return b, nil
 }
 
-func CreateGrid(name string, provider string, region string, masterInstance string, slaveInstance string, slaveNumber int, ttl int, user string) {
+// CreateGrid stores a new grid in status Ready and returns its id.
+func CreateGrid(name string, provider string, region string, masterInstance string, slaveInstance string, slaveNumber int, ttl int, user string) (string, error) {
 
 	sql := `INSERT INTO portal.grid (name, status_id, health_id, created_by_user, last_edited_user, ttl,
 		    provider_id, region_id, master_instance_type_id, slave_instance_type_id, nodes) 
@@ -168,12 +227,15 @@ func CreateGrid(name string, provider string, region string, masterInstance stri
 			 (select v.id FROM portal.providers p  INNER JOIN portal.provider_regions r ON p.id=r.provider 
 				INNER JOIN portal.region_vm_sizes v ON r.id=v.provider_region WHERE p.name=$4 AND r.region=$5 AND v.name=$7),
 			 $8
-			)`
+			) RETURNING id`
 
-	_, err := db.Exec(sql, name, user, ttl, provider, region, masterInstance, slaveInstance, slaveNumber)
+	var id string
+	err := db.QueryRow(sql, name, user, ttl, provider, region, masterInstance, slaveInstance, slaveNumber).Scan(&id)
 	if err != nil {
 		fmt.Println("Error inserting into database for db.CreateGrid: ", err)
+		return "", err
 	}
+	return id, nil
 }
 
 // GetGridsByStatus returns a list of grids based on status
@@ -321,11 +383,10 @@ func GetGridRegion(id string) (string, error) {
 // are on it then change TTL tag so it gets deleted. Mark as deleted in DB so it doesn't show up
 // anymore in the UI.
 //
-// current possible grid status: Ready, Error, Deploying, Deployed, Destroyed, Deleted
-func DeleteGridByID(id string) error {
+// The states a grid can be in are those of GridStates.
+func DeleteGridByID(id string, change StatusChange) error {
 
-	sqlString := "UPDATE portal.grid SET status_id = (SELECT id from portal.grid_status WHERE status='Deleted') WHERE id = $1"
-	_, err := db.Exec(sqlString, id)
+	err := UpdateGridStatus(id, "Deleted", change)
 	if err != nil {
 		fmt.Println("Error inserting Deleted grid status into database: ", err)
 		return err
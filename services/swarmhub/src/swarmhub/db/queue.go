@@ -0,0 +1,174 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// QueuedTest is a test waiting in portal.grid_queue for its grid to be available.
+type QueuedTest struct {
+	TestID             string
+	Name               string
+	GridID             string
+	StartAutomatically bool
+	QueuedBy           string
+	Queued             string
+}
+
+// QueueTest adds the test to the end of the queue of the grid and returns its
+// position in the queue, starting at 1.
+func QueueTest(gridID string, testID string, startAutomatically bool, user string) (int, error) {
+	sqlString := `INSERT INTO portal.grid_queue (grid_id, test_id, start_automatically, queued_by) VALUES ($1, $2, $3, $4)`
+	_, err := db.Exec(sqlString, gridID, testID, startAutomatically, user)
+	if err != nil {
+		err = fmt.Errorf("unable to queue test %v on grid %v: %v", testID, gridID, err)
+		return 0, err
+	}
+
+	var position int
+	err = db.QueryRow(`SELECT count(*) FROM portal.grid_queue WHERE grid_id = $1`, gridID).Scan(&position)
+	if err != nil {
+		err = fmt.Errorf("unable to get the queue position of test %v: %v", testID, err)
+		return 0, err
+	}
+	return position, nil
+}
+
+// NextQueuedTest takes the test that has been waiting the longest off the queue of
+// the grid and claims the grid for it by setting it as the test of the grid. It
+// only does so when the grid is Available and has no test, which is checked with
+// the grid row locked in the same transaction, so of two callers racing for the
+// grid only one gets a test. The tests that are no longer Queued, e.g. because
+// they were deleted while waiting, are dropped. ok is false when there is nothing
+// to deploy.
+func NextQueuedTest(gridID string) (next QueuedTest, ok bool, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		err = fmt.Errorf("unable to start transaction: %v", err)
+		return next, false, err
+	}
+	defer tx.Rollback()
+
+	var status string
+	var testID sql.NullString
+	err = tx.QueryRow(`SELECT s.status, g.test_id FROM portal.grid g
+		INNER JOIN portal.grid_status s ON g.status_id = s.id
+		WHERE g.id = $1 FOR UPDATE`, gridID).Scan(&status, &testID)
+	if err != nil {
+		err = fmt.Errorf("unable to get the status of grid %v: %v", gridID, err)
+		return next, false, err
+	}
+	if status != "Available" || testID.Valid {
+		return next, false, nil
+	}
+
+	_, err = tx.Exec(`DELETE FROM portal.grid_queue WHERE grid_id = $1 AND test_id NOT IN (
+		SELECT t.id FROM portal.test t WHERE t.status_id = (SELECT id FROM portal.test_status WHERE status='Queued')
+	)`, gridID)
+	if err != nil {
+		err = fmt.Errorf("unable to drop tests no longer queued on grid %v: %v", gridID, err)
+		return next, false, err
+	}
+
+	var queued time.Time
+	var queuedBy sql.NullString
+	err = tx.QueryRow(`DELETE FROM portal.grid_queue WHERE id = (
+		SELECT id FROM portal.grid_queue WHERE grid_id = $1 ORDER BY queued LIMIT 1
+	) RETURNING test_id, start_automatically, queued_by, queued`, gridID).Scan(&next.TestID, &next.StartAutomatically, &queuedBy, &queued)
+	if err == sql.ErrNoRows {
+		return next, false, tx.Commit()
+	} else if err != nil {
+		err = fmt.Errorf("unable to take the next test off the queue of grid %v: %v", gridID, err)
+		return next, false, err
+	}
+	next.GridID = gridID
+	next.QueuedBy = queuedBy.String
+	next.Queued = queued.UTC().Format(time.RFC3339)
+
+	_, err = tx.Exec(`UPDATE portal.grid SET test_id = $2 WHERE id = $1`, gridID, next.TestID)
+	if err != nil {
+		err = fmt.Errorf("unable to claim grid %v for test %v: %v", gridID, next.TestID, err)
+		return next, false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		err = fmt.Errorf("unable to commit the queue of grid %v: %v", gridID, err)
+		return next, false, err
+	}
+	return next, true, nil
+}
+
+// RemoveQueuedTest takes the test off the queue it is waiting in and returns the
+// grid of the queue, or an empty string when the test wasn't queued.
+func RemoveQueuedTest(testID string) (string, error) {
+	var gridID string
+	err := db.QueryRow(`DELETE FROM portal.grid_queue WHERE test_id = $1 RETURNING grid_id`, testID).Scan(&gridID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		err = fmt.Errorf("unable to remove test %v from its queue: %v", testID, err)
+		return "", err
+	}
+	return gridID, nil
+}
+
+// ClearGridQueue empties the queue of the grid and returns the tests that were in it.
+func ClearGridQueue(gridID string) ([]string, error) {
+	var testIDs []string
+	rows, err := db.Query(`DELETE FROM portal.grid_queue WHERE grid_id = $1 RETURNING test_id`, gridID)
+	if err != nil {
+		err = fmt.Errorf("unable to clear the queue of grid %v: %v", gridID, err)
+		return testIDs, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var testID string
+		if err := rows.Scan(&testID); err != nil {
+			err = fmt.Errorf("unable to read the queue of grid %v: %v", gridID, err)
+			return testIDs, err
+		}
+		testIDs = append(testIDs, testID)
+	}
+	return testIDs, rows.Err()
+}
+
+// GridQueue returns the tests waiting for the grid as json, in the order they are deployed.
+func GridQueue(gridID string) ([]byte, error) {
+	var b []byte
+
+	rows, err := db.Query(`SELECT q.test_id, t.name, q.start_automatically, q.queued_by, q.queued
+		FROM portal.grid_queue q
+		INNER JOIN portal.test t ON t.id = q.test_id
+		WHERE q.grid_id = $1
+		ORDER BY q.queued`, gridID)
+	if err != nil {
+		err = fmt.Errorf("unable to get the queue of grid %v: %v", gridID, err)
+		return b, err
+	}
+	defer rows.Close()
+
+	queue := []QueuedTest{}
+	for rows.Next() {
+		var test QueuedTest
+		var queuedBy sql.NullString
+		var queued time.Time
+		if err := rows.Scan(&test.TestID, &test.Name, &test.StartAutomatically, &queuedBy, &queued); err != nil {
+			err = fmt.Errorf("unable to read the queue of grid %v: %v", gridID, err)
+			return b, err
+		}
+		test.GridID = gridID
+		test.QueuedBy = queuedBy.String
+		test.Queued = queued.UTC().Format(time.RFC3339)
+		queue = append(queue, test)
+	}
+	if err := rows.Err(); err != nil {
+		err = fmt.Errorf("unable to read the queue of grid %v: %v", gridID, err)
+		return b, err
+	}
+
+	return json.Marshal(queue)
+}
@@ -0,0 +1,323 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Schedule runs a copy of a test on a grid built from a grid template whenever its
+// cron expression fires. NextRun is empty when it is disabled.
+type Schedule struct {
+	ID             string
+	Name           string
+	Cron           string
+	TestID         string
+	GridTemplateID string
+	// MaxDuration is set on the copies of the test, e.g. 1h. Without it a run
+	// lasts until the test is stopped or the grid's TTL runs out.
+	MaxDuration string
+	Enabled     bool
+	NextRun     string
+	CreatedBy   string
+	Created     string
+}
+
+// ScheduleRun is a single run of a schedule, its status is one of Starting,
+// Provisioning, Running, Finished and Failed.
+type ScheduleRun struct {
+	ID         string
+	ScheduleID string
+	TestID     string
+	GridID     string
+	Status     string
+	Reason     string
+	StartedBy  string
+	Started    string
+	Finished   string
+}
+
+const scheduleColumns = `s.id, s.name, s.cron, s.test_id, s.grid_template_id, s.max_duration, s.enabled, s.next_run, s.created_by_user, s.created`
+
+func scanSchedule(row interface{ Scan(...interface{}) error }) (Schedule, error) {
+	var s Schedule
+	var maxDuration sql.NullInt64
+	var nextRun, created pq.NullTime
+	err := row.Scan(&s.ID, &s.Name, &s.Cron, &s.TestID, &s.GridTemplateID, &maxDuration, &s.Enabled, &nextRun, &s.CreatedBy, &created)
+	if err != nil {
+		return s, err
+	}
+
+	if maxDuration.Valid {
+		s.MaxDuration = (time.Duration(maxDuration.Int64) * time.Second).String()
+	}
+	if nextRun.Valid {
+		s.NextRun = nextRun.Time.Format(time.RFC3339)
+	}
+	if created.Valid {
+		s.Created = created.Time.Format(time.RFC3339)
+	}
+	return s, nil
+}
+
+// maxDurationSeconds is how max_duration columns store a duration, NULL for none.
+func maxDurationSeconds(duration string) (interface{}, error) {
+	if duration == "" {
+		return nil, nil
+	}
+	d, err := time.ParseDuration(duration)
+	if err != nil {
+		return nil, err
+	}
+	return int64(d / time.Second), nil
+}
+
+// nullTime is NULL for the zero time.
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC()
+}
+
+// CreateSchedule stores the schedule, nextRun is when it runs first.
+func CreateSchedule(s Schedule, nextRun time.Time, user string) (string, error) {
+	maxDuration, err := maxDurationSeconds(s.MaxDuration)
+	if err != nil {
+		err = fmt.Errorf("invalid maximum duration: %v", err)
+		return "", err
+	}
+
+	var id string
+	sqlString := `INSERT INTO portal.schedule (name, cron, test_id, grid_template_id, max_duration, enabled, next_run, created_by_user, last_edited_user)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8) RETURNING id`
+	err = db.QueryRow(sqlString, s.Name, s.Cron, s.TestID, s.GridTemplateID, maxDuration, s.Enabled, nullTime(nextRun), user).Scan(&id)
+	if err != nil {
+		err = fmt.Errorf("unable to create schedule: %v", err)
+		return "", err
+	}
+	return id, nil
+}
+
+// UpdateSchedule replaces the settings of the schedule, nextRun is when it runs next.
+func UpdateSchedule(id string, s Schedule, nextRun time.Time, user string) error {
+	maxDuration, err := maxDurationSeconds(s.MaxDuration)
+	if err != nil {
+		err = fmt.Errorf("invalid maximum duration: %v", err)
+		return err
+	}
+
+	sqlString := `UPDATE portal.schedule SET (name, cron, test_id, grid_template_id, max_duration, enabled, next_run, last_edited_user, last_edited_time)
+		= ($2, $3, $4, $5, $6, $7, $8, $9, current_timestamp()) WHERE id = $1`
+	result, err := db.Exec(sqlString, id, s.Name, s.Cron, s.TestID, s.GridTemplateID, maxDuration, s.Enabled, nullTime(nextRun), user)
+	if err != nil {
+		err = fmt.Errorf("unable to update schedule %v: %v", id, err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("schedule %v does not exist", id)
+	}
+	return nil
+}
+
+// DeleteSchedule removes the schedule. Its runs are kept without it so the ones
+// that are still going are torn down when they finish.
+func DeleteSchedule(id string) error {
+	_, err := db.Exec("DELETE FROM portal.schedule WHERE id = $1", id)
+	if err != nil {
+		err = fmt.Errorf("unable to delete schedule %v: %v", id, err)
+		return err
+	}
+	return nil
+}
+
+// GetSchedules returns all schedules, the ones running next first.
+func GetSchedules() ([]Schedule, error) {
+	rows, err := db.Query(`SELECT ` + scheduleColumns + ` FROM portal.schedule s ORDER BY s.next_run NULLS LAST, s.name`)
+	if err != nil {
+		err = fmt.Errorf("unable to query schedules: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []Schedule{}
+	for rows.Next() {
+		s, err := scanSchedule(rows)
+		if err != nil {
+			err = fmt.Errorf("unable to scan schedule: %v", err)
+			return schedules, err
+		}
+		schedules = append(schedules, s)
+	}
+	return schedules, nil
+}
+
+// GetScheduleByID returns the schedule, sql.ErrNoRows when there is none.
+func GetScheduleByID(id string) (Schedule, error) {
+	s, err := scanSchedule(db.QueryRow(`SELECT `+scheduleColumns+` FROM portal.schedule s WHERE s.id = $1`, id))
+	if err == sql.ErrNoRows {
+		return s, err
+	} else if err != nil {
+		err = fmt.Errorf("unable to get schedule %v: %v", id, err)
+		return s, err
+	}
+	return s, nil
+}
+
+// DueSchedules returns the enabled schedules whose next run is not after now.
+func DueSchedules(now time.Time) ([]Schedule, error) {
+	rows, err := db.Query(`SELECT `+scheduleColumns+` FROM portal.schedule s
+		WHERE s.enabled AND s.next_run IS NOT NULL AND s.next_run <= $1
+		ORDER BY s.next_run`, now.UTC())
+	if err != nil {
+		err = fmt.Errorf("unable to query due schedules: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schedules []Schedule
+	for rows.Next() {
+		s, err := scanSchedule(rows)
+		if err != nil {
+			err = fmt.Errorf("unable to scan schedule: %v", err)
+			return schedules, err
+		}
+		schedules = append(schedules, s)
+	}
+	return schedules, nil
+}
+
+// ClaimScheduleRun moves the next run of the schedule from due to next. It reports
+// false when another swarmhub got there first, so every run is only started once.
+func ClaimScheduleRun(id string, due string, next time.Time) (bool, error) {
+	result, err := db.Exec(`UPDATE portal.schedule SET next_run = $3 WHERE id = $1 AND next_run = $2`, id, due, nullTime(next))
+	if err != nil {
+		err = fmt.Errorf("unable to claim the run of schedule %v: %v", id, err)
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		err = fmt.Errorf("unable to claim the run of schedule %v: %v", id, err)
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// CreateScheduleRun records that a run of the schedule is starting.
+func CreateScheduleRun(scheduleID string, user string) (string, error) {
+	var id string
+	err := db.QueryRow(`INSERT INTO portal.schedule_run (schedule_id, status, started_by) VALUES ($1, 'Starting', $2) RETURNING id`, scheduleID, user).Scan(&id)
+	if err != nil {
+		err = fmt.Errorf("unable to record the run of schedule %v: %v", scheduleID, err)
+		return "", err
+	}
+	return id, nil
+}
+
+// UpdateScheduleRunTest records the copy of the test and the grid of the run.
+func UpdateScheduleRunTest(id string, testID string, gridID string) error {
+	_, err := db.Exec(`UPDATE portal.schedule_run SET (test_id, grid_id) = ($2, $3) WHERE id = $1`, id, testID, nullString(gridID))
+	if err != nil {
+		err = fmt.Errorf("unable to update schedule run %v: %v", id, err)
+		return err
+	}
+	return nil
+}
+
+// UpdateScheduleRunStatus sets the status of a run that hasn't finished yet.
+func UpdateScheduleRunStatus(id string, status string, reason string) error {
+	_, err := db.Exec(`UPDATE portal.schedule_run SET (status, reason) = ($2, $3) WHERE id = $1 AND finished IS NULL`, id, status, reason)
+	if err != nil {
+		err = fmt.Errorf("unable to update schedule run %v: %v", id, err)
+		return err
+	}
+	return nil
+}
+
+// FinishScheduleRun ends the run with status Finished or Failed. It reports false
+// when the run had already ended.
+func FinishScheduleRun(id string, status string, reason string) (bool, error) {
+	result, err := db.Exec(`UPDATE portal.schedule_run SET (status, reason, finished) = ($2, $3, current_timestamp())
+		WHERE id = $1 AND finished IS NULL`, id, status, reason)
+	if err != nil {
+		err = fmt.Errorf("unable to finish schedule run %v: %v", id, err)
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		err = fmt.Errorf("unable to finish schedule run %v: %v", id, err)
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// ActiveScheduleRun returns the unfinished run that uses the test or grid id, ok
+// is false when there is none.
+func ActiveScheduleRun(id string) (run ScheduleRun, ok bool, err error) {
+	row := db.QueryRow(`SELECT r.id, r.schedule_id, r.test_id, r.grid_id, r.status, r.reason, r.started_by, r.started, r.finished
+		FROM portal.schedule_run r WHERE r.finished IS NULL AND (r.test_id = $1 OR r.grid_id = $1)
+		ORDER BY r.started DESC LIMIT 1`, id)
+	run, err = scanScheduleRun(row)
+	if err == sql.ErrNoRows {
+		return run, false, nil
+	} else if err != nil {
+		err = fmt.Errorf("unable to get the schedule run of %v: %v", id, err)
+		return run, false, err
+	}
+	return run, true, nil
+}
+
+// ScheduleRuns returns the last runs of the schedule as json, newest first.
+func ScheduleRuns(scheduleID string, limit int) ([]byte, error) {
+	rows, err := db.Query(`SELECT r.id, r.schedule_id, r.test_id, r.grid_id, r.status, r.reason, r.started_by, r.started, r.finished
+		FROM portal.schedule_run r WHERE r.schedule_id = $1
+		ORDER BY r.started DESC LIMIT $2`, scheduleID, limit)
+	if err != nil {
+		err = fmt.Errorf("unable to query the runs of schedule %v: %v", scheduleID, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []ScheduleRun{}
+	for rows.Next() {
+		run, err := scanScheduleRun(rows)
+		if err != nil {
+			err = fmt.Errorf("unable to scan schedule run: %v", err)
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return json.Marshal(runs)
+}
+
+func scanScheduleRun(row interface{ Scan(...interface{}) error }) (ScheduleRun, error) {
+	var run ScheduleRun
+	var scheduleID, testID, gridID sql.NullString
+	var started, finished pq.NullTime
+	err := row.Scan(&run.ID, &scheduleID, &testID, &gridID, &run.Status, &run.Reason, &run.StartedBy, &started, &finished)
+	if err != nil {
+		return run, err
+	}
+
+	run.ScheduleID = scheduleID.String
+	run.TestID = testID.String
+	run.GridID = gridID.String
+	if started.Valid {
+		run.Started = started.Time.Format(time.RFC3339)
+	}
+	if finished.Valid {
+		run.Finished = finished.Time.Format(time.RFC3339)
+	}
+	return run, nil
+}
+
+// nullString is NULL for the empty string.
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,207 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// StateMachine lists the statuses a test or grid can move to from each status. The
+// statuses are the rows of portal.<kind>_status.
+type StateMachine struct {
+	// Kind is test or grid, the table the state machine is for.
+	Kind        string
+	transitions map[string][]string
+}
+
+// TestStates are the status changes a test may go through. A test can be deleted
+// from any status, deleted is final.
+var TestStates = StateMachine{Kind: "test", transitions: map[string][]string{
+	"Creating":      {"Uploading", "Ready", "Missing info", "Upload Failed", "Error", "Deleted"},
+	"Uploading":     {"Ready", "Upload Failed", "Error", "Deleted"},
+	"Upload Failed": {"Uploading", "Deleted"},
+	"Missing info":  {"Uploading", "Ready", "Deleted"},
+	"Ready":         {"Uploading", "Missing info", "Queued", "Deploying", "Deleted"},
+	"Queued":        {"Deploying", "Ready", "Error", "Expired", "Deleted"},
+	"Deploying":     {"Deployed", "Ready", "Error", "Stopping", "Stopped", "Expired", "Deleted"},
+	"Deployed":      {"Launching", "Launched", "Running", "Ready", "Stopping", "Stopped", "Error", "Expired", "Deleted"},
+	"Launching":     {"Launched", "Running", "Stopping", "Stopped", "Error", "Expired", "Deleted"},
+	"Launched":      {"Running", "Stopping", "Stopped", "Error", "Expired", "Deleted"},
+	"Running":       {"Stopping", "Stopped", "Error", "Expired", "Deleted"},
+	"Stopping":      {"Stopped", "Error", "Expired", "Deleted"},
+	"Stopped":       {"Uploading", "Deleted"},
+	"Expired":       {"Uploading", "Deleted"},
+	"Error":         {"Uploading", "Ready", "Queued", "Deploying", "Stopping", "Stopped", "Expired", "Deleted"},
+	"Deleted":       {},
+}}
+
+// GridStates are the status changes a grid may go through. Deleted is final.
+var GridStates = StateMachine{Kind: "grid", transitions: map[string][]string{
+	"Ready":     {"Deploying", "Error", "Deleting", "Deleted"},
+	"Deploying": {"Available", "Error", "Expired", "Deleting", "Deleted"},
+	"Available": {"Deployed", "Cleaning", "Stopping", "Error", "Expired", "Deleting", "Deleted"},
+	"Deployed":  {"Available", "Cleaning", "Stopping", "Error", "Expired", "Deleting", "Deleted"},
+	"Cleaning":  {"Available", "Error", "Expired", "Deleting", "Deleted"},
+	"Stopping":  {"Stopped", "Error", "Expired", "Deleting", "Deleted"},
+	"Stopped":   {"Expired", "Deleting", "Deleted"},
+	"Error":     {"Available", "Cleaning", "Destroyed", "Expired", "Deleting", "Deleted"},
+	"Expired":   {"Deleting", "Deleted"},
+	"Deleting":  {"Deleted", "Expired", "Error"},
+	"Destroyed": {"Deleted"},
+	"Deleted":   {},
+}}
+
+// Valid reports if status is one of the statuses of the state machine.
+func (m StateMachine) Valid(status string) bool {
+	_, ok := m.transitions[status]
+	return ok
+}
+
+// CanTransition reports if a test or grid in status from may be set to status to.
+// Setting the status it already has is always allowed.
+func (m StateMachine) CanTransition(from string, to string) bool {
+	if !m.Valid(to) {
+		return false
+	}
+	if from == to {
+		return true
+	}
+	for _, status := range m.transitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// TransitionError is returned when a status change is not allowed.
+type TransitionError struct {
+	Kind string
+	ID   string
+	From string
+	To   string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("%v %v can't move from status %q to %q", e.Kind, e.ID, e.From, e.To)
+}
+
+// StatusChange is who changed a status and why, it is kept in the status history.
+type StatusChange struct {
+	// Actor is the user of an API request, or the service that published the
+	// status, e.g. swarmhub, ttl-enforcer or deployer/<host>.
+	Actor  string
+	Reason string
+}
+
+// StatusHistoryEntry is a status change of a test or grid. Duration is how long the
+// test or grid stayed in the status To, it is empty for the current status.
+type StatusHistoryEntry struct {
+	From     string
+	To       string
+	Actor    string
+	Reason   string
+	Changed  string
+	Duration string `json:",omitempty"`
+}
+
+// transition changes the status of the test or grid id within a transaction and
+// records it in portal.<kind>_status_history. update is the statement setting the
+// status, with the id as $1 and the status as $2. Setting the status the row
+// already has changes nothing.
+func (m StateMachine) transition(id string, status string, update string, change StatusChange) error {
+	tx, err := db.Begin()
+	if err != nil {
+		err = fmt.Errorf("unable to start transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	var current sql.NullString
+	query := fmt.Sprintf(`SELECT s.status FROM portal.%v t LEFT JOIN portal.%v_status s ON t.status_id = s.id WHERE t.id = $1`, m.Kind, m.Kind)
+	err = tx.QueryRow(query, id).Scan(&current)
+	if err == sql.ErrNoRows {
+		err = fmt.Errorf("%v %v does not exist", m.Kind, id)
+		return err
+	} else if err != nil {
+		err = fmt.Errorf("unable to get the status of %v %v: %v", m.Kind, id, err)
+		return err
+	}
+
+	if current.String == status {
+		return nil
+	}
+
+	if !m.Valid(status) {
+		err = fmt.Errorf("%q is not a %v status", status, m.Kind)
+		fmt.Println("Rejected status change:", err)
+		return err
+	}
+	// a row without a known status can be set to any status, so it can be repaired
+	if current.Valid && m.Valid(current.String) && !m.CanTransition(current.String, status) {
+		err = &TransitionError{Kind: m.Kind, ID: id, From: current.String, To: status}
+		fmt.Println("Rejected status change:", err)
+		return err
+	}
+
+	_, err = tx.Exec(update, id, status)
+	if err != nil {
+		err = fmt.Errorf("unable to update the status of %v %v: %v", m.Kind, id, err)
+		return err
+	}
+
+	history := fmt.Sprintf(`INSERT INTO portal.%v_status_history (%v_id, from_status, to_status, actor, reason) VALUES ($1, $2, $3, $4, $5)`, m.Kind, m.Kind)
+	_, err = tx.Exec(history, id, current, status, change.Actor, change.Reason)
+	if err != nil {
+		err = fmt.Errorf("unable to record the status change of %v %v: %v", m.Kind, id, err)
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		err = fmt.Errorf("unable to commit the status change of %v %v: %v", m.Kind, id, err)
+		return err
+	}
+	return nil
+}
+
+// history returns the status changes of the test or grid id, oldest first.
+func (m StateMachine) history(id string) ([]StatusHistoryEntry, error) {
+	entries := []StatusHistoryEntry{}
+
+	query := fmt.Sprintf(`SELECT from_status, to_status, actor, reason, changed FROM portal.%v_status_history
+		WHERE %v_id = $1
+		ORDER BY changed`, m.Kind, m.Kind)
+	rows, err := db.Query(query, id)
+	if err != nil {
+		err = fmt.Errorf("unable to get the status history of %v %v: %v", m.Kind, id, err)
+		return entries, err
+	}
+	defer rows.Close()
+
+	var changes []time.Time
+	for rows.Next() {
+		var from, actor, reason sql.NullString
+		var entry StatusHistoryEntry
+		var changed time.Time
+		if err := rows.Scan(&from, &entry.To, &actor, &reason, &changed); err != nil {
+			err = fmt.Errorf("unable to read the status history of %v %v: %v", m.Kind, id, err)
+			return entries, err
+		}
+		entry.From = from.String
+		entry.Actor = actor.String
+		entry.Reason = reason.String
+		entry.Changed = changed.UTC().Format(time.RFC3339)
+		entries = append(entries, entry)
+		changes = append(changes, changed)
+	}
+	if err := rows.Err(); err != nil {
+		err = fmt.Errorf("unable to read the status history of %v %v: %v", m.Kind, id, err)
+		return entries, err
+	}
+
+	for i := 0; i < len(entries)-1; i++ {
+		entries[i].Duration = changes[i+1].Sub(changes[i]).String()
+	}
+	return entries, nil
+}
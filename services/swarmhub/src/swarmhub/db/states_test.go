@@ -0,0 +1,77 @@
+package db
+
+import "testing"
+
+func TestTestStates(t *testing.T) {
+	tests := []struct {
+		from string
+		to   string
+		want bool
+	}{
+		{"Ready", "Queued", true},
+		{"Queued", "Deploying", true},
+		{"Deploying", "Deployed", true},
+		{"Deployed", "Running", true},
+		{"Running", "Stopping", true},
+		{"Stopping", "Stopped", true},
+		{"Running", "Expired", true},
+		{"Error", "Ready", true},
+		{"Stopped", "Running", false},
+		{"Expired", "Deployed", false},
+		{"Ready", "Running", false},
+		{"Deleted", "Ready", false},
+		{"Running", "Running", true},
+		{"Ready", "Unknown", false},
+		{"Unknown", "Ready", false},
+	}
+	for _, test := range tests {
+		got := TestStates.CanTransition(test.from, test.to)
+		if got != test.want {
+			t.Errorf("test %v -> %v allowed is %v, want %v", test.from, test.to, got, test.want)
+		}
+	}
+}
+
+func TestGridStates(t *testing.T) {
+	tests := []struct {
+		from string
+		to   string
+		want bool
+	}{
+		{"Ready", "Deploying", true},
+		{"Deploying", "Available", true},
+		{"Available", "Deployed", true},
+		{"Deployed", "Cleaning", true},
+		{"Cleaning", "Available", true},
+		{"Available", "Expired", true},
+		{"Expired", "Deleting", true},
+		{"Deleting", "Deleted", true},
+		{"Error", "Available", true},
+		{"Deleted", "Available", false},
+		{"Expired", "Available", false},
+		{"Ready", "Deployed", false},
+		{"Destroyed", "Available", false},
+		{"Available", "Available", true},
+		{"Available", "Unknown", false},
+	}
+	for _, test := range tests {
+		got := GridStates.CanTransition(test.from, test.to)
+		if got != test.want {
+			t.Errorf("grid %v -> %v allowed is %v, want %v", test.from, test.to, got, test.want)
+		}
+	}
+}
+
+// TestStatesAreKnown makes sure every status that can be moved to is a status of
+// the state machine, so no status is a dead end by accident.
+func TestStatesAreKnown(t *testing.T) {
+	for _, m := range []StateMachine{TestStates, GridStates} {
+		for from, tos := range m.transitions {
+			for _, to := range tos {
+				if !m.Valid(to) {
+					t.Errorf("%v status %v moves to unknown status %v", m.Kind, from, to)
+				}
+			}
+		}
+	}
+}
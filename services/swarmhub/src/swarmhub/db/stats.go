@@ -0,0 +1,109 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/common/locust"
+)
+
+// TestStats are the locust statistics of a run of a test, collected when it was
+// stopped.
+type TestStats struct {
+	TestID    string
+	Collected string
+	locust.Stats
+}
+
+// StoreTestStats keeps the statistics of the test in portal.test_stats, replacing
+// the ones of an earlier run.
+func StoreTestStats(testID string, stats locust.Stats) error {
+	tx, err := db.Begin()
+	if err != nil {
+		err = fmt.Errorf("unable to start transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM portal.test_stats WHERE test_id = $1", testID)
+	if err != nil {
+		err = fmt.Errorf("unable to remove the old statistics of test %v: %v", testID, err)
+		return err
+	}
+
+	insert := `INSERT INTO portal.test_stats (test_id, total, method, name, requests, failures, median_response_time,
+		avg_response_time, min_response_time, max_response_time, avg_content_length, rps, percentiles)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`
+
+	rows := append([]locust.RequestStats{stats.Total}, stats.Requests...)
+	for i, r := range rows {
+		percentiles, err := json.Marshal(r.Percentiles)
+		if err != nil {
+			err = fmt.Errorf("unable to convert the percentiles to json: %v", err)
+			return err
+		}
+
+		_, err = tx.Exec(insert, testID, i == 0, r.Method, r.Name, r.Requests, r.Failures, r.MedianResponseTime,
+			r.AvgResponseTime, r.MinResponseTime, r.MaxResponseTime, r.AvgContentLength, r.RPS, string(percentiles))
+		if err != nil {
+			err = fmt.Errorf("unable to store the statistics of test %v: %v", testID, err)
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		err = fmt.Errorf("unable to commit the statistics of test %v: %v", testID, err)
+		return err
+	}
+	return nil
+}
+
+// GetTestStats returns the statistics stored for the test, ok is false when none
+// were collected.
+func GetTestStats(testID string) (stats TestStats, ok bool, err error) {
+	query := `SELECT total, method, name, requests, failures, median_response_time, avg_response_time,
+		min_response_time, max_response_time, avg_content_length, rps, percentiles, collected
+		FROM portal.test_stats WHERE test_id = $1 ORDER BY total DESC, name, method`
+
+	rows, err := db.Query(query, testID)
+	if err != nil {
+		err = fmt.Errorf("unable to query the statistics of test %v: %v", testID, err)
+		return stats, false, err
+	}
+	defer rows.Close()
+
+	stats.TestID = testID
+	stats.Requests = []locust.RequestStats{}
+	for rows.Next() {
+		var r locust.RequestStats
+		var total bool
+		var percentiles sql.NullString
+		var collected time.Time
+		err := rows.Scan(&total, &r.Method, &r.Name, &r.Requests, &r.Failures, &r.MedianResponseTime, &r.AvgResponseTime,
+			&r.MinResponseTime, &r.MaxResponseTime, &r.AvgContentLength, &r.RPS, &percentiles, &collected)
+		if err != nil {
+			err = fmt.Errorf("unable to scan the statistics of test %v: %v", testID, err)
+			return stats, false, err
+		}
+
+		if percentiles.Valid {
+			err = json.Unmarshal([]byte(percentiles.String), &r.Percentiles)
+			if err != nil {
+				err = fmt.Errorf("unable to read the percentiles of test %v: %v", testID, err)
+				return stats, false, err
+			}
+		}
+
+		ok = true
+		stats.Collected = collected.Format(time.RFC3339)
+		if total {
+			stats.Total = r
+			continue
+		}
+		stats.Requests = append(stats.Requests, r)
+	}
+	return stats, ok, nil
+}
@@ -7,6 +7,10 @@ import (
 	"strings"
 	"time"
 
+	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/compare"
+	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/slo"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/common/operations"
 	"github.com/lib/pq"
 )
 
@@ -21,20 +25,21 @@ func nullStringToStringSlice(l []sql.NullString) []string {
 }
 
 // UpdateTestStatus should be used whenever updating a test status. It has special logic to add timestamps
-// depending on the status being set.
-func UpdateTestStatus(id string, status string) error {
+// depending on the status being set. Changes TestStates doesn't allow are rejected with a
+// *TransitionError, the others are recorded in portal.test_status_history with the change.
+func UpdateTestStatus(id string, status string, change StatusChange) error {
 
 	var sql string
 
 	if status == "Deployed" {
-		sql = "UPDATE portal.test SET (status_id, launched) = ((SELECT id from portal.test_status WHERE status=$2), current_timestamp()) WHERE id=$1"
+		sql = "UPDATE portal.test SET (status_id, launched, stop_reason) = ((SELECT id from portal.test_status WHERE status=$2), current_timestamp(), NULL) WHERE id=$1"
 	} else if status == "Stopped" || status == "Expired" {
 		sql = "UPDATE portal.test SET (status_id, stopped) = ((SELECT id from portal.test_status WHERE status=$2), current_timestamp())  WHERE id=$1"
 	} else {
 		sql = "UPDATE portal.test SET status_id = (SELECT id from portal.test_status WHERE status=$2) WHERE id=$1"
 	}
 
-	_, err := db.Exec(sql, id, status)
+	err := TestStates.transition(id, status, sql, change)
 	if err != nil {
 		fmt.Println("Error inserting into database: ", err)
 		return err
@@ -43,11 +48,20 @@ func UpdateTestStatus(id string, status string) error {
 	return nil
 }
 
+// TestStatusHistory returns the status changes of the test as json, oldest first.
+func TestStatusHistory(id string) ([]byte, error) {
+	entries, err := TestStates.history(id)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(entries)
+}
+
 func TestByID(id string) ([]byte, error) {
 	var b []byte
 	var err error
 
-	query := `SELECT t.id, t.name, s.status, array_agg(l.status), t.description, t.created, t.launched, t.stopped, t.grafana_snapshot_url 
+	query := `SELECT t.id, t.name, s.status, array_agg(l.status), t.description, t.created, t.launched, t.stopped, t.grafana_snapshot_url, t.max_duration, t.stop_reason 
 		FROM portal.test t 
 		INNER JOIN portal.test_status s 
 		ON t.status_id = s.id
@@ -56,7 +70,7 @@ func TestByID(id string) ([]byte, error) {
 	  LEFT JOIN portal.labels l
 		ON l.id = tl.label_id
 		WHERE t.id=$1
-	  GROUP BY t.id, t.name, s.status, t.description, t.created, t.launched, t.stopped, t.grafana_snapshot_url;`
+	  GROUP BY t.id, t.name, s.status, t.description, t.created, t.launched, t.stopped, t.grafana_snapshot_url, t.max_duration, t.stop_reason;`
 
 	rows, err := db.Query(query, id)
 	if err != nil {
@@ -71,7 +85,9 @@ func TestByID(id string) ([]byte, error) {
 		var id, name, status, desc, created, launched, stopped, snapshotURL string
 		var sqlCreated, sqlLaunched, sqlStopped pq.NullTime
 		var labels []sql.NullString
-		if err := rows.Scan(&id, &name, &status, pq.Array(&labels), &desc, &sqlCreated, &sqlLaunched, &sqlStopped, &snapshotURL); err != nil {
+		var sqlMaxDuration sql.NullInt64
+		var sqlStopReason sql.NullString
+		if err := rows.Scan(&id, &name, &status, pq.Array(&labels), &desc, &sqlCreated, &sqlLaunched, &sqlStopped, &snapshotURL, &sqlMaxDuration, &sqlStopReason); err != nil {
 			fmt.Println(err)
 			return b, err
 		}
@@ -95,7 +111,12 @@ func TestByID(id string) ([]byte, error) {
 		} else {
 			stopped = "-"
 		}
-		test = Test{id, name, desc, status, nullStringToStringSlice(labels), "Success", created, launched, stopped, snapshotURL}
+		var maxDuration string
+		if sqlMaxDuration.Valid {
+			maxDuration = (time.Duration(sqlMaxDuration.Int64) * time.Second).String()
+		}
+
+		test = Test{id, name, desc, status, nullStringToStringSlice(labels), "Success", created, launched, stopped, snapshotURL, maxDuration, sqlStopReason.String}
 	}
 
 	b, err = json.Marshal(test)
@@ -110,9 +131,8 @@ func TestByID(id string) ([]byte, error) {
 //
 // Possibly delete all the data associated to the test and remove from the DB?
 //
-// Possible states a test can be in: Ready, Creating, Uploading, Queued, Deploying, Deployed,
-// Launching, Launched, Running, Stopping, Stopped, Missing info, Upload Failed, Error
-func DeleteTestByID(id string) error {
+// The states a test can be in are those of TestStates.
+func DeleteTestByID(id string, change StatusChange) error {
 	var status string
 
 	sqlString := `SELECT ts.status FROM portal.test t 
@@ -131,8 +151,7 @@ func DeleteTestByID(id string) error {
 		fmt.Println("Need to add logic for Deployed/Deploying before deleting?")
 	}
 
-	sqlString = "UPDATE portal.test SET status_id = (SELECT id from portal.test_status WHERE status='Deleted') WHERE id = $1"
-	_, err = db.Exec(sqlString, id)
+	err = UpdateTestStatus(id, "Deleted", change)
 	if err != nil {
 		fmt.Println("Error inserting Deleted test status into database: ", err)
 		return err
@@ -372,7 +391,7 @@ func TestPaginate(testID string, startDate time.Time, endDate time.Time, search
 			stopped = "-"
 		}
 
-		tests = append(tests, Test{id, name, "-", status, nullStringToStringSlice(labels), result, created, launched, stopped, ""})
+		tests = append(tests, Test{id, name, "-", status, nullStringToStringSlice(labels), result, created, launched, stopped, "", "", ""})
 	}
 
 	b, err = json.Marshal(tests)
@@ -686,6 +705,219 @@ func CreateTestFiles(testFiles TestFiles, id string, user string) (string, error
 	return scriptID, err
 }
 
+// TestLoadProfile returns the load profile of the test, nil when it has none.
+func TestLoadProfile(id string) (*operations.LoadProfile, error) {
+	var raw sql.NullString
+	err := db.QueryRow("SELECT load_profile FROM portal.test WHERE id = $1", id).Scan(&raw)
+	if err != nil {
+		err = fmt.Errorf("unable to get the load profile of test %v: %v", id, err)
+		return nil, err
+	}
+	if !raw.Valid || raw.String == "" {
+		return nil, nil
+	}
+
+	var profile operations.LoadProfile
+	err = json.Unmarshal([]byte(raw.String), &profile)
+	if err != nil {
+		err = fmt.Errorf("unable to read the load profile of test %v: %v", id, err)
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// UpdateTestLoadProfile sets the load profile of the test, nil removes it.
+func UpdateTestLoadProfile(id string, profile *operations.LoadProfile, user string) error {
+	var raw interface{}
+	if profile != nil {
+		b, err := json.Marshal(profile)
+		if err != nil {
+			err = fmt.Errorf("unable to convert the load profile to json: %v", err)
+			return err
+		}
+		raw = string(b)
+	}
+
+	sqlString := "UPDATE portal.test SET (load_profile, last_edited_user, last_edited_time) = ($2, $3, current_timestamp()) WHERE id = $1"
+	_, err := db.Exec(sqlString, id, raw, user)
+	if err != nil {
+		err = fmt.Errorf("unable to update the load profile of test %v: %v", id, err)
+		return err
+	}
+	return nil
+}
+
+// UpdateTestMaxDuration sets how long the test may run once it is deployed before
+// it is stopped, 0 lets it run until it is stopped by hand.
+func UpdateTestMaxDuration(id string, maxDuration time.Duration, user string) error {
+	var seconds interface{}
+	if maxDuration > 0 {
+		seconds = int64(maxDuration / time.Second)
+	}
+
+	sqlString := "UPDATE portal.test SET (max_duration, last_edited_user, last_edited_time) = ($2, $3, current_timestamp()) WHERE id = $1"
+	_, err := db.Exec(sqlString, id, seconds, user)
+	if err != nil {
+		err = fmt.Errorf("unable to update the maximum duration of test %v: %v", id, err)
+		return err
+	}
+	return nil
+}
+
+// UpdateTestStopReason records why the test was stopped, it is cleared when the
+// test is deployed again.
+func UpdateTestStopReason(id string, reason string) error {
+	_, err := db.Exec("UPDATE portal.test SET stop_reason = $2 WHERE id = $1", id, reason)
+	if err != nil {
+		err = fmt.Errorf("unable to update the stop reason of test %v: %v", id, err)
+		return err
+	}
+	return nil
+}
+
+// TestThresholds returns the threshold rules of the test, nil when it has none.
+func TestThresholds(id string) ([]slo.Rule, error) {
+	var raw sql.NullString
+	err := db.QueryRow("SELECT thresholds FROM portal.test WHERE id = $1", id).Scan(&raw)
+	if err != nil {
+		err = fmt.Errorf("unable to get the thresholds of test %v: %v", id, err)
+		return nil, err
+	}
+	if !raw.Valid || raw.String == "" {
+		return nil, nil
+	}
+
+	var rules []slo.Rule
+	err = json.Unmarshal([]byte(raw.String), &rules)
+	if err != nil {
+		err = fmt.Errorf("unable to read the thresholds of test %v: %v", id, err)
+		return nil, err
+	}
+	return rules, nil
+}
+
+// UpdateTestThresholds sets the threshold rules of the test, none removes them.
+func UpdateTestThresholds(id string, rules []slo.Rule, user string) error {
+	var raw interface{}
+	if len(rules) > 0 {
+		b, err := json.Marshal(rules)
+		if err != nil {
+			err = fmt.Errorf("unable to convert the thresholds to json: %v", err)
+			return err
+		}
+		raw = string(b)
+	}
+
+	sqlString := "UPDATE portal.test SET (thresholds, last_edited_user, last_edited_time) = ($2, $3, current_timestamp()) WHERE id = $1"
+	_, err := db.Exec(sqlString, id, raw, user)
+	if err != nil {
+		err = fmt.Errorf("unable to update the thresholds of test %v: %v", id, err)
+		return err
+	}
+	return nil
+}
+
+// DetailedResult is what is kept as the detailed result of a test when it stops,
+// the evaluation of its thresholds and its comparison to the baseline of its
+// tests. Either is missing when the test has no thresholds or no baseline.
+type DetailedResult struct {
+	*slo.Evaluation
+	Baseline *compare.BaselineComparison `json:",omitempty"`
+}
+
+// UpdateTestDetailedResult stores the detailed result of the test and sets its
+// result from the evaluation, unless there is none.
+func UpdateTestDetailedResult(id string, result DetailedResult) error {
+	b, err := json.Marshal(result)
+	if err != nil {
+		err = fmt.Errorf("unable to convert the detailed result to json: %v", err)
+		return err
+	}
+
+	if result.Evaluation == nil {
+		_, err = db.Exec("UPDATE portal.test SET detailed_result = $2 WHERE id = $1", id, string(b))
+	} else {
+		sqlString := "UPDATE portal.test SET (result_id, detailed_result) = ((SELECT id from portal.test_results WHERE result=$2), $3) WHERE id = $1"
+		_, err = db.Exec(sqlString, id, result.Result, string(b))
+	}
+	if err != nil {
+		err = fmt.Errorf("unable to update the result of test %v: %v", id, err)
+		return err
+	}
+	return nil
+}
+
+// TestEvaluation returns the detailed result of the test, null when it has none.
+func TestEvaluation(id string) ([]byte, error) {
+	var raw sql.NullString
+	err := db.QueryRow("SELECT detailed_result FROM portal.test WHERE id = $1", id).Scan(&raw)
+	if err != nil {
+		err = fmt.Errorf("unable to get the detailed result of test %v: %v", id, err)
+		return nil, err
+	}
+	if !raw.Valid || raw.String == "" {
+		return []byte("null"), nil
+	}
+	return []byte(raw.String), nil
+}
+
+// OverdueTest is a test that has been running longer than its maximum duration.
+type OverdueTest struct {
+	ID          string
+	GridID      string
+	Launched    time.Time
+	MaxDuration time.Duration
+}
+
+// GetOverdueTests returns the deployed tests that were launched longer than their
+// maximum duration ago.
+func GetOverdueTests() ([]OverdueTest, error) {
+	sqlString := `SELECT t.id, g.id, t.launched, t.max_duration FROM portal.test t
+	 INNER JOIN portal.test_status s ON t.status_id = s.id
+	 INNER JOIN portal.grid g ON g.test_id = t.id
+	 WHERE s.status IN ('Deployed', 'Launching', 'Launched', 'Running')
+	 AND t.launched IS NOT NULL AND t.max_duration IS NOT NULL
+	 AND t.launched + t.max_duration * INTERVAL '1 second' < $1
+	 ORDER BY t.launched`
+
+	rows, err := db.Query(sqlString, time.Now().UTC())
+	if err != nil {
+		err = fmt.Errorf("failed to query overdue tests: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tests []OverdueTest
+
+	for rows.Next() {
+		var test OverdueTest
+		var seconds int64
+		if err := rows.Scan(&test.ID, &test.GridID, &test.Launched, &seconds); err != nil {
+			err = fmt.Errorf("failed to scan overdue test: %v", err)
+			return tests, err
+		}
+		test.MaxDuration = time.Duration(seconds) * time.Second
+		tests = append(tests, test)
+	}
+
+	return tests, nil
+}
+
+// GetTestStatus returns the status of the test.
+func GetTestStatus(id string) (string, error) {
+	var status string
+	sqlString := `SELECT ts.status FROM portal.test t
+	INNER JOIN portal.test_status ts on ts.id = t.status_id
+	WHERE t.id=$1`
+
+	err := db.QueryRow(sqlString, id).Scan(&status)
+	if err != nil {
+		err = fmt.Errorf("unable to get the status of test %v: %v", id, err)
+		return status, err
+	}
+	return status, nil
+}
+
 func EditTestTitle(id string, title string) error {
 
 	sql := "UPDATE portal.test SET name = $2 WHERE id = $1"
@@ -741,9 +973,9 @@ func DeleteTest(id string) {
 
 func DuplicateTest(testID string) (string, error) {
 	var newID string
-	sql := `INSERT INTO portal.test (name, description, script_id, created_by_user, last_edited_user, status_id) 
-    SELECT concat('COPY of ', t.name), t.description, t.script_id, t.created_by_user, t.last_edited_user, s.id
-	FROM (SELECT name, description, script_id, status_id, created_by_user, last_edited_user FROM portal.test WHERE id=$1) as t
+	sql := `INSERT INTO portal.test (name, description, script_id, created_by_user, last_edited_user, status_id, load_profile, max_duration, thresholds) 
+    SELECT concat('COPY of ', t.name), t.description, t.script_id, t.created_by_user, t.last_edited_user, s.id, t.load_profile, t.max_duration, t.thresholds
+	FROM (SELECT name, description, script_id, status_id, created_by_user, last_edited_user, load_profile, max_duration, thresholds FROM portal.test WHERE id=$1) as t
 	CROSS JOIN
 	(SELECT id from portal.test_status WHERE status='Ready') as s
 	RETURNING id;`
@@ -893,7 +1125,7 @@ func GetTestsByStatus(statusList []string) ([]byte, error) {
 			stopped = "-"
 		}
 
-		tests = append(tests, Test{id, name, desc, status, nullStringToStringSlice(labels), result, created, launched, stopped, ""})
+		tests = append(tests, Test{id, name, desc, status, nullStringToStringSlice(labels), result, created, launched, stopped, "", "", ""})
 	}
 
 	if len(tests) == 0 {
@@ -908,21 +1140,37 @@ func GetTestsByStatus(statusList []string) ([]byte, error) {
 	return b, err
 }
 
-// RefreshTestStatus is used to make sure the test are in the correct status
-func RefreshTestStatus() error {
-	query := `UPDATE portal.test SET status_id = (SELECT id from portal.test_status WHERE status='Stopped')
-	          WHERE id IN (
-							SELECT t.id FROM portal.test t
-							INNER JOIN portal.test_status s 
-							ON t.status_id = s.id
-							WHERE t.status_id = (SELECT id from portal.test_status WHERE status='Deployed')
-							AND t.id NOT IN (
-								SELECT g.test_id FROM portal.grid g 
-								INNER JOIN portal.grid_status gs
-								ON g.status_id = gs.id
-								where g.status_id = (SELECT id FROM portal.grid_status WHERE status='Deployed')
-							)
-						)`
-	_, err := db.Exec(query)
-	return err
+// RefreshTestStatus is used to make sure the test are in the correct status. Deployed tests
+// whose grid no longer has a test deployed are stopped, the change is attributed to actor.
+func RefreshTestStatus(actor string) error {
+	query := `SELECT t.id FROM portal.test t
+				WHERE t.status_id = (SELECT id from portal.test_status WHERE status='Deployed')
+				AND t.id NOT IN (
+					SELECT g.test_id FROM portal.grid g
+					WHERE g.status_id = (SELECT id FROM portal.grid_status WHERE status='Deployed')
+					AND g.test_id IS NOT NULL
+				)`
+	rows, err := db.Query(query)
+	if err != nil {
+		return err
+	}
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		err = UpdateTestStatus(id, "Stopped", StatusChange{Actor: actor, Reason: "grid no longer has the test deployed"})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -1,10 +1,7 @@
 package ec2
 
 import (
-	"encoding/json"
 	"fmt"
-	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/db"
-	"net/http"
 	"os"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -12,99 +9,111 @@ import (
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
-	"github.com/julienschmidt/httprouter"
 )
 
-func GetMasterIP(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	fmt.Println("Getting master IP")
-	testID := ps.ByName("id")
-	type output struct {
-		Status      string
-		IP          string
-		Auth        string
-		Description string
-	}
-
-	cookie, err := r.Cookie("Authorization")
-	if err != nil {
-		_output := output{Status: "Failed", IP: "", Auth: "", Description: "Failed to get auth token from cookie."}
-		b, _ := json.Marshal(_output)
-		w.Write(b)
-		return
-	}
+// Instance is an EC2 instance that belongs to a grid.
+type Instance struct {
+	ID        string
+	Name      string
+	State     string
+	PrivateIP string
+	PublicIP  string
+}
 
+func newClient(region string) *ec2.EC2 {
 	cred := credentials.NewCredentials(&credentials.StaticProvider{Value: credentials.Value{
 		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY"),
 		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
 	}})
 
-	// step 1. use testID and get grid ID and grid region
-	gridID, gridRegion, err := db.GetGridByTestID(testID)
+	return ec2.New(session.New(&aws.Config{
+		Region:      aws.String(region),
+		Credentials: cred,
+	}))
+}
+
+func describeInstances(region string, filters []*ec2.Filter) ([]Instance, error) {
+	var instances []Instance
+
+	svc := newClient(region)
+	result, err := svc.DescribeInstances(&ec2.DescribeInstancesInput{Filters: filters})
 	if err != nil {
-		_output := output{Status: "Failed", IP: "", Auth: "", Description: err.Error()}
-		b, _ := json.Marshal(_output)
-		w.Write(b)
-		return
+		if aerr, ok := err.(awserr.Error); ok {
+			err = fmt.Errorf("failed to describe instances: %v", aerr.Error())
+			return instances, err
+		}
+		err = fmt.Errorf("failed to describe instances: %v", err)
+		return instances, err
 	}
 
-	svc := ec2.New(session.New(&aws.Config{
-		Region:      aws.String(gridRegion),
-		Credentials: cred,
-	}))
+	for _, reservation := range result.Reservations {
+		for _, instance := range reservation.Instances {
+			i := Instance{ID: aws.StringValue(instance.InstanceId)}
+			if instance.State != nil {
+				i.State = aws.StringValue(instance.State.Name)
+			}
+			i.PrivateIP = aws.StringValue(instance.PrivateIpAddress)
+			i.PublicIP = aws.StringValue(instance.PublicIpAddress)
+			for _, tag := range instance.Tags {
+				if aws.StringValue(tag.Key) == "Name" {
+					i.Name = aws.StringValue(tag.Value)
+				}
+			}
+			instances = append(instances, i)
+		}
+	}
+
+	return instances, nil
+}
+
+// GridInstances returns the instances that are tagged as part of the grid and
+// have not been terminated.
+func GridInstances(gridID string, region string) ([]Instance, error) {
+	filters := []*ec2.Filter{
+		{
+			Name:   aws.String("tag:Grid"),
+			Values: []*string{aws.String(gridID)},
+		},
+		{
+			Name:   aws.String("instance-state-name"),
+			Values: []*string{aws.String("pending"), aws.String("running"), aws.String("stopping"), aws.String("stopped")},
+		},
+	}
 
-	input := &ec2.DescribeInstancesInput{
-		Filters: []*ec2.Filter{
-			{
-				Name: aws.String("tag:Grid"),
-				Values: []*string{
-					aws.String(gridID),
-				},
+	return describeInstances(region, filters)
+}
+
+// MasterIP returns the public ip address of the running locust master for the grid.
+func MasterIP(gridID string, region string) (string, error) {
+	filters := []*ec2.Filter{
+		{
+			Name: aws.String("tag:Grid"),
+			Values: []*string{
+				aws.String(gridID),
 			},
-			{
-				Name: aws.String("tag:Name"),
-				Values: []*string{
-					aws.String("locust-master"),
-				},
+		},
+		{
+			Name: aws.String("tag:Name"),
+			Values: []*string{
+				aws.String("locust-master"),
 			},
-			{
-				Name: aws.String("instance-state-name"),
-				Values: []*string{
-					aws.String("running"),
-				},
+		},
+		{
+			Name: aws.String("instance-state-name"),
+			Values: []*string{
+				aws.String("running"),
 			},
 		},
 	}
 
-	result, err := svc.DescribeInstances(input)
+	instances, err := describeInstances(region, filters)
 	if err != nil {
-		var errorMessage string
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			default:
-				errorMessage = aerr.Error()
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			errorMessage = err.Error()
-		}
-		_output := output{Status: "Failed", IP: "", Auth: "", Description: errorMessage}
-		b, _ := json.Marshal(_output)
-		w.Write(b)
-		return
+		return "", err
 	}
 
-	if len(result.Reservations) > 0 {
-		if len(result.Reservations[0].Instances) > 0 {
-			ipAddress := *result.Reservations[0].Instances[0].PublicIpAddress
-			_output := output{Status: "Success", IP: ipAddress, Auth: cookie.Value, Description: "Call was a success."}
-			b, _ := json.Marshal(_output)
-			w.Write(b)
-			return
-		}
+	if len(instances) == 0 || instances[0].PublicIP == "" {
+		return "", fmt.Errorf("no matching IP addresses")
 	}
 
-	_output := output{Status: "Failed", IP: "", Auth: "", Description: "No matching IP addresses."}
-	b, _ := json.Marshal(_output)
-	w.Write(b)
+	return instances[0].PublicIP, nil
 }
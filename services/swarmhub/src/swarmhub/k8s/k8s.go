@@ -0,0 +1,457 @@
+package k8s
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strconv"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	masterBindPort = 9000
+	webPort        = 8089
+	proxyPort      = 443
+	locustDir      = "/locust"
+	// scriptsKey is where the zipped test scripts are kept in the config map of
+	// the grid, scriptsAnnotation the checksum of them on the pod template so
+	// the pods roll when they change.
+	scriptsKey        = "scripts.zip"
+	scriptsAnnotation = "swarmhub/scripts"
+)
+
+// MaxScriptsSize is the largest zip of test scripts that fits into the config map
+// of a grid, kubernetes refuses config maps over 1MiB.
+const MaxScriptsSize = 1000 * 1024
+
+// defaultLocustfile is loaded onto a grid until a test is deployed to it.
+const defaultLocustfile = `from locust import HttpLocust, TaskSet
+
+
+class WebsiteUser(HttpLocust):
+    task_set = TaskSet
+`
+
+// Sizes maps the instance types of the kubernetes provider to the resources
+// requested for the pod.
+var Sizes = map[string]corev1.ResourceList{
+	"small": {
+		corev1.ResourceCPU:    resource.MustParse("500m"),
+		corev1.ResourceMemory: resource.MustParse("512Mi"),
+	},
+	"medium": {
+		corev1.ResourceCPU:    resource.MustParse("1"),
+		corev1.ResourceMemory: resource.MustParse("1Gi"),
+	},
+	"large": {
+		corev1.ResourceCPU:    resource.MustParse("2"),
+		corev1.ResourceMemory: resource.MustParse("4Gi"),
+	},
+}
+
+// Client builds grids as a locust master Deployment and Service plus a
+// Deployment of locust workers. The namespace of a grid is its region.
+type Client struct {
+	Clientset kubernetes.Interface
+	// Image is the locust image used for the master and the workers.
+	Image string
+	// ProxyImage is the locust-go image, when set it runs next to the master
+	// so the locust UI can be reached the same way as on the other providers.
+	ProxyImage string
+}
+
+// Grid is the shape of a grid running inside kubernetes.
+type Grid struct {
+	ID         string
+	Namespace  string
+	MasterSize string
+	WorkerSize string
+	Workers    int32
+}
+
+// Node is a locust pod of a grid.
+type Node struct {
+	Name  string
+	Role  string
+	Phase string
+	IP    string
+}
+
+// NewInClusterClient creates a client using the service account swarmhub runs as.
+func NewInClusterClient(image string, proxyImage string) (*Client, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		err = fmt.Errorf("unable to load in cluster config: %v", err)
+		return nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		err = fmt.Errorf("unable to create clientset: %v", err)
+		return nil, err
+	}
+
+	return &Client{Clientset: clientset, Image: image, ProxyImage: proxyImage}, nil
+}
+
+func masterName(gridID string) string {
+	return "locust-master-" + gridID
+}
+
+func workerName(gridID string) string {
+	return "locust-worker-" + gridID
+}
+
+func configName(gridID string) string {
+	return "locust-" + gridID
+}
+
+func gridLabels(gridID string, role string) map[string]string {
+	return map[string]string{
+		"app":       "locust",
+		"grid":      gridID,
+		"component": role,
+	}
+}
+
+func gridSelector(gridID string) string {
+	return "app=locust,grid=" + gridID
+}
+
+// CreateGrid creates the config map, master and workers of the grid.
+func (c *Client) CreateGrid(grid Grid) error {
+	masterResources, ok := Sizes[grid.MasterSize]
+	if !ok {
+		return fmt.Errorf("unknown master size %v", grid.MasterSize)
+	}
+
+	workerResources, ok := Sizes[grid.WorkerSize]
+	if !ok {
+		return fmt.Errorf("unknown worker size %v", grid.WorkerSize)
+	}
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   configName(grid.ID),
+			Labels: gridLabels(grid.ID, "config"),
+		},
+		Data: map[string]string{"locustfile.py": defaultLocustfile},
+	}
+
+	_, err := c.Clientset.CoreV1().ConfigMaps(grid.Namespace).Create(configMap)
+	if err != nil {
+		err = fmt.Errorf("failed to create config map: %v", err)
+		return err
+	}
+
+	_, err = c.Clientset.CoreV1().Services(grid.Namespace).Create(c.masterService(grid))
+	if err != nil {
+		err = fmt.Errorf("failed to create master service: %v", err)
+		return err
+	}
+
+	masterArgs := []string{"--master", "--master-bind-port=" + strconv.Itoa(masterBindPort)}
+	master := c.deployment(grid.ID, masterName(grid.ID), "master", 1, masterResources, masterArgs)
+	if c.ProxyImage != "" {
+		addProxy(&master.Spec.Template.Spec)
+	}
+
+	_, err = c.Clientset.AppsV1().Deployments(grid.Namespace).Create(master)
+	if err != nil {
+		err = fmt.Errorf("failed to create master deployment: %v", err)
+		return err
+	}
+
+	workerArgs := []string{"--slave", "--master-host=" + masterName(grid.ID), "--master-port=" + strconv.Itoa(masterBindPort)}
+	worker := c.deployment(grid.ID, workerName(grid.ID), "worker", grid.Workers, workerResources, workerArgs)
+
+	_, err = c.Clientset.AppsV1().Deployments(grid.Namespace).Create(worker)
+	if err != nil {
+		err = fmt.Errorf("failed to create worker deployment: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+func (c *Client) masterService(grid Grid) *corev1.Service {
+	ports := []corev1.ServicePort{
+		{Name: "master", Port: masterBindPort, TargetPort: intstr.FromInt(masterBindPort)},
+		{Name: "master-next", Port: masterBindPort + 1, TargetPort: intstr.FromInt(masterBindPort + 1)},
+		{Name: "web", Port: webPort, TargetPort: intstr.FromInt(webPort)},
+	}
+	if c.ProxyImage != "" {
+		ports = append(ports, corev1.ServicePort{Name: "proxy", Port: proxyPort, TargetPort: intstr.FromInt(proxyPort)})
+	}
+
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   masterName(grid.ID),
+			Labels: gridLabels(grid.ID, "master"),
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: gridLabels(grid.ID, "master"),
+			Ports:    ports,
+		},
+	}
+}
+
+// deployment builds a locust deployment. An init container copies the default
+// locustfile into the shared volume and, when the config map of the grid holds
+// test scripts, unpacks them over it. The scripts stay in the config map, so pods
+// that restart get them again.
+func (c *Client) deployment(gridID string, name string, role string, replicas int32, resources corev1.ResourceList, args []string) *appsv1.Deployment {
+	labels := gridLabels(gridID, role)
+	locustArgs := "locust -f " + locustDir + "/locustfile.py"
+	for _, arg := range args {
+		locustArgs = locustArgs + " " + arg
+	}
+
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					InitContainers: []corev1.Container{
+						{
+							Name:    "scripts",
+							Image:   "busybox",
+							Command: []string{"sh", "-c"},
+							Args: []string{"cp /defaults/locustfile.py " + locustDir + "/ && " +
+								"if [ -f /defaults/" + scriptsKey + " ]; then unzip -o /defaults/" + scriptsKey + " -d " + locustDir + "; fi"},
+							VolumeMounts: []corev1.VolumeMount{
+								{Name: "defaults", MountPath: "/defaults"},
+								{Name: "scripts", MountPath: locustDir},
+							},
+						},
+					},
+					Containers: []corev1.Container{
+						{
+							Name:       "locust",
+							Image:      c.Image,
+							Command:    []string{"sh", "-c"},
+							Args:       []string{"if [ -f requirements.txt ]; then pip install -r requirements.txt; fi; exec " + locustArgs},
+							WorkingDir: locustDir,
+							Resources:  corev1.ResourceRequirements{Requests: resources, Limits: resources},
+							VolumeMounts: []corev1.VolumeMount{
+								{Name: "scripts", MountPath: locustDir},
+							},
+						},
+					},
+					Volumes: []corev1.Volume{
+						{
+							Name: "defaults",
+							VolumeSource: corev1.VolumeSource{
+								ConfigMap: &corev1.ConfigMapVolumeSource{
+									LocalObjectReference: corev1.LocalObjectReference{Name: configName(gridID)},
+								},
+							},
+						},
+						{
+							Name:         "scripts",
+							VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// addProxy runs locust-go next to the master with the jwt-key and tls secrets
+// swarmhub itself uses.
+func addProxy(spec *corev1.PodSpec) {
+	spec.Containers = append(spec.Containers, corev1.Container{
+		Name:       "locust-go",
+		WorkingDir: "/etc/locust-go",
+		Env: []corev1.EnvVar{
+			{Name: "TLS_CERT_FILE_LOC", Value: "/etc/tls/server.crt"},
+			{Name: "TLS_KEY_FILE_LOC", Value: "/etc/tls/server.key"},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: "jwt", MountPath: "/etc/locust-go"},
+			{Name: "tls", MountPath: "/etc/tls"},
+		},
+	})
+	spec.Volumes = append(spec.Volumes,
+		corev1.Volume{
+			Name: "jwt",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: "jwt-key",
+					Items:      []corev1.KeyToPath{{Key: "jwt-key", Path: "jwt"}},
+				},
+			},
+		},
+		corev1.Volume{
+			Name:         "tls",
+			VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "tls"}},
+		},
+	)
+}
+
+// GridReady reports if the master and all of the workers are available.
+func (c *Client) GridReady(namespace string, gridID string) (bool, error) {
+	for _, name := range []string{masterName(gridID), workerName(gridID)} {
+		deployment, err := c.Clientset.AppsV1().Deployments(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			err = fmt.Errorf("failed to get deployment %v: %v", name, err)
+			return false, err
+		}
+
+		var replicas int32 = 1
+		if deployment.Spec.Replicas != nil {
+			replicas = *deployment.Spec.Replicas
+		}
+
+		if deployment.Status.ObservedGeneration < deployment.Generation {
+			return false, nil
+		}
+
+		if deployment.Status.UpdatedReplicas < replicas || deployment.Status.AvailableReplicas < replicas {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// DeleteGrid removes everything that was created for the grid. Objects that are
+// already gone are skipped.
+func (c *Client) DeleteGrid(namespace string, gridID string) error {
+	propagation := metav1.DeletePropagationForeground
+	options := &metav1.DeleteOptions{PropagationPolicy: &propagation}
+
+	for _, name := range []string{workerName(gridID), masterName(gridID)} {
+		err := c.Clientset.AppsV1().Deployments(namespace).Delete(name, options)
+		if err != nil && !errors.IsNotFound(err) {
+			err = fmt.Errorf("failed to delete deployment %v: %v", name, err)
+			return err
+		}
+	}
+
+	err := c.Clientset.CoreV1().Services(namespace).Delete(masterName(gridID), options)
+	if err != nil && !errors.IsNotFound(err) {
+		err = fmt.Errorf("failed to delete service: %v", err)
+		return err
+	}
+
+	err = c.Clientset.CoreV1().ConfigMaps(namespace).Delete(configName(gridID), options)
+	if err != nil && !errors.IsNotFound(err) {
+		err = fmt.Errorf("failed to delete config map: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// Nodes lists the locust pods of the grid.
+func (c *Client) Nodes(namespace string, gridID string) ([]Node, error) {
+	var nodes []Node
+
+	pods, err := c.Clientset.CoreV1().Pods(namespace).List(metav1.ListOptions{LabelSelector: gridSelector(gridID)})
+	if err != nil {
+		err = fmt.Errorf("failed to list pods: %v", err)
+		return nodes, err
+	}
+
+	for _, pod := range pods.Items {
+		nodes = append(nodes, Node{Name: pod.Name, Role: pod.Labels["component"], Phase: string(pod.Status.Phase), IP: pod.Status.PodIP})
+	}
+
+	return nodes, nil
+}
+
+// MasterAddress returns the load balancer address of the master service when it
+// has one, otherwise the cluster dns name of the service.
+func (c *Client) MasterAddress(namespace string, gridID string) (string, error) {
+	service, err := c.Clientset.CoreV1().Services(namespace).Get(masterName(gridID), metav1.GetOptions{})
+	if err != nil {
+		err = fmt.Errorf("failed to get master service: %v", err)
+		return "", err
+	}
+
+	for _, ingress := range service.Status.LoadBalancer.Ingress {
+		if ingress.IP != "" {
+			return ingress.IP, nil
+		}
+		if ingress.Hostname != "" {
+			return ingress.Hostname, nil
+		}
+	}
+
+	return service.Name + "." + namespace + ".svc.cluster.local", nil
+}
+
+// MasterWebURL is where the web API of the master is reached from inside the cluster.
+func (c *Client) MasterWebURL(namespace string, gridID string) string {
+	return "http://" + masterName(gridID) + "." + namespace + ".svc.cluster.local:" + strconv.Itoa(webPort)
+}
+
+// SetScripts stores the zipped test scripts in the config map of the grid and
+// rolls the master and workers onto them. Nil scripts put the default locustfile
+// back.
+func (c *Client) SetScripts(namespace string, gridID string, scripts []byte) error {
+	if len(scripts) > MaxScriptsSize {
+		return fmt.Errorf("test scripts of %v bytes are larger than the %v bytes a grid can hold", len(scripts), MaxScriptsSize)
+	}
+
+	configMap, err := c.Clientset.CoreV1().ConfigMaps(namespace).Get(configName(gridID), metav1.GetOptions{})
+	if err != nil {
+		err = fmt.Errorf("failed to get config map: %v", err)
+		return err
+	}
+
+	checksum := ""
+	if scripts == nil {
+		delete(configMap.BinaryData, scriptsKey)
+	} else {
+		if configMap.BinaryData == nil {
+			configMap.BinaryData = map[string][]byte{}
+		}
+		configMap.BinaryData[scriptsKey] = scripts
+		checksum = fmt.Sprintf("%x", sha256.Sum256(scripts))
+	}
+
+	_, err = c.Clientset.CoreV1().ConfigMaps(namespace).Update(configMap)
+	if err != nil {
+		err = fmt.Errorf("failed to update config map: %v", err)
+		return err
+	}
+
+	for _, name := range []string{masterName(gridID), workerName(gridID)} {
+		deployment, err := c.Clientset.AppsV1().Deployments(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			err = fmt.Errorf("failed to get deployment %v: %v", name, err)
+			return err
+		}
+
+		annotations := deployment.Spec.Template.Annotations
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[scriptsAnnotation] = checksum
+		deployment.Spec.Template.Annotations = annotations
+
+		_, err = c.Clientset.AppsV1().Deployments(namespace).Update(deployment)
+		if err != nil {
+			err = fmt.Errorf("failed to update deployment %v: %v", name, err)
+			return err
+		}
+	}
+
+	return nil
+}
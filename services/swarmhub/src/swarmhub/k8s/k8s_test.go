@@ -0,0 +1,170 @@
+package k8s
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testNamespace = "swarmhub"
+
+func newTestClient(t *testing.T) *Client {
+	c := &Client{Clientset: fake.NewSimpleClientset(), Image: "locustio/locust"}
+	err := c.CreateGrid(Grid{ID: "g1", Namespace: testNamespace, MasterSize: "small", WorkerSize: "medium", Workers: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestCreateGrid(t *testing.T) {
+	c := newTestClient(t)
+
+	tests := []struct {
+		name     string
+		replicas int32
+	}{
+		{masterName("g1"), 1},
+		{workerName("g1"), 3},
+	}
+	for _, test := range tests {
+		deployment, err := c.Clientset.AppsV1().Deployments(testNamespace).Get(test.name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *deployment.Spec.Replicas != test.replicas {
+			t.Errorf("%v has %v replicas, want %v", test.name, *deployment.Spec.Replicas, test.replicas)
+		}
+	}
+
+	configMap, err := c.Clientset.CoreV1().ConfigMaps(testNamespace).Get(configName("g1"), metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configMap.Data["locustfile.py"] != defaultLocustfile {
+		t.Errorf("config map has no default locustfile")
+	}
+
+	_, err = c.Clientset.CoreV1().Services(testNamespace).Get(masterName("g1"), metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateGridUnknownSize(t *testing.T) {
+	c := &Client{Clientset: fake.NewSimpleClientset()}
+	err := c.CreateGrid(Grid{ID: "g1", Namespace: testNamespace, MasterSize: "huge", WorkerSize: "small", Workers: 1})
+	if err == nil {
+		t.Fatal("expected an error for an unknown size")
+	}
+}
+
+func TestSetScripts(t *testing.T) {
+	c := newTestClient(t)
+
+	tests := []struct {
+		scripts []byte
+		wantErr bool
+	}{
+		{[]byte("zip one"), false},
+		{[]byte("zip two"), false},
+		{nil, false},
+		{make([]byte, MaxScriptsSize+1), true},
+	}
+	previous := ""
+	for i, test := range tests {
+		err := c.SetScripts(testNamespace, "g1", test.scripts)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%v: expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%v: %v", i, err)
+		}
+
+		configMap, err := c.Clientset.CoreV1().ConfigMaps(testNamespace).Get(configName("g1"), metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored, ok := configMap.BinaryData[scriptsKey]
+		if ok != (test.scripts != nil) || string(stored) != string(test.scripts) {
+			t.Errorf("%v: config map holds %q, want %q", i, stored, test.scripts)
+		}
+
+		var checksums []string
+		for _, name := range []string{masterName("g1"), workerName("g1")} {
+			deployment, err := c.Clientset.AppsV1().Deployments(testNamespace).Get(name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			checksums = append(checksums, deployment.Spec.Template.Annotations[scriptsAnnotation])
+		}
+		if checksums[0] != checksums[1] {
+			t.Errorf("%v: master and workers have different scripts %v", i, checksums)
+		}
+		if test.scripts != nil && checksums[0] == previous {
+			t.Errorf("%v: pods are not rolled onto the new scripts", i)
+		}
+		if test.scripts == nil && checksums[0] != "" {
+			t.Errorf("%v: pods still point at scripts %v", i, checksums[0])
+		}
+		previous = checksums[0]
+	}
+}
+
+func TestGridReady(t *testing.T) {
+	tests := []struct {
+		name      string
+		available int32
+		observed  int64
+		want      bool
+	}{
+		{"no pods", 0, 1, false},
+		{"all pods", 3, 1, true},
+		{"old generation", 3, 0, false},
+	}
+	for _, test := range tests {
+		c := newTestClient(t)
+		for _, name := range []string{masterName("g1"), workerName("g1")} {
+			deployments := c.Clientset.AppsV1().Deployments(testNamespace)
+			deployment, err := deployments.Get(name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			deployment.Generation = 1
+			deployment.Status = appsv1.DeploymentStatus{ObservedGeneration: test.observed, UpdatedReplicas: test.available, AvailableReplicas: test.available}
+			_, err = deployments.Update(deployment)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		ready, err := c.GridReady(testNamespace, "g1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ready != test.want {
+			t.Errorf("%v: ready is %v, want %v", test.name, ready, test.want)
+		}
+	}
+}
+
+func TestDeleteGrid(t *testing.T) {
+	c := newTestClient(t)
+
+	for i := 0; i < 2; i++ {
+		err := c.DeleteGrid(testNamespace, "g1")
+		if err != nil {
+			t.Fatalf("delete %v: %v", i+1, err)
+		}
+	}
+
+	_, err := c.GridReady(testNamespace, "g1")
+	if err == nil {
+		t.Error("expected the deployments to be gone")
+	}
+}
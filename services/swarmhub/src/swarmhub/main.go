@@ -170,6 +170,9 @@ func TokenAuth(handler httprouter.Handle) httprouter.Handle {
 func main() {
 	ConfigSet()
 	api.StartNats(Registry)
+	go api.ExpireGrids()
+	go api.EnforceMaxDurations()
+	go api.RunSchedules()
 
 	router := httprouter.New()
 
@@ -186,7 +189,7 @@ func main() {
 	router.POST("/login", LoginPagePost)
 	router.POST("/logout", LogoutPost)
 
-	signalChan := make(chan os.Signal)
+	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP, syscall.SIGQUIT)
 	go api.Shutdown(signalChan)
 
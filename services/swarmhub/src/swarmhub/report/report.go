@@ -0,0 +1,183 @@
+// Package report renders the report of a test run as a single HTML page, with
+// its styles inline so it can be shared, archived or printed to PDF as is.
+package report
+
+import (
+	"html/template"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/db"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/common/locust"
+	"github.com/att-cloudnative-labs/swarmhub/services/common/operations"
+)
+
+// Report is what the report of a test shows. Grid, Profile, Stats and Result are
+// nil when the test has none.
+type Report struct {
+	Test    db.Test
+	Grid    *db.GridStruct
+	Profile *operations.LoadProfile
+	History []db.StatusHistoryEntry
+	Stats   *db.TestStats
+	Result  *db.DetailedResult
+	// Generated is when the report was rendered and by whom.
+	Generated   string
+	GeneratedBy string
+}
+
+var page = template.Must(template.New("report").Funcs(template.FuncMap{
+	"number":     number,
+	"percentile": percentile,
+	"percent":    func(s locust.RequestStats) string { return number(s.FailRatio() * 100) },
+}).Parse(pageTemplate))
+
+// Render writes the report as HTML.
+func Render(w io.Writer, r Report) error {
+	return page.Execute(w, r)
+}
+
+// number rounds to two decimals.
+func number(v float64) string {
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64)
+}
+
+// percentile returns a percentile of the response times like "95", or - when
+// locust didn't report it.
+func percentile(s locust.RequestStats, p string) string {
+	v, ok := s.Percentiles[p]
+	if !ok {
+		return "-"
+	}
+	return number(v)
+}
+
+const pageTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Test.Name}} - swarmhub report</title>
+<style>
+  body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; color: #222; margin: 2em auto; max-width: 1100px; padding: 0 1em; font-size: 14px; }
+  h1 { margin-bottom: 0.2em; }
+  h2 { border-bottom: 1px solid #ccc; padding-bottom: 0.2em; margin-top: 1.6em; }
+  table { border-collapse: collapse; width: 100%; margin: 0.5em 0; }
+  th, td { border: 1px solid #ddd; padding: 4px 8px; text-align: left; vertical-align: top; }
+  th { background: #f4f4f4; }
+  td.num { text-align: right; font-variant-numeric: tabular-nums; }
+  dl { display: grid; grid-template-columns: max-content auto; gap: 4px 16px; }
+  dt { font-weight: bold; }
+  dd { margin: 0; }
+  .meta { color: #666; }
+  .label { display: inline-block; background: #e8eef7; border-radius: 3px; padding: 0 6px; margin-right: 4px; }
+  .Pass, .passed { color: #1a7f37; font-weight: bold; }
+  .Fail, .failed { color: #c62828; font-weight: bold; }
+  .Partial { color: #b26a00; font-weight: bold; }
+  tr.total td { font-weight: bold; }
+  section { page-break-inside: avoid; }
+  @page { size: A4 landscape; margin: 1.5cm; }
+  @media print { body { margin: 0; max-width: none; } a { color: inherit; } }
+</style>
+</head>
+<body>
+<h1>{{.Test.Name}}</h1>
+<p class="meta">Report of test {{.Test.ID}}, generated {{.Generated}}{{with .GeneratedBy}} by {{.}}{{end}}.</p>
+
+<section>
+<h2>Test</h2>
+<dl>
+  <dt>Description</dt><dd>{{.Test.Desc}}</dd>
+  <dt>Status</dt><dd>{{.Test.Status}}{{with .Test.StopReason}} ({{.}}){{end}}</dd>
+  <dt>Result</dt><dd>{{with .Test.Result}}<span class="{{.}}">{{.}}</span>{{else}}-{{end}}</dd>
+  <dt>Labels</dt><dd>{{range .Test.Labels}}<span class="label">{{.}}</span>{{else}}-{{end}}</dd>
+  <dt>Created</dt><dd>{{.Test.Created}}</dd>
+  <dt>Launched</dt><dd>{{with .Test.Launched}}{{.}}{{else}}-{{end}}</dd>
+  <dt>Stopped</dt><dd>{{with .Test.Stopped}}{{.}}{{else}}-{{end}}</dd>
+  {{with .Test.MaxDuration}}<dt>Maximum duration</dt><dd>{{.}}</dd>{{end}}
+  <dt>Grafana snapshot</dt><dd>{{with .Test.SnapshotURL}}<a href="{{.}}">{{.}}</a>{{else}}-{{end}}</dd>
+</dl>
+</section>
+
+<section>
+<h2>Grid</h2>
+{{with .Grid}}
+<dl>
+  <dt>Name</dt><dd>{{.Name}}</dd>
+  <dt>Provider</dt><dd>{{.Provider}} {{.Region}}</dd>
+  <dt>Master</dt><dd>{{.Master}}</dd>
+  <dt>Slaves</dt><dd>{{.Nodes}} x {{.Slave}}</dd>
+  <dt>TTL</dt><dd>{{.TTL}}</dd>
+</dl>
+{{else}}<p>The test was not run on a grid.</p>{{end}}
+</section>
+
+<section>
+<h2>Load profile</h2>
+{{with .Profile}}
+{{if .Stages}}
+<table>
+  <tr><th>Users</th><th>Spawn rate</th><th>Duration</th></tr>
+  {{range .Stages}}<tr><td class="num">{{.Users}}</td><td class="num">{{number .SpawnRate}}/s</td><td>{{.Duration}}</td></tr>
+  {{end}}
+</table>
+{{else}}
+<p>{{.Users}} users spawned at {{number .SpawnRate}}/s{{with .Duration}} for {{.}}{{else}} until stopped{{end}}.</p>
+{{end}}
+{{else}}<p>Started by hand in the locust UI.</p>{{end}}
+</section>
+
+<section>
+<h2>Status timeline</h2>
+<table>
+  <tr><th>Changed</th><th>From</th><th>To</th><th>Duration</th><th>By</th><th>Reason</th></tr>
+  {{range .History}}<tr><td>{{.Changed}}</td><td>{{.From}}</td><td>{{.To}}</td><td>{{.Duration}}</td><td>{{.Actor}}</td><td>{{.Reason}}</td></tr>
+  {{else}}<tr><td colspan="6">No status changes were recorded.</td></tr>
+  {{end}}
+</table>
+</section>
+
+<section>
+<h2>Statistics</h2>
+{{with .Stats}}
+<p class="meta">Collected {{.Collected}}. Response times in milliseconds.</p>
+<table>
+  <tr><th>Endpoint</th><th>Requests</th><th>Failures</th><th>Failure %</th><th>RPS</th><th>Avg</th><th>Median</th><th>Min</th><th>Max</th><th>p95</th><th>p99</th></tr>
+  {{range .Requests}}<tr><td>{{.Method}} {{.Name}}</td><td class="num">{{.Requests}}</td><td class="num">{{.Failures}}</td><td class="num">{{percent .}}</td><td class="num">{{number .RPS}}</td><td class="num">{{number .AvgResponseTime}}</td><td class="num">{{number .MedianResponseTime}}</td><td class="num">{{number .MinResponseTime}}</td><td class="num">{{number .MaxResponseTime}}</td><td class="num">{{percentile . "95"}}</td><td class="num">{{percentile . "99"}}</td></tr>
+  {{end}}
+  {{with .Total}}<tr class="total"><td>All requests</td><td class="num">{{.Requests}}</td><td class="num">{{.Failures}}</td><td class="num">{{percent .}}</td><td class="num">{{number .RPS}}</td><td class="num">{{number .AvgResponseTime}}</td><td class="num">{{number .MedianResponseTime}}</td><td class="num">{{number .MinResponseTime}}</td><td class="num">{{number .MaxResponseTime}}</td><td class="num">{{percentile . "95"}}</td><td class="num">{{percentile . "99"}}</td></tr>{{end}}
+</table>
+{{else}}<p>No statistics were collected for this test.</p>{{end}}
+</section>
+
+<section>
+<h2>Evaluation</h2>
+{{with .Result}}
+{{with .Evaluation}}
+<p>{{with .Result}}<span class="{{.}}">{{.}}</span>, {{end}}{{.Passed}} of {{len .Rules}} thresholds passed.</p>
+<table>
+  <tr><th>Threshold</th><th>Value</th><th>Outcome</th></tr>
+  {{range .Rules}}<tr><td>{{.Description}}</td><td class="num">{{if .Error}}-{{else}}{{number .Value}}{{end}}</td><td>{{if .Passed}}<span class="passed">passed</span>{{else}}<span class="failed">failed</span>{{with .Error}}: {{.}}{{end}}{{end}}</td></tr>
+  {{end}}
+</table>
+{{else}}<p>The test has no thresholds.</p>{{end}}
+{{with .Baseline}}
+<h3>Baseline</h3>
+{{if .Error}}<p>Not compared to baseline {{.BaselineTestID}}: {{.Error}}.</p>
+{{else}}
+<p>Compared to baseline {{.BaselineTestID}} with a tolerance of {{number .Tolerance}}%: {{if .Regressions}}<span class="failed">{{.Regressions}} regressions</span>{{else}}<span class="passed">no regressions</span>{{end}}.</p>
+{{if .Regressions}}
+<table>
+  <tr><th>Endpoint</th><th>Metric</th><th>Baseline</th><th>Value</th><th>Change</th></tr>
+  {{range .Regressed}}<tr><td>{{with .Endpoint}}{{.}}{{else}}All requests{{end}}</td><td>{{.Metric}}</td><td class="num">{{number .Baseline}}</td><td class="num">{{number .Value}}</td><td class="num">{{with .DeltaPercent}}{{number .}}%{{else}}-{{end}}</td></tr>
+  {{end}}
+</table>
+{{end}}
+{{end}}
+{{end}}
+{{else}}<p>The test has no thresholds and no baseline.</p>{{end}}
+</section>
+</body>
+</html>
+`
@@ -0,0 +1,174 @@
+// Package slo evaluates the threshold rules of a test against the statistics of
+// its locust master, which decides if the test passed.
+package slo
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/common/locust"
+)
+
+// The results of an evaluation, the rows of portal.test_results.
+const (
+	Pass    = "Pass"
+	Partial = "Partial"
+	Fail    = "Fail"
+)
+
+// metrics are the metrics a rule can check besides the percentiles, which are
+// given like p95 or p99.9. Response times are in milliseconds, failure_ratio is
+// the percentage of requests that failed and rps the average requests per second.
+var metrics = map[string]func(locust.RequestStats) float64{
+	"avg":           func(s locust.RequestStats) float64 { return s.AvgResponseTime },
+	"median":        func(s locust.RequestStats) float64 { return s.MedianResponseTime },
+	"min":           func(s locust.RequestStats) float64 { return s.MinResponseTime },
+	"max":           func(s locust.RequestStats) float64 { return s.MaxResponseTime },
+	"failure_ratio": func(s locust.RequestStats) float64 { return s.FailRatio() * 100 },
+	"rps":           func(s locust.RequestStats) float64 { return s.RPS },
+	"requests":      func(s locust.RequestStats) float64 { return float64(s.Requests) },
+	"failures":      func(s locust.RequestStats) float64 { return float64(s.Failures) },
+}
+
+var operators = map[string]func(value float64, threshold float64) bool{
+	"<":  func(v, t float64) bool { return v < t },
+	"<=": func(v, t float64) bool { return v <= t },
+	">":  func(v, t float64) bool { return v > t },
+	">=": func(v, t float64) bool { return v >= t },
+}
+
+// Rule is a threshold a metric of the test has to meet, e.g. p95 < 250.
+type Rule struct {
+	// Endpoint is a request as locust names it, optionally with its method like
+	// "GET /login". Without it the rule applies to all requests together.
+	Endpoint  string `json:",omitempty"`
+	Metric    string
+	Operator  string
+	Threshold float64
+}
+
+func (r Rule) Validate() error {
+	if _, ok := operators[r.Operator]; !ok {
+		return fmt.Errorf("Operator must be one of <, <=, > and >=, got %q", r.Operator)
+	}
+	if _, ok := metrics[r.Metric]; ok {
+		return nil
+	}
+	if _, ok := percentile(r.Metric); ok {
+		return nil
+	}
+	return fmt.Errorf("unknown Metric %q, use a percentile like p95, avg, median, min, max, failure_ratio, rps, requests or failures", r.Metric)
+}
+
+func (r Rule) String() string {
+	endpoint := "all requests"
+	if r.Endpoint != "" {
+		endpoint = r.Endpoint
+	}
+	return fmt.Sprintf("%v of %v %v %v", r.Metric, endpoint, r.Operator, strconv.FormatFloat(r.Threshold, 'f', -1, 64))
+}
+
+// percentile returns the key of a metric like p95 in locust.RequestStats.Percentiles.
+func percentile(metric string) (string, bool) {
+	if !strings.HasPrefix(metric, "p") {
+		return "", false
+	}
+	p, err := strconv.ParseFloat(metric[1:], 64)
+	if err != nil || p <= 0 || p > 100 {
+		return "", false
+	}
+	return strconv.FormatFloat(p, 'f', -1, 64), true
+}
+
+// value returns the metric of the rule from the statistics.
+func (r Rule) value(stats locust.Stats) (float64, error) {
+	s, err := stats.Endpoint(r.Endpoint)
+	if err != nil {
+		return 0, err
+	}
+	if s.Requests == 0 {
+		return 0, fmt.Errorf("no requests were made")
+	}
+	return MetricValue(s, r.Metric)
+}
+
+// MetricValue returns a metric a rule can check, like p95 or rps, from the
+// statistics of an endpoint.
+func MetricValue(s locust.RequestStats, metric string) (float64, error) {
+	if m, ok := metrics[metric]; ok {
+		return m(s), nil
+	}
+	key, ok := percentile(metric)
+	if !ok {
+		return 0, fmt.Errorf("unknown metric %q", metric)
+	}
+	v, ok := s.Percentiles[key]
+	if !ok {
+		return 0, fmt.Errorf("locust doesn't report the %v percentile", key)
+	}
+	return v, nil
+}
+
+// ValidateRules checks every rule of a test.
+func ValidateRules(rules []Rule) error {
+	for i, rule := range rules {
+		err := rule.Validate()
+		if err != nil {
+			return fmt.Errorf("rule %v: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// RuleResult is the outcome of a rule. Error is why the metric could not be
+// found, the rule failed then.
+type RuleResult struct {
+	Rule
+	Description string
+	Value       float64
+	Passed      bool
+	Error       string `json:",omitempty"`
+}
+
+// Evaluation is the outcome of the rules of a test, it is kept as the detailed
+// result of the test.
+type Evaluation struct {
+	Result string
+	Passed int
+	Failed int
+	Rules  []RuleResult
+}
+
+// Evaluate checks the rules against the statistics. The test passes when all
+// rules passed, fails when none did and is a partial success otherwise.
+func Evaluate(rules []Rule, stats locust.Stats) Evaluation {
+	evaluation := Evaluation{Rules: []RuleResult{}}
+	for _, rule := range rules {
+		result := RuleResult{Rule: rule, Description: rule.String()}
+		value, err := rule.value(stats)
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Value = value
+			result.Passed = operators[rule.Operator](value, rule.Threshold)
+		}
+
+		if result.Passed {
+			evaluation.Passed++
+		} else {
+			evaluation.Failed++
+		}
+		evaluation.Rules = append(evaluation.Rules, result)
+	}
+
+	switch {
+	case evaluation.Failed == 0:
+		evaluation.Result = Pass
+	case evaluation.Passed == 0:
+		evaluation.Result = Fail
+	default:
+		evaluation.Result = Partial
+	}
+	return evaluation
+}
@@ -0,0 +1,119 @@
+package slo
+
+import (
+	"testing"
+
+	"github.com/att-cloudnative-labs/swarmhub/services/common/locust"
+)
+
+var testStats = locust.Stats{
+	Requests: []locust.RequestStats{
+		{Method: "GET", Name: "/", Requests: 1000, Failures: 10, AvgResponseTime: 120, MedianResponseTime: 100, MaxResponseTime: 900, RPS: 50, Percentiles: map[string]float64{"95": 300, "99": 600, "99.9": 850}},
+		{Method: "POST", Name: "/login", Requests: 200, Failures: 0, AvgResponseTime: 250, MedianResponseTime: 200, MaxResponseTime: 1200, RPS: 10},
+		{Method: "GET", Name: "/login", Requests: 100, Failures: 0, AvgResponseTime: 80},
+		{Method: "GET", Name: "/idle"},
+	},
+	Total: locust.RequestStats{Name: "Aggregated", Requests: 1300, Failures: 10, AvgResponseTime: 150, MedianResponseTime: 110, RPS: 65, Percentiles: map[string]float64{"95": 400}},
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		rule  Rule
+		valid bool
+	}{
+		{Rule{Metric: "p95", Operator: "<", Threshold: 250}, true},
+		{Rule{Metric: "p99.9", Operator: "<=", Threshold: 250}, true},
+		{Rule{Metric: "rps", Operator: ">=", Threshold: 10}, true},
+		{Rule{Metric: "failure_ratio", Operator: "<", Threshold: 1}, true},
+		{Rule{Metric: "p0", Operator: "<", Threshold: 1}, false},
+		{Rule{Metric: "p101", Operator: "<", Threshold: 1}, false},
+		{Rule{Metric: "latency", Operator: "<", Threshold: 1}, false},
+		{Rule{Metric: "avg", Operator: "==", Threshold: 1}, false},
+	}
+	for _, test := range tests {
+		err := test.rule.Validate()
+		if (err == nil) != test.valid {
+			t.Errorf("%v: got %v, want valid %v", test.rule, err, test.valid)
+		}
+	}
+}
+
+func TestEvaluateRules(t *testing.T) {
+	tests := []struct {
+		rule    Rule
+		value   float64
+		passed  bool
+		wantErr bool
+	}{
+		{Rule{Metric: "p95", Operator: "<", Threshold: 500}, 400, true, false},
+		{Rule{Metric: "p95", Operator: "<", Threshold: 400}, 400, false, false},
+		{Rule{Metric: "p95", Operator: "<=", Threshold: 400}, 400, true, false},
+		{Rule{Endpoint: "GET /", Metric: "p99.9", Operator: "<", Threshold: 900}, 850, true, false},
+		{Rule{Endpoint: "GET /", Metric: "failure_ratio", Operator: "<", Threshold: 1}, 1, false, false},
+		{Rule{Endpoint: "/", Metric: "rps", Operator: ">", Threshold: 40}, 50, true, false},
+		{Rule{Endpoint: "POST /login", Metric: "avg", Operator: "<", Threshold: 200}, 250, false, false},
+		{Rule{Metric: "requests", Operator: ">=", Threshold: 1300}, 1300, true, false},
+		// locust doesn't report percentiles of this endpoint
+		{Rule{Endpoint: "POST /login", Metric: "p95", Operator: "<", Threshold: 500}, 0, false, true},
+		// requested with two methods
+		{Rule{Endpoint: "/login", Metric: "avg", Operator: "<", Threshold: 500}, 0, false, true},
+		{Rule{Endpoint: "GET /missing", Metric: "avg", Operator: "<", Threshold: 500}, 0, false, true},
+		{Rule{Endpoint: "GET /idle", Metric: "avg", Operator: "<", Threshold: 500}, 0, false, true},
+	}
+	for _, test := range tests {
+		evaluation := Evaluate([]Rule{test.rule}, testStats)
+		if len(evaluation.Rules) != 1 {
+			t.Fatalf("%v: got %v results", test.rule, len(evaluation.Rules))
+		}
+		result := evaluation.Rules[0]
+		if result.Passed != test.passed || result.Value != test.value || (result.Error != "") != test.wantErr {
+			t.Errorf("%v: got passed %v, value %v, error %q", test.rule, result.Passed, result.Value, result.Error)
+		}
+		if result.Description != test.rule.String() {
+			t.Errorf("%v: description is %q", test.rule, result.Description)
+		}
+	}
+}
+
+func TestEvaluateResult(t *testing.T) {
+	pass := Rule{Metric: "avg", Operator: "<", Threshold: 1000}
+	fail := Rule{Metric: "avg", Operator: ">", Threshold: 1000}
+	broken := Rule{Endpoint: "GET /missing", Metric: "avg", Operator: "<", Threshold: 1000}
+
+	tests := []struct {
+		name   string
+		rules  []Rule
+		result string
+		passed int
+		failed int
+	}{
+		{"all passed", []Rule{pass, pass}, Pass, 2, 0},
+		{"none passed", []Rule{fail, broken}, Fail, 0, 2},
+		{"some passed", []Rule{pass, fail, broken}, Partial, 1, 2},
+		{"no rules", nil, Pass, 0, 0},
+	}
+	for _, test := range tests {
+		evaluation := Evaluate(test.rules, testStats)
+		if evaluation.Result != test.result || evaluation.Passed != test.passed || evaluation.Failed != test.failed {
+			t.Errorf("%v: got %v with %v passed and %v failed", test.name, evaluation.Result, evaluation.Passed, evaluation.Failed)
+		}
+		if evaluation.Rules == nil {
+			t.Errorf("%v: rules are nil, they are kept as json", test.name)
+		}
+	}
+}
+
+func TestRuleString(t *testing.T) {
+	tests := []struct {
+		rule Rule
+		want string
+	}{
+		{Rule{Metric: "p95", Operator: "<", Threshold: 250}, "p95 of all requests < 250"},
+		{Rule{Endpoint: "GET /login", Metric: "failure_ratio", Operator: "<=", Threshold: 0.5}, "failure_ratio of GET /login <= 0.5"},
+	}
+	for _, test := range tests {
+		if got := test.rule.String(); got != test.want {
+			t.Errorf("got %q, want %q", got, test.want)
+		}
+	}
+}
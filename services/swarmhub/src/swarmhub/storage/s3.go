@@ -1,8 +1,10 @@
 package storage
 
 import (
+	"bytes"
 	"fmt"
 	"mime/multipart"
+	"time"
 
 	"github.com/att-cloudnative-labs/swarmhub/services/swarmhub/src/swarmhub/db"
 
@@ -17,6 +19,8 @@ var (
 	s3sess               *session.Session
 	bucket               string
 	ServerSideEncryption = "AES256"
+	// Endpoint overrides the s3 endpoint, used for s3 compatible storage such as minio.
+	Endpoint string
 )
 
 // SetS3 is used to set the aws session and bucket name for s3 access
@@ -29,11 +33,17 @@ func SetS3(accessKey, secretAccessKey, region, bucketName string) error {
 		AccessKeyID:     accessKey,
 		SecretAccessKey: secretAccessKey,
 	}})
-	var err error
-	s3sess, err = session.NewSession(&aws.Config{
+	config := &aws.Config{
 		Region:      aws.String(region),
 		Credentials: s3creds,
-	})
+	}
+	if Endpoint != "" {
+		config.Endpoint = aws.String(Endpoint)
+		config.S3ForcePathStyle = aws.Bool(true)
+	}
+
+	var err error
+	s3sess, err = session.NewSession(config)
 	if err != nil {
 		err = fmt.Errorf("unable to create aws session for s3: %v", err)
 		return err
@@ -41,19 +51,19 @@ func SetS3(accessKey, secretAccessKey, region, bucketName string) error {
 	return nil
 }
 
-// UploadScript is used for uploading test scripts
-func UploadScript(testid string, scriptid string, zipFileName string, file multipart.File) error {
+// UploadScript is used for uploading test scripts, the status changes are attributed to user.
+func UploadScript(testid string, scriptid string, zipFileName string, file multipart.File, user string) error {
 	uploadName := "scripts/" + scriptid + "/file/" + zipFileName
 	fmt.Println("Uploading", uploadName)
-	db.UpdateTestStatus(testid, "Uploading")
+	db.UpdateTestStatus(testid, "Uploading", db.StatusChange{Actor: user, Reason: "uploading " + zipFileName})
 	err := uploadObject(file, uploadName)
 	if err != nil {
 		fmt.Println("Failed to upload", uploadName)
-		db.UpdateTestStatus(testid, "Upload Failed")
+		db.UpdateTestStatus(testid, "Upload Failed", db.StatusChange{Actor: user, Reason: err.Error()})
 		return err
 	}
 	fmt.Println("Finished uploading", uploadName)
-	db.UpdateTestStatus(testid, "Ready")
+	db.UpdateTestStatus(testid, "Ready", db.StatusChange{Actor: user, Reason: "upload finished"})
 	return err
 }
 
@@ -70,6 +80,24 @@ func DownloadScript(scriptid string, zipFileName string) (*aws.WriteAtBuffer, er
 	return buff, err
 }
 
+// ScriptURL returns a presigned url that test scripts can be downloaded from
+// without s3 credentials, it is valid for the given duration.
+func ScriptURL(scriptid string, zipFileName string, expires time.Duration) (string, error) {
+	downloadName := "scripts/" + scriptid + "/file/" + zipFileName
+	svc := s3.New(s3sess)
+	req, _ := svc.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(downloadName),
+	})
+
+	url, err := req.Presign(expires)
+	if err != nil {
+		err = fmt.Errorf("unable to presign %v: %v", downloadName, err)
+		return "", err
+	}
+	return url, nil
+}
+
 // UploadAttachment is used for uploading attachments for a test.
 func UploadAttachment(testid string, attachmentName string, file multipart.File) error {
 	uploadName := "attachments/" + testid + "/file/" + attachmentName
@@ -108,6 +136,50 @@ func DeleteAttachment(testid string, attachmentName string) error {
 	return nil
 }
 
+// UploadDeploymentLogs is used to archive the logs of a deployment once it has finished.
+func UploadDeploymentLogs(id string, logs []byte) error {
+	uploadName := "deploylogs/" + id + "/file/deploylogs.json"
+	fmt.Println("Uploading", uploadName)
+	err := uploadBytes(logs, uploadName)
+	if err != nil {
+		fmt.Println("Failed to upload", uploadName)
+		return err
+	}
+	fmt.Println("Finished uploading", uploadName)
+	return nil
+}
+
+// DownloadDeploymentLogs is used to download the archived logs of a deployment.
+func DownloadDeploymentLogs(id string) (*aws.WriteAtBuffer, error) {
+	downloadName := "deploylogs/" + id + "/file/deploylogs.json"
+	fmt.Println("Downloading", downloadName)
+	buff, err := downloadObjectBuffer(downloadName)
+	if err != nil {
+		fmt.Println("Failed to download", downloadName)
+		return buff, err
+	}
+	fmt.Println("Finished downloading", downloadName)
+	return buff, err
+}
+
+func uploadBytes(data []byte, uploadName string) error {
+	uploader := s3manager.NewUploader(s3sess)
+
+	_, err := uploader.Upload(&s3manager.UploadInput{
+		Bucket:               aws.String(bucket),
+		ServerSideEncryption: aws.String(ServerSideEncryption),
+		Key:                  aws.String(uploadName),
+		Body:                 bytes.NewReader(data),
+	})
+	if err != nil {
+		fmt.Println("Unable to upload", err)
+		return err
+	}
+
+	fmt.Printf("Successfully uploaded %q to %q\n", uploadName, bucket)
+	return nil
+}
+
 func uploadObject(file multipart.File, uploadName string) error {
 	defer file.Close()
 
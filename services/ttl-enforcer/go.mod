@@ -1,11 +1,11 @@
 module github.com/att-cloudnative-labs/swarmhub/services/ttl-enforcer
 
 require (
+	github.com/att-cloudnative-labs/swarmhub/services/common v0.0.0
 	github.com/aws/aws-sdk-go v1.16.11
-	github.com/nats-io/go-nats v1.7.2 // indirect
-	github.com/nats-io/go-nats-streaming v0.4.4
-	github.com/nats-io/nats.go v1.8.1
-	github.com/nats-io/stan.go v0.5.0
+	github.com/nats-io/nats.go v1.16.0 // indirect
 	github.com/prometheus/client_golang v0.9.3
 	github.com/spf13/viper v1.4.0
 )
+
+replace github.com/att-cloudnative-labs/swarmhub/services/common => ../common
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
@@ -9,31 +8,42 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/att-cloudnative-labs/swarmhub/services/common/bus"
+	"github.com/att-cloudnative-labs/swarmhub/services/common/events"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
-	stan "github.com/nats-io/go-nats-streaming"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/viper"
 )
 
 var (
-	natsUsername  string
-	natsPassword  string
-	natsURL       string
-	stanClusterID string
-	sc            stan.Conn
-	regions       []string
-	sleep         time.Duration
+	natsUsername string
+	natsPassword string
+	natsURL      string
+	messageBus   bus.Bus
+	regions      []string
+	sleep        time.Duration
 )
 
-type natsMessage struct {
-	ID             string
-	DeploymentType string
-	Region         string
-	Status         string
+// actor is who the events of ttl-enforcer are attributed to.
+const actor = "ttl-enforcer"
+
+// gridProvider is implemented for every grid provider whose machines ttl-enforcer
+// is responsible for tearing down.
+type gridProvider interface {
+	// DeleteGrid removes the machines of a grid that was deleted in swarmhub.
+	DeleteGrid(region string, gridID string)
+	// DeleteExpiredGrids removes the machines that have outlived their TTL.
+	DeleteExpiredGrids()
 }
 
+// gridProviders maps the name of the provider in portal.providers to its implementation.
+type gridProviders map[string]gridProvider
+
+// defaultProvider is used for messages that were published without a provider.
+const defaultProvider = "AWS"
+
 type ec2instance struct {
 	ID   string
 	Grid string
@@ -53,16 +63,18 @@ func main() {
 	setConfig()
 	createNatsConnection()
 
-	sessions := createEC2Connections(regions)
+	providers := gridProviders{
+		"AWS": createEC2Connections(regions),
+	}
 	go func() {
 		for {
-			sessions.DeleteExpiredInstances()
+			providers.DeleteExpiredGrids()
 			time.Sleep(60 * 5 * time.Second)
 		}
 
 	}()
 
-	go sessions.SubscribeForDeletions()
+	go providers.SubscribeForDeletions()
 
 	http.Handle("/metrics", promhttp.Handler())
 	fmt.Println("Prometheus metrics started.")
@@ -92,7 +104,6 @@ func setConfig() {
 		natsURL = "nats://" + natsUsername + ":" + natsPassword + "@" + natsURLRaw
 	}
 
-	stanClusterID = registry.GetString("STAN_CLUSTER_ID")
 	regions = registry.GetStringSlice("AWS_REGIONS")
 	if len(regions) == 0 {
 		panic("No regions to monitor!")
@@ -104,19 +115,58 @@ func setConfig() {
 	}
 }
 
-func (s ec2sessions) terminationHandler(msg *stan.Msg) {
-	var natsMsg natsMessage
+func (p gridProviders) terminationHandler(msg *bus.Msg) {
 	fmt.Println("terminationHanlder message:", string(msg.Data))
 
-	err := json.Unmarshal(msg.Data, &natsMsg)
+	event, err := events.Decode(msg.Data)
 	if err != nil {
-		fmt.Println("Failed to unmarshal msg.Data", err.Error())
+		fmt.Println("Failed to decode msg.Data", err.Error())
+		return
+	}
+
+	gridStatus, ok := event.(*events.GridStatusChanged)
+	if !ok || gridStatus.Status != "Deleting" {
+		return
+	}
+
+	providerName := gridStatus.Provider
+	if providerName == "" {
+		providerName = defaultProvider
+	}
+
+	provider, ok := p[providerName]
+	if !ok {
+		fmt.Printf("Not deleting grid %v, provider %v is not handled by ttl-enforcer.\n", gridStatus.GridID, providerName)
 		return
 	}
 
-	if natsMsg.DeploymentType == "Grid" && (natsMsg.Status == "Deleting") {
-		go s.deleteGrid(natsMsg.Region, natsMsg.ID, 1)
+	go provider.DeleteGrid(gridStatus.Region, gridStatus.GridID)
+}
+
+// DeleteExpiredGrids asks every provider to remove its expired grids.
+func (p gridProviders) DeleteExpiredGrids() {
+	for _, provider := range p {
+		provider.DeleteExpiredGrids()
+	}
+}
+
+// SubscribeForDeletions is used to delete grids that were manually deleted
+// in the UI of the tool instead of waiting for the TTL.
+func (p gridProviders) SubscribeForDeletions() {
+	if messageBus == nil {
+		fmt.Println("Not subscribing for deletions, there is no nats connection.")
+		return
 	}
+	sub, err := messageBus.Subscribe("deployer.status", p.terminationHandler, bus.DeliverNew())
+	if err != nil {
+		fmt.Println("Failed to Subscribe to nats topic", err.Error())
+	}
+	_ = sub
+}
+
+// DeleteGrid terminates every instance that is tagged with the grid.
+func (s ec2sessions) DeleteGrid(region string, gridID string) {
+	s.deleteGrid(region, gridID, 1)
 }
 
 func (s ec2sessions) deleteGrid(region string, gridID string, tryCount int) {
@@ -174,25 +224,15 @@ func (s ec2sessions) deleteGrid(region string, gridID string, tryCount int) {
 	fmt.Printf("Finished checking for instances to delete for Region: %v, Grid: %v\n", region, gridID)
 }
 
-// NatsDeletions is used to delete instances that were manually deleted
-// in the UI of the tool instead of waiting for the TTL.
-func (s ec2sessions) SubscribeForDeletions() {
-	sub, err := sc.Subscribe("deployer.status", s.terminationHandler)
-	if err != nil {
-		fmt.Println("Failed to Subscribe to nats topic", err.Error())
-	}
-	_ = sub
-}
-
 func (s ec2sessions) getExpiredInstances() {
 	for _, session := range s.sessions {
 		session.getExpiredInstances()
 	}
 }
 
-// DeleteExpiredInstances goes through the list of EC2 instances and terminates the instances
+// DeleteExpiredGrids goes through the list of EC2 instances and terminates the instances
 // that are expired based on TTL.
-func (s ec2sessions) DeleteExpiredInstances() {
+func (s ec2sessions) DeleteExpiredGrids() {
 	for _, session := range s.sessions {
 		session.deleteExpiredInstances()
 	}
@@ -226,8 +266,21 @@ func (s ec2session) deleteExpiredInstances() error {
 }
 
 func (s ec2session) publishNatsMessage(region string, gridID string, status string) {
-	message := fmt.Sprintf(`{"ID": "%v", "Region": "%v", "DeploymentType": "Grid", "Status": "%v"}`, gridID, region, status)
-	sc.Publish("deployer.status", []byte(message))
+	event := &events.GridStatusChanged{GridID: gridID, Status: status, Region: region, Reason: statusReasons[status]}
+	if messageBus == nil {
+		fmt.Println("Not publishing, there is no nats connection:", gridID, status)
+		return
+	}
+	err := events.Publish(messageBus, actor, event)
+	if err != nil {
+		fmt.Println("Failed to publish grid status:", err.Error())
+	}
+}
+
+// statusReasons explains the statuses ttl-enforcer sets.
+var statusReasons = map[string]string{
+	"Deleted": "Instances of the grid were terminated.",
+	"Expired": "The TTL of the grid ran out.",
 }
 
 func (s ec2session) publishNatsMessagesFromEC2List(instances []ec2instance, status string) {
@@ -319,16 +372,17 @@ func (s ec2session) getExpiredInstances() ([]ec2instance, error) {
 
 func createNatsConnection() {
 	hostname, _ := os.Hostname()
-	var err error
-	sc, err = stan.Connect(stanClusterID, hostname, stan.NatsURL(natsURL))
+	natsBus, err := bus.Connect(natsURL, hostname)
 	if err != nil {
-		fmt.Println("Failed to connect to nats streaming cluster: ", err.Error())
+		fmt.Println("Failed to connect to nats jetstream: ", err.Error())
 		// Sleeping 60 seconds in order for the ec2 delete expired instances to be able to run.
 		go func() {
 			time.Sleep(60 * time.Second)
 			os.Exit(2)
 		}()
+		return
 	}
+	messageBus = natsBus
 }
 
 func createEC2Connections(regions []string) ec2sessions {